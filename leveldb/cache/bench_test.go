@@ -7,6 +7,7 @@
 package cache
 
 import (
+	"math/rand"
 	"sync/atomic"
 	"testing"
 )
@@ -199,3 +200,70 @@ func BenchmarkCacheParallel_Delete(b *testing.B) {
 	b.ReportMetric(float64(c.Nodes()), "nodes")
 	b.Logf("STATS: %#v", c.GetStats())
 }
+
+// Workloads of the cacher benchmarks, returning the key of the i-th access.
+var cacherWorkloads = []struct {
+	name string
+	new  func() func(i int) uint64
+}{
+	// Accesses following a Zipf distribution over 100k keys.
+	{"Skewed", func() func(i int) uint64 {
+		z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 100000)
+		return func(int) uint64 { return z.Uint64() }
+	}},
+	// Skewed accesses interleaved with long scans of keys accessed once.
+	{"Scan", func() func(i int) uint64 {
+		z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 100000)
+		return func(i int) uint64 {
+			if i%4096 < 2048 {
+				return 1<<32 + uint64(i)
+			}
+			return z.Uint64()
+		}
+	}},
+}
+
+func BenchmarkCacher_HitRatio(b *testing.B) {
+	for _, w := range cacherWorkloads {
+		for _, x := range cachers {
+			b.Run(w.name+"/"+x.name, func(b *testing.B) {
+				c := NewCache(x.new(10000))
+				next := w.new()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					key := next(i)
+					c.Get(0, key, func() (int, Value) {
+						return 1, key
+					}).Release()
+				}
+				b.StopTimer()
+				stats := c.GetStats()
+				b.ReportMetric(float64(stats.HitCount)/float64(stats.HitCount+stats.MissCount), "hit-ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkCacherParallel_Hit(b *testing.B) {
+	for _, x := range cachers {
+		b.Run(x.name, func(b *testing.B) {
+			c := NewCache(x.new(10000))
+			for i := 0; i < 10000; i++ {
+				c.Get(0, uint64(i), func() (int, Value) {
+					return 1, i
+				}).Release()
+			}
+
+			var seed int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				next := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+				for pb.Next() {
+					if h := c.Get(0, uint64(next.Intn(10000)), nil); h != nil {
+						h.Release()
+					}
+				}
+			})
+		})
+	}
+}
@@ -35,6 +35,26 @@ type Cacher interface {
 	Evict(n *Node)
 }
 
+// PriorityCacher is the interface of cachers that reserve part of their
+// capacity for high-priority 'cache nodes'.
+type PriorityCacher interface {
+	Cacher
+
+	// SetHighPriorityRatio sets the fraction of the capacity reserved for
+	// high-priority 'cache nodes'. Low-priority 'cache nodes' can't evict
+	// high-priority ones as long as they fit in the reserved capacity.
+	SetHighPriorityRatio(ratio float64)
+}
+
+// Priority is the priority class of a 'cache node', see PriorityCacher.
+type Priority int
+
+// Priority classes.
+const (
+	LowPriority Priority = iota
+	HighPriority
+)
+
 // Value is a 'cache-able object'. It may implements util.Releaser, if
 // so the the Release method will be called once object is released.
 type Value interface{}
@@ -50,6 +70,11 @@ func (g *NamespaceGetter) Get(key uint64, setFunc func() (size int, value Value)
 	return g.Cache.Get(g.NS, key, setFunc)
 }
 
+// GetWithPriority simply calls Cache.GetWithPriority() method.
+func (g *NamespaceGetter) GetWithPriority(key uint64, priority Priority, setFunc func() (size int, value Value)) *Handle {
+	return g.Cache.GetWithPriority(g.NS, key, priority, setFunc)
+}
+
 // The hash tables implementation is based on:
 // "Dynamic-Sized Nonblocking Hash Tables", by Yujie Liu,
 // Kunlong Zhang, and Michael Spear.
@@ -232,6 +257,9 @@ func (b *mBucket) delete(r *Cache, h *mHead, hash uint32, ns, key uint64) (done,
 
 		// Update counter.
 		atomic.AddInt64(&r.statSize, int64(n.size)*-1)
+		if s := r.scope(n.ns); s != nil {
+			atomic.AddInt64(&s.size, int64(n.size)*-1)
+		}
 		shrink := atomic.AddInt64(&r.statNodes, -1) < h.shrinkThreshold
 		if bLen >= mOverflowThreshold {
 			atomic.AddInt32(&h.overflow, -1)
@@ -374,6 +402,10 @@ type Cache struct {
 	statMiss   int64
 	statSet    int64
 	statDel    int64
+
+	// Scopes by ID, see NewScope.
+	scopes  sync.Map
+	scopeID uint64
 }
 
 // NewCache creates a new 'cache map'. The cacher is optional and
@@ -468,6 +500,12 @@ func (r *Cache) SetCapacity(capacity int) {
 // The returned 'cache handle' should be released after use by calling Release
 // method.
 func (r *Cache) Get(ns, key uint64, setFunc func() (size int, value Value)) *Handle {
+	return r.GetWithPriority(ns, key, LowPriority, setFunc)
+}
+
+// GetWithPriority is like Get, but a 'cache node' created by setFunc is given
+// the priority class.
+func (r *Cache) GetWithPriority(ns, key uint64, priority Priority, setFunc func() (size int, value Value)) *Handle {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	if r.closed {
@@ -501,8 +539,12 @@ func (r *Cache) Get(ns, key uint64, setFunc func() (size int, value Value)) *Han
 						n.unRefInternal(false)
 						return nil
 					}
+					n.priority = priority
 					atomic.AddInt64(&r.statSet, 1)
 					atomic.AddInt64(&r.statSize, int64(n.size))
+					if s := r.scope(ns); s != nil {
+						atomic.AddInt64(&s.size, int64(n.size))
+					}
 				}
 				n.mu.Unlock()
 				if r.cacher != nil {
@@ -669,6 +711,75 @@ func (r *Cache) Close(force bool) {
 	}
 }
 
+// The namespaces of a scope have the scope ID in their high bits, below
+// them are the namespaces given to Scope.NS.
+const scopeShift = 40
+
+// Scope is a range of namespaces of a 'cache map', with its own size
+// accounting. It allows multiple users, such as DB instances, to share a
+// 'cache map' without namespace collisions.
+type Scope struct {
+	r    *Cache
+	id   uint64
+	size int64
+}
+
+// NewScope creates a new scope of the 'cache map'. The scope should be
+// closed once no longer used.
+func (r *Cache) NewScope() *Scope {
+	s := &Scope{r: r, id: atomic.AddUint64(&r.scopeID, 1)}
+	r.scopes.Store(s.id, s)
+	return s
+}
+
+// Returns the scope of the given namespace, or nil if the namespace
+// doesn't belong to any scope.
+func (r *Cache) scope(ns uint64) *Scope {
+	if ns>>scopeShift == 0 {
+		return nil
+	}
+	if s, ok := r.scopes.Load(ns >> scopeShift); ok {
+		return s.(*Scope)
+	}
+	return nil
+}
+
+// Cache returns the 'cache map' of the scope.
+func (s *Scope) Cache() *Cache {
+	return s.r
+}
+
+// NS returns the namespace of the 'cache map' that corresponds to the
+// given namespace within the scope. The given namespace must be less
+// than 2^40.
+func (s *Scope) NS(ns uint64) uint64 {
+	return s.id<<scopeShift | ns
+}
+
+// Size returns sums of 'cache node' size within the scope.
+func (s *Scope) Size() int {
+	return int(atomic.LoadInt64(&s.size))
+}
+
+// Close evicts all 'cache node' within the scope, the 'cache map' itself
+// is left open. Nodes still referenced are no longer accounted in the
+// scope size once released.
+func (s *Scope) Close() {
+	r := s.r
+	r.mu.RLock()
+	if !r.closed && r.cacher != nil {
+		r.enumerateNodesWithCB(func(nodes []*Node) {
+			for _, n := range nodes {
+				if n.ns>>scopeShift == s.id {
+					r.cacher.Evict(n)
+				}
+			}
+		})
+	}
+	r.mu.RUnlock()
+	r.scopes.Delete(s.id)
+}
+
 // Node is a 'cache node'.
 type Node struct {
 	r *Cache
@@ -676,9 +787,10 @@ type Node struct {
 	hash    uint32
 	ns, key uint64
 
-	mu    sync.Mutex
-	size  int
-	value Value
+	mu       sync.Mutex
+	size     int
+	value    Value
+	priority Priority
 
 	ref      int32
 	delFuncs []func()
@@ -701,6 +813,11 @@ func (n *Node) Size() int {
 	return n.size
 }
 
+// Priority returns this 'cache node' priority class.
+func (n *Node) Priority() Priority {
+	return n.priority
+}
+
 // Value returns this 'cache node' value.
 func (n *Node) Value() Value {
 	return n.value
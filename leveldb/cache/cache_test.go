@@ -457,6 +457,54 @@ func TestLRUCache_Eviction(t *testing.T) {
 	}
 }
 
+func TestLRUCache_HighPriority(t *testing.T) {
+	r := NewLRU(10)
+	r.(PriorityCacher).SetHighPriorityRatio(0.4)
+	c := NewCache(r)
+	setPriority := func(key uint64, priority Priority) {
+		c.GetWithPriority(0, key, priority, func() (int, Value) {
+			return 1, int(key)
+		}).Release()
+	}
+	present := func(key uint64) bool {
+		h := c.Get(0, key, nil)
+		if h == nil {
+			return false
+		}
+		h.Release()
+		return true
+	}
+
+	// High-priority nodes fitting in the reserved capacity survive a scan
+	// of low-priority nodes.
+	for key := uint64(1); key <= 5; key++ {
+		setPriority(key, HighPriority)
+	}
+	for key := uint64(100); key < 200; key++ {
+		setPriority(key, LowPriority)
+	}
+	for key := uint64(1); key <= 5; key++ {
+		// The least recently used one was moved to the low-priority list.
+		if got, want := present(key), key > 1; got != want {
+			t.Errorf("key %d: present=%v, want %v", key, got, want)
+		}
+	}
+	if got, want := c.Size(), 10; got != want {
+		t.Errorf("size: got %d, want %d", got, want)
+	}
+
+	// Without reserved capacity, the nodes are evicted as usual.
+	r.(PriorityCacher).SetHighPriorityRatio(0)
+	for key := uint64(200); key < 210; key++ {
+		setPriority(key, LowPriority)
+	}
+	for key := uint64(2); key <= 5; key++ {
+		if present(key) {
+			t.Errorf("key %d: still present", key)
+		}
+	}
+}
+
 func TestLRUCache_Evict(t *testing.T) {
 	lru := NewLRU(6).(*lru)
 	c := NewCache(lru)
@@ -531,6 +579,61 @@ func TestLRUCache_Delete(t *testing.T) {
 	require.Equal(t, 4, delFuncCalled)
 }
 
+func TestCache_Scope(t *testing.T) {
+	c := NewCache(NewLRU(100))
+	s1, s2 := c.NewScope(), c.NewScope()
+	set := func(s *Scope, key uint64, size int) {
+		c.Get(s.NS(1), key, func() (int, Value) {
+			return size, int(key)
+		}).Release()
+	}
+	present := func(s *Scope, key uint64) bool {
+		h := c.Get(s.NS(1), key, nil)
+		if h == nil {
+			return false
+		}
+		h.Release()
+		return true
+	}
+
+	// The same namespace and key doesn't collide across scopes.
+	set(s1, 1, 10)
+	set(s1, 2, 10)
+	set(s2, 1, 5)
+	if v := c.Get(s2.NS(1), 1, nil); v == nil || v.Value() != 1 || c.Nodes() != 3 {
+		t.Fatalf("scoped node: got %v, nodes %d", v, c.Nodes())
+	} else {
+		v.Release()
+	}
+	if got, want := s1.Size(), 20; got != want {
+		t.Errorf("scope 1 size: got %d, want %d", got, want)
+	}
+	if got, want := s2.Size(), 5; got != want {
+		t.Errorf("scope 2 size: got %d, want %d", got, want)
+	}
+	if got, want := c.Size(), 25; got != want {
+		t.Errorf("cache size: got %d, want %d", got, want)
+	}
+
+	// Evicted nodes are no longer accounted.
+	c.Evict(s1.NS(1), 2)
+	if got, want := s1.Size(), 10; got != want {
+		t.Errorf("scope 1 size after evict: got %d, want %d", got, want)
+	}
+
+	// Closing a scope only evicts its own nodes.
+	s1.Close()
+	if present(s1, 1) {
+		t.Error("node of the closed scope still present")
+	}
+	if !present(s2, 1) {
+		t.Error("node of the other scope evicted")
+	}
+	if got, want := c.Size(), 5; got != want {
+		t.Errorf("cache size after close: got %d, want %d", got, want)
+	}
+}
+
 func TestLRUCache_Close(t *testing.T) {
 	relFuncCalled := 0
 	relFunc := func() {
@@ -554,3 +657,101 @@ func TestLRUCache_Close(t *testing.T) {
 	require.Equal(t, 3, relFuncCalled)
 	require.Equal(t, 1, delFuncCalled)
 }
+
+var cachers = []struct {
+	name string
+	new  func(capacity int) Cacher
+}{
+	{"LRU", NewLRU},
+	{"CLOCK", NewCLOCK},
+	{"TinyLFU", NewTinyLFU},
+}
+
+func TestCachers_Capacity(t *testing.T) {
+	for _, x := range cachers {
+		t.Run(x.name, func(t *testing.T) {
+			c := NewCache(x.new(100))
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(int64(i)))
+					for j := 0; j < 10000; j++ {
+						key := uint64(rnd.Intn(500))
+						switch rnd.Intn(10) {
+						case 0:
+							c.Delete(0, key, nil)
+						case 1:
+							c.Evict(0, key)
+						default:
+							set(c, 0, key, key, 1+int(key%3), nil).Release()
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			require.LessOrEqual(t, c.Size(), 100)
+
+			c.EvictAll()
+			require.Zero(t, c.Nodes())
+			require.Zero(t, c.Size())
+
+			// A banned node is never cached.
+			h := set(c, 0, 1, 1, 1, nil)
+			require.True(t, c.Delete(0, 1, nil))
+			h.Release()
+			require.Nil(t, c.Get(0, 1, nil))
+			set(c, 0, 1, 1, 1, nil).Release()
+			h = c.Get(0, 1, nil)
+			require.NotNil(t, h)
+			h.Release()
+
+			c.SetCapacity(0)
+			require.Zero(t, c.Nodes())
+		})
+	}
+}
+
+func TestCLOCKCache_SecondChance(t *testing.T) {
+	c := NewCache(NewCLOCK(4))
+	for key := uint64(1); key <= 4; key++ {
+		set(c, 0, key, key, 1, nil).Release()
+	}
+	// Referenced nodes survive the next sweep.
+	for _, key := range []uint64{1, 3} {
+		c.Get(0, key, nil).Release()
+	}
+	set(c, 0, 5, 5, 1, nil).Release()
+	set(c, 0, 6, 6, 1, nil).Release()
+	for key, want := range map[uint64]bool{1: true, 2: false, 3: true, 4: false, 5: true, 6: true} {
+		h := c.Get(0, key, nil)
+		require.Equalf(t, want, h != nil, "key %d", key)
+		if h != nil {
+			h.Release()
+		}
+	}
+}
+
+func TestTinyLFUCache_ScanResistance(t *testing.T) {
+	c := NewCache(NewTinyLFU(200))
+	// Access a hot set repeatedly.
+	for i := 0; i < 10; i++ {
+		for key := uint64(0); key < 100; key++ {
+			set(c, 0, key, key, 1, nil).Release()
+		}
+	}
+	// A scan of one-hit nodes doesn't evict the hot set.
+	for key := uint64(1000); key < 11000; key++ {
+		set(c, 0, key, key, 1, nil).Release()
+	}
+	var hot int
+	for key := uint64(0); key < 100; key++ {
+		if h := c.Get(0, key, nil); h != nil {
+			hot++
+			h.Release()
+		}
+	}
+	require.GreaterOrEqual(t, hot, 95)
+	require.LessOrEqual(t, c.Size(), 200)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+type clockNode struct {
+	n   *Node
+	h   *Handle
+	ban bool
+	// Set on access, cleared by the clock hand.
+	referenced uint32
+
+	next, prev *clockNode
+}
+
+// The CLOCK keeps the 'cache nodes' in a ring swept by a clock hand. A hit
+// only sets the referenced bit of the node, without taking the lock. The
+// hand gives a second chance to referenced nodes, and evicts the first
+// unreferenced one.
+type clock struct {
+	mu       sync.Mutex
+	capacity int
+	used     int
+	nodes    int
+	hand     *clockNode
+}
+
+func (r *clock) Capacity() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capacity
+}
+
+func (r *clock) SetCapacity(capacity int) {
+	r.mu.Lock()
+	r.capacity = capacity
+	evicted := r.evict()
+	r.mu.Unlock()
+
+	for _, cn := range evicted {
+		cn.h.Release()
+	}
+}
+
+// Inserts the node behind the hand, so that it is the last one swept.
+func (r *clock) insert(cn *clockNode) {
+	if r.hand == nil {
+		cn.next, cn.prev = cn, cn
+		r.hand = cn
+	} else {
+		cn.next = r.hand
+		cn.prev = r.hand.prev
+		cn.prev.next = cn
+		r.hand.prev = cn
+	}
+	r.nodes++
+}
+
+func (r *clock) remove(cn *clockNode) {
+	if cn.next == nil {
+		panic("BUG: removing removed node")
+	}
+	if cn.next == cn {
+		r.hand = nil
+	} else {
+		if r.hand == cn {
+			r.hand = cn.next
+		}
+		cn.prev.next = cn.next
+		cn.next.prev = cn.prev
+	}
+	cn.next, cn.prev = nil, nil
+	r.nodes--
+}
+
+// Sweeps the ring until the used size fits in the capacity, and returns
+// the evicted nodes. Their handle must be released once the lock is
+// released. Nodes are evicted regardless of their referenced bit after two
+// full sweeps, so that concurrent hits can't keep the hand spinning.
+func (r *clock) evict() (evicted []*clockNode) {
+	for steps := 0; r.used > r.capacity; steps++ {
+		cn := r.hand
+		if cn == nil {
+			panic("BUG: invalid CLOCK used or capacity counter")
+		}
+		if atomic.LoadUint32(&cn.referenced) != 0 && steps < 2*r.nodes {
+			atomic.StoreUint32(&cn.referenced, 0)
+			r.hand = cn.next
+			continue
+		}
+		r.remove(cn)
+		atomic.StorePointer(&cn.n.CacheData, nil)
+		r.used -= cn.n.Size()
+		evicted = append(evicted, cn)
+	}
+	return
+}
+
+func (r *clock) Promote(n *Node) {
+	if cn := (*clockNode)(atomic.LoadPointer(&n.CacheData)); cn != nil {
+		if atomic.LoadUint32(&cn.referenced) == 0 {
+			atomic.StoreUint32(&cn.referenced, 1)
+		}
+		return
+	}
+
+	var evicted []*clockNode
+
+	r.mu.Lock()
+	if n.CacheData == nil && n.Size() <= r.capacity {
+		cn := &clockNode{n: n, h: n.GetHandle()}
+		r.insert(cn)
+		atomic.StorePointer(&n.CacheData, unsafe.Pointer(cn))
+		r.used += n.Size()
+		evicted = r.evict()
+	}
+	r.mu.Unlock()
+
+	for _, cn := range evicted {
+		cn.h.Release()
+	}
+}
+
+func (r *clock) Ban(n *Node) {
+	r.mu.Lock()
+	cn := (*clockNode)(n.CacheData)
+	if cn == nil {
+		atomic.StorePointer(&n.CacheData, unsafe.Pointer(&clockNode{n: n, ban: true}))
+	} else if !cn.ban {
+		r.remove(cn)
+		cn.ban = true
+		r.used -= n.Size()
+		r.mu.Unlock()
+
+		cn.h.Release()
+		cn.h = nil
+		return
+	}
+	r.mu.Unlock()
+}
+
+func (r *clock) Evict(n *Node) {
+	r.mu.Lock()
+	cn := (*clockNode)(n.CacheData)
+	if cn == nil || cn.ban {
+		r.mu.Unlock()
+		return
+	}
+	r.remove(cn)
+	r.used -= n.Size()
+	atomic.StorePointer(&n.CacheData, nil)
+	r.mu.Unlock()
+
+	cn.h.Release()
+}
+
+// NewCLOCK creates a new CLOCK-cache. Unlike the LRU-cache, cache hits
+// don't take its lock.
+func NewCLOCK(capacity int) Cacher {
+	return &clock{capacity: capacity}
+}
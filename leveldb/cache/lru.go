@@ -12,9 +12,10 @@ import (
 )
 
 type lruNode struct {
-	n   *Node
-	h   *Handle
-	ban bool
+	n    *Node
+	h    *Handle
+	ban  bool
+	high bool
 
 	next, prev *lruNode
 }
@@ -38,17 +39,27 @@ func (n *lruNode) remove() {
 	}
 }
 
+// The LRU keeps high-priority 'cache nodes' in their own list, up to the
+// reserved capacity. Beyond it, the least recently used ones are moved to
+// the head of the low-priority list. Nodes are evicted from the tail of the
+// low-priority list first.
 type lru struct {
-	mu       sync.Mutex
-	capacity int
-	used     int
-	recent   lruNode
+	mu        sync.Mutex
+	capacity  int
+	used      int
+	highRatio float64
+	highUsed  int
+	recent    lruNode
+	high      lruNode
 }
 
 func (r *lru) reset() {
 	r.recent.next = &r.recent
 	r.recent.prev = &r.recent
+	r.high.next = &r.high
+	r.high.prev = &r.high
 	r.used = 0
+	r.highUsed = 0
 }
 
 func (r *lru) Capacity() int {
@@ -58,24 +69,82 @@ func (r *lru) Capacity() int {
 }
 
 func (r *lru) SetCapacity(capacity int) {
-	var evicted []*lruNode
-
 	r.mu.Lock()
 	r.capacity = capacity
+	r.balance()
+	evicted := r.evict()
+	r.mu.Unlock()
+
+	for _, rn := range evicted {
+		rn.h.Release()
+	}
+}
+
+func (r *lru) SetHighPriorityRatio(ratio float64) {
+	r.mu.Lock()
+	r.highRatio = ratio
+	r.balance()
+	r.mu.Unlock()
+}
+
+// Moves the least recently used high-priority nodes to the low-priority
+// list, until they fit in the reserved capacity.
+func (r *lru) balance() {
+	highCapacity := int(float64(r.capacity) * r.highRatio)
+	for r.highUsed > highCapacity {
+		rn := r.high.prev
+		if rn == &r.high {
+			panic("BUG: invalid LRU high-priority used counter")
+		}
+		rn.remove()
+		rn.insert(&r.recent)
+		rn.high = false
+		r.highUsed -= rn.n.Size()
+	}
+}
+
+// Removes the least recently used nodes until the used size fits in the
+// capacity, and returns them. Their handle must be released once the lock
+// is released.
+func (r *lru) evict() (evicted []*lruNode) {
 	for r.used > r.capacity {
 		rn := r.recent.prev
-		if rn == nil {
+		if rn == &r.recent {
+			rn = r.high.prev
+		}
+		if rn == nil || rn == &r.high {
 			panic("BUG: invalid LRU used or capacity counter")
 		}
 		rn.remove()
 		rn.n.CacheData = nil
 		r.used -= rn.n.Size()
+		if rn.high {
+			r.highUsed -= rn.n.Size()
+		}
 		evicted = append(evicted, rn)
 	}
-	r.mu.Unlock()
+	return
+}
 
-	for _, rn := range evicted {
-		rn.h.Release()
+// Inserts the node at the head of the list of its priority.
+func (r *lru) push(rn *lruNode) {
+	if rn.n.Priority() == HighPriority {
+		rn.insert(&r.high)
+		if !rn.high {
+			rn.high = true
+			r.highUsed += rn.n.Size()
+		}
+		r.balance()
+	} else {
+		rn.insert(&r.recent)
+	}
+}
+
+func (r *lru) unlink(rn *lruNode) {
+	rn.remove()
+	if rn.high {
+		rn.high = false
+		r.highUsed -= rn.n.Size()
 	}
 }
 
@@ -86,26 +155,16 @@ func (r *lru) Promote(n *Node) {
 	if n.CacheData == nil {
 		if n.Size() <= r.capacity {
 			rn := &lruNode{n: n, h: n.GetHandle()}
-			rn.insert(&r.recent)
 			n.CacheData = unsafe.Pointer(rn)
 			r.used += n.Size()
-
-			for r.used > r.capacity {
-				rn := r.recent.prev
-				if rn == nil {
-					panic("BUG: invalid LRU used or capacity counter")
-				}
-				rn.remove()
-				rn.n.CacheData = nil
-				r.used -= rn.n.Size()
-				evicted = append(evicted, rn)
-			}
+			r.push(rn)
+			evicted = r.evict()
 		}
 	} else {
 		rn := (*lruNode)(n.CacheData)
 		if !rn.ban {
 			rn.remove()
-			rn.insert(&r.recent)
+			r.push(rn)
 		}
 	}
 	r.mu.Unlock()
@@ -122,7 +181,7 @@ func (r *lru) Ban(n *Node) {
 	} else {
 		rn := (*lruNode)(n.CacheData)
 		if !rn.ban {
-			rn.remove()
+			r.unlink(rn)
 			rn.ban = true
 			r.used -= rn.n.Size()
 			r.mu.Unlock()
@@ -142,7 +201,7 @@ func (r *lru) Evict(n *Node) {
 		r.mu.Unlock()
 		return
 	}
-	rn.remove()
+	r.unlink(rn)
 	r.used -= n.Size()
 	n.CacheData = nil
 	r.mu.Unlock()
@@ -150,7 +209,9 @@ func (r *lru) Evict(n *Node) {
 	rn.h.Release()
 }
 
-// NewLRU create a new LRU-cache.
+// NewLRU create a new LRU-cache. The returned cacher implements
+// PriorityCacher, no capacity is reserved for high-priority 'cache nodes'
+// by default.
 func NewLRU(capacity int) Cacher {
 	r := &lru{capacity: capacity}
 	r.reset()
@@ -0,0 +1,311 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// The segments of the W-TinyLFU.
+const (
+	segWindow = iota
+	segProbation
+	segProtected
+)
+
+const (
+	tinyLFUWindowRatio    = 0.01
+	tinyLFUProtectedRatio = 0.8
+
+	sketchDepth      = 4
+	sketchMinWidth   = 1 << 10
+	sketchMaxCounter = 15
+	// The counters are halved after sketchSampleFactor times the width
+	// increments, so that the frequencies reflect recent accesses.
+	sketchSampleFactor = 10
+)
+
+// A count-min sketch of the access frequency of the 'cache nodes'.
+type sketch struct {
+	width     uint32
+	counters  []uint8
+	additions int
+}
+
+func (s *sketch) init(width uint32) {
+	s.width = width
+	s.counters = make([]uint8, sketchDepth*int(width))
+	s.additions = 0
+}
+
+func (s *sketch) index(i int, ns, key uint64) int {
+	return i*int(s.width) + int(murmur32(ns, key, uint32(i)*0x9e3779b9)&(s.width-1))
+}
+
+func (s *sketch) increment(ns, key uint64) {
+	for i := 0; i < sketchDepth; i++ {
+		if x := s.index(i, ns, key); s.counters[x] < sketchMaxCounter {
+			s.counters[x]++
+		}
+	}
+	if s.additions++; s.additions >= sketchSampleFactor*int(s.width) {
+		for x := range s.counters {
+			s.counters[x] /= 2
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *sketch) frequency(ns, key uint64) uint8 {
+	f := uint8(sketchMaxCounter)
+	for i := 0; i < sketchDepth; i++ {
+		if c := s.counters[s.index(i, ns, key)]; c < f {
+			f = c
+		}
+	}
+	return f
+}
+
+type tinyLFUNode struct {
+	n   *Node
+	h   *Handle
+	ban bool
+	seg int
+
+	next, prev *tinyLFUNode
+}
+
+func (n *tinyLFUNode) insert(at *tinyLFUNode) {
+	x := at.next
+	at.next = n
+	n.prev = at
+	n.next = x
+	x.prev = n
+}
+
+func (n *tinyLFUNode) remove() {
+	if n.prev != nil {
+		n.prev.next = n.next
+		n.next.prev = n.prev
+		n.prev = nil
+		n.next = nil
+	} else {
+		panic("BUG: removing removed node")
+	}
+}
+
+// The W-TinyLFU admits new 'cache nodes' into a small LRU window. The
+// nodes leaving the window enter the main segmented LRU only if they are
+// accessed more frequently than the nodes they would evict, as estimated by
+// a count-min sketch. The main LRU has a probation and a protected segment,
+// a node is protected once hit on probation. This keeps scans and one-hit
+// nodes from evicting the frequently used ones.
+type tinyLFU struct {
+	mu       sync.Mutex
+	capacity int
+	used     int
+	nodes    int
+	// Used size of each segment, and its list of nodes.
+	segUsed [3]int
+	segs    [3]tinyLFUNode
+	sketch  sketch
+}
+
+func (r *tinyLFU) reset() {
+	for i := range r.segs {
+		r.segs[i].next = &r.segs[i]
+		r.segs[i].prev = &r.segs[i]
+	}
+	r.sketch.init(sketchMinWidth)
+}
+
+func (r *tinyLFU) windowCapacity() int {
+	c := int(float64(r.capacity) * tinyLFUWindowRatio)
+	if c < 1 {
+		c = 1
+	}
+	return c
+}
+
+func (r *tinyLFU) Capacity() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capacity
+}
+
+func (r *tinyLFU) SetCapacity(capacity int) {
+	r.mu.Lock()
+	r.capacity = capacity
+	evicted := r.evict()
+	r.mu.Unlock()
+
+	for _, tn := range evicted {
+		tn.h.Release()
+	}
+}
+
+func (r *tinyLFU) push(tn *tinyLFUNode, seg int) {
+	tn.seg = seg
+	tn.insert(&r.segs[seg])
+	r.segUsed[seg] += tn.n.Size()
+}
+
+func (r *tinyLFU) unlink(tn *tinyLFUNode) {
+	tn.remove()
+	r.segUsed[tn.seg] -= tn.n.Size()
+}
+
+// Returns the least recently used node of the segment, or nil if empty.
+func (r *tinyLFU) tail(seg int) *tinyLFUNode {
+	if tn := r.segs[seg].prev; tn != &r.segs[seg] {
+		return tn
+	}
+	return nil
+}
+
+func (r *tinyLFU) drop(tn *tinyLFUNode, evicted []*tinyLFUNode) []*tinyLFUNode {
+	r.unlink(tn)
+	tn.n.CacheData = nil
+	r.used -= tn.n.Size()
+	r.nodes--
+	return append(evicted, tn)
+}
+
+// Moves the nodes leaving the window to the main LRU, or evicts them, then
+// evicts nodes until the used size fits in the capacity. Returns the
+// evicted nodes, their handle must be released once the lock is released.
+func (r *tinyLFU) evict() (evicted []*tinyLFUNode) {
+	mainCapacity := r.capacity - r.windowCapacity()
+	for r.segUsed[segWindow] > r.windowCapacity() {
+		candidate := r.tail(segWindow)
+		if r.segUsed[segProbation]+r.segUsed[segProtected]+candidate.n.Size() <= mainCapacity {
+			r.unlink(candidate)
+			r.push(candidate, segProbation)
+			continue
+		}
+		victim := r.tail(segProbation)
+		if victim == nil {
+			victim = r.tail(segProtected)
+		}
+		if victim != nil && r.sketch.frequency(candidate.n.ns, candidate.n.key) > r.sketch.frequency(victim.n.ns, victim.n.key) {
+			evicted = r.drop(victim, evicted)
+			r.unlink(candidate)
+			r.push(candidate, segProbation)
+		} else {
+			evicted = r.drop(candidate, evicted)
+		}
+	}
+	for r.used > r.capacity {
+		tn := r.tail(segProbation)
+		if tn == nil {
+			tn = r.tail(segProtected)
+		}
+		if tn == nil {
+			tn = r.tail(segWindow)
+		}
+		if tn == nil {
+			panic("BUG: invalid TinyLFU used or capacity counter")
+		}
+		evicted = r.drop(tn, evicted)
+	}
+	return
+}
+
+// Access records an access of the node, and grows the sketch along with
+// the number of nodes.
+func (r *tinyLFU) access(n *Node) {
+	if r.nodes > int(r.sketch.width) {
+		r.sketch.init(r.sketch.width * 2)
+	}
+	r.sketch.increment(n.ns, n.key)
+}
+
+func (r *tinyLFU) Promote(n *Node) {
+	var evicted []*tinyLFUNode
+
+	r.mu.Lock()
+	r.access(n)
+	if n.CacheData == nil {
+		if n.Size() <= r.capacity {
+			tn := &tinyLFUNode{n: n, h: n.GetHandle()}
+			n.CacheData = unsafe.Pointer(tn)
+			r.used += n.Size()
+			r.nodes++
+			r.push(tn, segWindow)
+			evicted = r.evict()
+		}
+	} else {
+		tn := (*tinyLFUNode)(n.CacheData)
+		if !tn.ban {
+			r.unlink(tn)
+			switch tn.seg {
+			case segWindow:
+				r.push(tn, segWindow)
+			default:
+				r.push(tn, segProtected)
+				protectedCapacity := int(float64(r.capacity-r.windowCapacity()) * tinyLFUProtectedRatio)
+				for r.segUsed[segProtected] > protectedCapacity {
+					x := r.tail(segProtected)
+					r.unlink(x)
+					r.push(x, segProbation)
+				}
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, tn := range evicted {
+		tn.h.Release()
+	}
+}
+
+func (r *tinyLFU) Ban(n *Node) {
+	r.mu.Lock()
+	if n.CacheData == nil {
+		n.CacheData = unsafe.Pointer(&tinyLFUNode{n: n, ban: true})
+	} else {
+		tn := (*tinyLFUNode)(n.CacheData)
+		if !tn.ban {
+			r.unlink(tn)
+			tn.ban = true
+			r.used -= n.Size()
+			r.nodes--
+			r.mu.Unlock()
+
+			tn.h.Release()
+			tn.h = nil
+			return
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *tinyLFU) Evict(n *Node) {
+	r.mu.Lock()
+	tn := (*tinyLFUNode)(n.CacheData)
+	if tn == nil || tn.ban {
+		r.mu.Unlock()
+		return
+	}
+	r.unlink(tn)
+	r.used -= n.Size()
+	r.nodes--
+	n.CacheData = nil
+	r.mu.Unlock()
+
+	tn.h.Release()
+}
+
+// NewTinyLFU creates a new W-TinyLFU-cache, which only admits new 'cache
+// nodes' into its main space if they are accessed more frequently than the
+// nodes they would evict.
+func NewTinyLFU(capacity int) Cacher {
+	r := &tinyLFU{capacity: capacity}
+	r.reset()
+	return r
+}
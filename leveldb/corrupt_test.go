@@ -8,15 +8,19 @@ package leveldb
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-update/goleveldb/leveldb/errors"
 	"github.com/golang-update/goleveldb/leveldb/filter"
 	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/util"
 )
 
 const ctValSize = 1000
@@ -484,3 +488,410 @@ func TestCorruptDB_RecoverTable(t *testing.T) {
 	}
 	h.check(985, 985)
 }
+
+func TestCorruptDB_VerifyChecksum(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	verify := func(want ...storage.FileDesc) {
+		t.Helper()
+		corrupted, err := h.db.VerifyChecksum(context.Background())
+		if err != nil {
+			t.Fatal("VerifyChecksum: got error: ", err)
+		}
+		if len(corrupted) != len(want) {
+			t.Fatalf("VerifyChecksum: got %v, want %v", corrupted, want)
+		}
+		for i, f := range corrupted {
+			if f.Fd != want[i] || f.Err == nil {
+				t.Fatalf("VerifyChecksum: got %v, want %v", corrupted, want)
+			}
+			t.Logf("corrupted: %v", f)
+		}
+	}
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.build(50)
+	h.compactMem()
+	verify()
+
+	// The whole-file checksum survives reopening, and catches corruption
+	// of the footer padding, which no block checksum covers.
+	h.closeDB()
+	tables, _ := h.stor.List(storage.TypeTable)
+	sortFds(tables)
+	h.corrupt(storage.TypeTable, 0, -9, 1)
+	h.openDB()
+	verify(tables[0])
+	if corrupted, _ := h.db.VerifyChecksum(context.Background()); corrupted[0].Level != 1 ||
+		!strings.Contains(corrupted[0].Err.Error(), "file checksum mismatch") {
+		t.Fatalf("VerifyChecksum: got %v, want file checksum mismatch at level 1", corrupted[0])
+	}
+
+	// Block corruption.
+	h.closeDB()
+	h.corrupt(storage.TypeTable, 1, 100, 1)
+	h.openDB()
+	verify(tables[1], tables[0])
+
+	// Live journals of a read-only DB are verified too.
+	h.build(10)
+	h.closeDB()
+	journals, _ := h.stor.List(storage.TypeJournal)
+	sortFds(journals)
+	h.corrupt(storage.TypeJournal, -1, 19, 1)
+	h.o.ReadOnly = true
+	h.openDB()
+	verify(tables[1], tables[0], journals[len(journals)-1])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := h.db.VerifyChecksum(ctx); err != context.Canceled {
+		t.Fatalf("VerifyChecksum: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCorruptDB_Scrubber(t *testing.T) {
+	l := &testEventListener{
+		created: make(map[int64]opt.TableFileInfo),
+		deleted: make(map[int64]bool),
+	}
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		BlockCacheCapacity: 8 * opt.MiB,
+		Strict:             opt.StrictJournalChecksum,
+		EventListener:      l,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.build(50)
+	h.compactMem()
+	h.closeDB()
+	tables, _ := h.stor.List(storage.TypeTable)
+	sortFds(tables)
+	h.corrupt(storage.TypeTable, 0, 100, 1)
+
+	// The block cache size after opening the DB, without the scrubber.
+	var s DBStats
+	h.openDB()
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	baseline := s.BlockCacheSize
+	h.closeDB()
+
+	h.o.ScrubBandwidth = 1 << 30
+	h.openDB()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if err := h.db.Stats(&s); err != nil {
+			t.Fatal("Stats: got error: ", err)
+		}
+		if s.Tickers.ScrubPasses > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scrubber didn't complete a pass, stats: %+v", s.Tickers)
+		}
+	}
+	if s.Tickers.ScrubbedTables < uint64(len(tables)) || s.Tickers.ScrubbedBytes == 0 || s.Tickers.ScrubCorruptions == 0 {
+		t.Fatalf("unexpected scrubber stats: %+v", s.Tickers)
+	}
+	// The scrubber doesn't fill the block cache, which reads do.
+	if s.BlockCacheSize != baseline {
+		t.Errorf("block cache size: got %d, want %d", s.BlockCacheSize, baseline)
+	}
+	if _, err := h.db.Get(tkey(0), nil); err != nil {
+		t.Fatal("Get: got error: ", err)
+	}
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.BlockCacheSize <= baseline {
+		t.Errorf("block cache size after read: got %d, want more than %d", s.BlockCacheSize, baseline)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.corruptions) == 0 {
+		t.Fatal("OnCorruption wasn't called")
+	}
+	c := l.corruptions[0]
+	if c.Table.Num != tables[0].Num || c.Table.Level != 1 || !errors.IsCorrupted(c.Err) {
+		t.Fatalf("OnCorruption: got %+v, want table %d at level 1", c, tables[0].Num)
+	}
+}
+
+func TestCorruptDB_Quarantine(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		BlockCacheCapacity:        100,
+		QuarantineCorruptedTables: true,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.closeDB()
+	tables, _ := h.stor.List(storage.TypeTable)
+	if len(tables) != 1 {
+		t.Fatalf("got %d tables, want 1", len(tables))
+	}
+	h.corrupt(storage.TypeTable, 0, 100, 1)
+	h.openDB()
+
+	// The compaction reading the corrupted table quarantines it, instead
+	// of setting a persistent error.
+	h.put(string(tkey(50)), "v50")
+	h.put("z", "vz")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.Tickers.QuarantinedTables != 1 {
+		t.Fatalf("QuarantinedTables: got %d, want 1", s.Tickers.QuarantinedTables)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if fds, _ := h.stor.List(storage.TypeQuarantine); len(fds) == 1 && fds[0].Num == tables[0].Num {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("quarantined table not moved aside")
+		}
+	}
+
+	checkLost := func(key []byte) {
+		t.Helper()
+		_, err := h.db.Get(key, nil)
+		lerr, ok := err.(*ErrDataLoss)
+		if !ok {
+			t.Fatalf("Get %q: got error %v, want ErrDataLoss", key, err)
+		}
+		if lerr.Fd.Num != tables[0].Num || !bytes.Equal(lerr.Min, tkey(0)) || !bytes.Equal(lerr.Max, tkey(99)) {
+			t.Fatalf("Get %q: unexpected error %v", key, lerr)
+		}
+	}
+	check := func() {
+		t.Helper()
+		checkLost(tkey(10))
+		// The entry was written before the table was quarantined, so it
+		// may be shadowed by a lost one.
+		checkLost(tkey(50))
+		if _, err := h.db.Has(tkey(20), nil); err == nil {
+			t.Fatal("Has: got no error, want ErrDataLoss")
+		}
+		h.getVal("z", "vz")
+		h.getVal(string(tkey(60)), "v60")
+
+		iter := h.db.NewIterator(nil, nil)
+		if iter.Next() || !strings.Contains(fmt.Sprint(iter.Error()), "data lost") {
+			t.Fatalf("Iterator: got error %v, want ErrDataLoss", iter.Error())
+		}
+		iter.Release()
+		iter = h.db.NewIterator(&util.Range{Start: []byte("y")}, nil)
+		if !iter.Next() || string(iter.Key()) != "z" {
+			t.Fatalf("Iterator: got error %v, want key z", iter.Error())
+		}
+		iter.Release()
+	}
+
+	// The DB keeps serving writes, entries written afterward are readable.
+	h.put(string(tkey(60)), "v60")
+	check()
+	h.compactMem()
+	check()
+
+	// The lost range survives reopening.
+	h.reopenDB()
+	check()
+
+	if err := h.db.ClearDataLoss(); err != nil {
+		t.Fatal("ClearDataLoss: got error: ", err)
+	}
+	h.reopenDB()
+	h.getVal(string(tkey(50)), "v50")
+	if _, err := h.db.Get(tkey(10), nil); err != ErrNotFound {
+		t.Fatalf("Get: got error %v, want ErrNotFound", err)
+	}
+	iter := h.db.NewIterator(nil, nil)
+	var n int
+	for iter.Next() {
+		n++
+	}
+	if err := iter.Error(); err != nil || n != 3 {
+		t.Fatalf("Iterator: got %d entries and error %v, want 3 entries", n, err)
+	}
+	iter.Release()
+}
+
+func TestCorruptDB_QuarantineCompacting(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		QuarantineCorruptedTables: true,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	v := h.db.s.version()
+	tt := v.levels[1][0]
+	v.release()
+	cause := errors.New("test corruption")
+
+	// The input of another compaction isn't quarantined.
+	c := h.db.s.getCompactionRange(1, nil, nil, true)
+	if c == nil {
+		t.Fatal("no compaction at level 1")
+	}
+	if h.db.quarantineTable(tt.fd.Num, cause, false) {
+		t.Fatal("quarantined the input of a compaction")
+	}
+
+	// A compaction whose input was quarantined since it started isn't
+	// committed, so it doesn't move the table back.
+	if !h.db.quarantineTable(tt.fd.Num, cause, true) {
+		t.Fatal("table not quarantined")
+	}
+	rec := &sessionRecord{}
+	rec.delTable(1, tt.fd.Num)
+	rec.addTableFile(2, tt)
+	if h.db.compactionCommit("table-move", rec) {
+		t.Fatal("compaction of a quarantined table committed")
+	}
+	h.db.s.doneCompaction(c)
+	c.release()
+	if h.totalTables() != 0 {
+		t.Fatalf("got %d tables, want 0", h.totalTables())
+	}
+}
+
+func TestCorruptDB_QuarantineClearDataLoss(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		QuarantineCorruptedTables: true,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	v := h.db.s.version()
+	num := v.levels[1][0].fd.Num
+	v.release()
+
+	// The file is moved aside after the lost range is cleared.
+	h.db.s.pauseFileDeletion()
+	if !h.db.quarantineTable(num, errors.New("test corruption"), false) {
+		t.Fatal("table not quarantined")
+	}
+	if err := h.db.ClearDataLoss(); err != nil {
+		t.Fatal("ClearDataLoss: got error: ", err)
+	}
+	h.db.s.resumeFileDeletion()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if fds, _ := h.stor.List(storage.TypeQuarantine); len(fds) == 1 && fds[0].Num == num {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("quarantined table not moved aside")
+		}
+	}
+}
+
+func TestCorruptDB_RecoveryReport(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.build(10)
+	h.closeDB()
+	tables, _ := h.stor.List(storage.TypeTable)
+	if len(tables) != 1 {
+		t.Fatalf("got %d tables, want 1", len(tables))
+	}
+	h.corrupt(storage.TypeTable, 0, 100, 1)
+	h.corrupt(storage.TypeJournal, -1, 19, 1)
+	h.o.Strict |= opt.StrictBlockChecksum
+
+	files := func() map[storage.FileDesc]string {
+		fds, err := h.stor.List(storage.TypeAll)
+		if err != nil {
+			t.Fatal("List: got error: ", err)
+		}
+		m := make(map[storage.FileDesc]string)
+		for _, fd := range fds {
+			r, err := h.stor.Open(fd)
+			if err != nil {
+				t.Fatal("Open: got error: ", err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal("Read: got error: ", err)
+			}
+			m[fd] = string(b)
+		}
+		return m
+	}
+	checkReport := func(r *RecoveryReport, dryRun bool) {
+		t.Helper()
+		if r.DryRun != dryRun || len(r.Tables) != 1 || r.CorruptedBlocks != 1 || r.DroppedTables != 0 {
+			t.Fatalf("unexpected report: %+v", r)
+		}
+		tr := r.Tables[0]
+		if tr.Fd != tables[0] || len(tr.CorruptedBlocks) != 1 || tr.CorruptedBlocks[0] != 0 || !tr.Rebuilt || tr.Dropped {
+			t.Fatalf("unexpected table report: %+v", tr)
+		}
+		if tr.GoodKeys == 0 || tr.GoodKeys >= 100 || tr.GoodKeys != r.RecoveredKeys || !bytes.Equal(tr.Max, tkey(99)) {
+			t.Fatalf("unexpected table report: %+v", tr)
+		}
+		if len(r.SkippedJournalRecords) == 0 || r.SkippedJournalRecords[0].Fd.Type != storage.TypeJournal {
+			t.Fatalf("unexpected skipped journal records: %+v", r.SkippedJournalRecords)
+		}
+		if len(r.DroppedFiles()) != 0 {
+			t.Fatalf("unexpected dropped files: %v", r.DroppedFiles())
+		}
+	}
+
+	// A dry run doesn't modify the DB.
+	before := files()
+	report, err := RecoverDryRun(h.stor, h.o)
+	if err != nil {
+		t.Fatal("RecoverDryRun: got error: ", err)
+	}
+	checkReport(report, true)
+	after := files()
+	for fd, b := range before {
+		if fd.Type != storage.TypeTemp && after[fd] != b {
+			t.Fatalf("RecoverDryRun modified %s", fd)
+		}
+	}
+	if len(after) != len(before) {
+		t.Fatalf("RecoverDryRun: got %d files, want %d", len(after), len(before))
+	}
+
+	h.db, report, err = RecoverWithReport(h.stor, h.o)
+	if err != nil {
+		t.Fatal("RecoverWithReport: got error: ", err)
+	}
+	checkReport(report, false)
+	h.check(90, 99)
+
+	// Tables dropped with StrictRecovery are listed.
+	h.closeDB()
+	h.corrupt(storage.TypeTable, 0, 100, 1)
+	h.o.Strict |= opt.StrictRecovery
+	if report, err = RecoverDryRun(h.stor, h.o); err != nil {
+		t.Fatal("RecoverDryRun: got error: ", err)
+	}
+	if fds := report.DroppedFiles(); len(fds) != 1 || report.DroppedTables != 1 || report.Tables[0].Rebuilt {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/golang-update/goleveldb/leveldb/cache"
 	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/internal/writebuffer"
 	"github.com/golang-update/goleveldb/leveldb/iterator"
 	"github.com/golang-update/goleveldb/leveldb/journal"
 	"github.com/golang-update/goleveldb/leveldb/memdb"
@@ -35,6 +36,7 @@ type DB struct {
 
 	// Stats. Need 64-bit alignment.
 	cWriteDelay            int64 // The cumulative duration of write delays
+	wbmUnchecked           int64 // Bytes written since the last write buffer check
 	cWriteDelayN           int32 // The cumulative number of write delays
 	inWritePaused          int32 // The indicator whether write operation is paused by compaction
 	aliveSnaps, aliveIters int32
@@ -70,20 +72,36 @@ type DB struct {
 	writeAckC    chan error
 	writeDelay   time.Duration
 	writeDelayN  int
+	writeStall   opt.WriteStallCondition
 	tr           *Transaction
+	wbm          *writebuffer.Manager
+	wbmUnreg     func()
+	wbmFlushing  uint32
 
 	// Compaction.
 	compCommitLk     sync.Mutex
 	tcompCmdC        chan cCmd
 	tcompPauseC      chan chan<- struct{}
+	tcompDoneC       chan struct{}
 	mcompCmdC        chan cCmd
 	compErrC         chan error
 	compPerErrC      chan error
 	compErrSetC      chan error
 	compWriteLocking bool
+	compFlushMu      sync.RWMutex // Held by memdb flushes, see pauseForFlush.
 	compStats        cStats
+	tickers          tickers
+	lat              *latencyHistograms
 	memdbMaxLevel    int // For testing.
 
+	// Background work pause.
+	bgMu      sync.Mutex
+	bgPaused  int           // pause nesting count
+	bgRunning int           // number of background jobs not at a safe point
+	bgResumeC chan struct{} // closed on resume; nil if not paused
+	bgIdleC   chan struct{} // closed once no background job is running
+	bgCancel  uint32        // set while in-flight compactions are canceled
+
 	// Close.
 	closeW sync.WaitGroup
 	closeC chan struct{}
@@ -92,7 +110,7 @@ type DB struct {
 }
 
 func openDB(s *session) (*DB, error) {
-	s.log("db@open opening")
+	s.log(opt.LogInfo, "db@open opening")
 	start := time.Now()
 	db := &DB{
 		s: s,
@@ -111,10 +129,13 @@ func openDB(s *session) (*DB, error) {
 		// Compaction
 		tcompCmdC:   make(chan cCmd),
 		tcompPauseC: make(chan chan<- struct{}),
+		tcompDoneC:  make(chan struct{}, s.o.GetCompactionConcurrency()),
 		mcompCmdC:   make(chan cCmd),
 		compErrC:    make(chan error),
 		compPerErrC: make(chan error),
 		compErrSetC: make(chan error),
+		// Stats
+		lat: newLatencyHistograms(s.o.GetLatencyHistograms()),
 		// Close
 		closeC: make(chan struct{}),
 	}
@@ -133,8 +154,12 @@ func openDB(s *session) (*DB, error) {
 			return nil, err
 		}
 
-		// Remove any obsolete files.
-		if err := db.checkAndCleanFiles(); err != nil {
+		// Record the options, then remove any obsolete files.
+		err := s.writeOptions()
+		if err == nil {
+			err = db.checkAndCleanFiles()
+		}
+		if err != nil {
 			// Close journal.
 			if db.journal != nil {
 				db.journal.Close()
@@ -158,8 +183,16 @@ func openDB(s *session) (*DB, error) {
 		go db.mCompaction()
 		// go db.jWriter()
 	}
+	if wbm := s.o.GetWriteBufferManager(); wbm != nil && !readOnly {
+		db.wbm = (*writebuffer.Manager)(wbm)
+		db.wbmUnreg = db.wbm.Register(db.writeBufferUsage, db.flushWriteBuffer)
+	}
+	if s.o.GetScrubBandwidth() > 0 {
+		db.closeW.Add(1)
+		go db.scrubber(!readOnly)
+	}
 
-	s.logf("db@open done T·%v", time.Since(start))
+	s.log(opt.LogInfo, "db@open done", lfDuration(time.Since(start)))
 
 	runtime.SetFinalizer(db, (*DB).Close)
 	return db, nil
@@ -174,6 +207,11 @@ func openDB(s *session) (*DB, error) {
 // detected in the DB. Use errors.IsCorrupted to test whether an error is
 // due to corruption. Corrupted DB can be recovered with Recover function.
 //
+// The options are recorded in an OPTIONS file, and compared with the options
+// the DB was last opened with, see CheckOptions. If ErrorIfOptionsMismatch is
+// true and they differ dangerously, Open returns an error wrapping
+// ErrIncompatibleOptions.
+//
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
@@ -200,6 +238,8 @@ func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 	} else if s.o.GetErrorIfExist() {
 		err = os.ErrExist
 		return
+	} else if err = s.checkOptions(o); err != nil {
+		return
 	}
 
 	return openDB(s)
@@ -241,22 +281,34 @@ func OpenFile(path string, o *opt.Options) (db *DB, err error) {
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Recover(stor storage.Storage, o *opt.Options) (db *DB, err error) {
+	db, _, err = RecoverWithReport(stor, o)
+	return
+}
+
+// RecoverWithReport is like Recover, and also returns a report of what was
+// salvaged from the DB. The report is returned even if the recovery fails,
+// covering the files processed so far.
+func RecoverWithReport(stor storage.Storage, o *opt.Options) (db *DB, report *RecoveryReport, err error) {
 	s, err := newSession(stor, o)
 	if err != nil {
 		return
 	}
 	defer func() {
+		s.recReport = nil
 		if err != nil {
 			s.close()
 			s.release()
 		}
 	}()
 
-	err = recoverTable(s, o)
+	report = &RecoveryReport{}
+	s.recReport = report
+	err = recoverTable(s, o, report, false)
 	if err != nil {
 		return
 	}
-	return openDB(s)
+	db, err = openDB(s)
+	return
 }
 
 // RecoverFile recovers and opens a DB with missing or corrupted manifest files
@@ -270,11 +322,18 @@ func Recover(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func RecoverFile(path string, o *opt.Options) (db *DB, err error) {
+	db, _, err = RecoverFileWithReport(path, o)
+	return
+}
+
+// RecoverFileWithReport is like RecoverFile, and also returns a report of
+// what was salvaged from the DB, see RecoverWithReport.
+func RecoverFileWithReport(path string, o *opt.Options) (db *DB, report *RecoveryReport, err error) {
 	stor, err := storage.OpenFile(path, false)
 	if err != nil {
 		return
 	}
-	db, err = Recover(stor, o)
+	db, report, err = RecoverWithReport(stor, o)
 	if err != nil {
 		stor.Close()
 	} else {
@@ -283,7 +342,9 @@ func RecoverFile(path string, o *opt.Options) (db *DB, err error) {
 	return
 }
 
-func recoverTable(s *session, o *opt.Options) error {
+// Recovers the tables into a new manifest, and fills the report. If dryRun
+// is true, the tables are only analyzed, and nothing is modified.
+func recoverTable(s *session, o *opt.Options, report *RecoveryReport, dryRun bool) error {
 	o = dupOptions(o)
 	// Mask StrictReader, lets StrictRecovery doing its job.
 	o.Strict &= ^opt.StrictReader
@@ -357,7 +418,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		return
 	}
 	recoverTable := func(fd storage.FileDesc) error {
-		s.logf("table@recovery recovering @%d", fd.Num)
+		s.log(opt.LogInfo, "table@recovery recovering", lfTable(fd.Num))
 		reader, err := s.stor.Open(fd)
 		if err != nil {
 			return err
@@ -379,7 +440,19 @@ func recoverTable(s *session, o *opt.Options) error {
 			tSeq                                     uint64
 			tgoodKey, tcorruptedKey, tcorruptedBlock int
 			imin, imax                               []byte
+
+			info = TableRecovery{Fd: fd, Size: size}
 		)
+		defer func() {
+			info.MaxSeq = tSeq
+			info.GoodKeys = tgoodKey
+			info.CorruptedKeys = tcorruptedKey
+			if imin != nil {
+				info.Min = append([]byte(nil), internalKey(imin).ukey()...)
+				info.Max = append([]byte(nil), internalKey(imax).ukey()...)
+			}
+			report.Tables = append(report.Tables, info)
+		}()
 		tr, err := table.NewReader(reader, size, fd, nil, bpool, o)
 		if err != nil {
 			return err
@@ -388,8 +461,13 @@ func recoverTable(s *session, o *opt.Options) error {
 		if itererr, ok := iter.(iterator.ErrorCallbackSetter); ok {
 			itererr.SetErrorCallback(func(err error) {
 				if errors.IsCorrupted(err) {
-					s.logf("table@recovery block corruption @%d %q", fd.Num, err)
+					s.log(opt.LogWarn, "table@recovery block corruption", lfTable(fd.Num), lfErr(err))
 					tcorruptedBlock++
+					if cerr, ok := err.(*errors.ErrCorrupted); ok {
+						if terr, ok := cerr.Err.(*table.ErrCorrupted); ok {
+							info.CorruptedBlocks = append(info.CorruptedBlocks, terr.Pos)
+						}
+					}
 				}
 			})
 		}
@@ -423,14 +501,16 @@ func recoverTable(s *session, o *opt.Options) error {
 
 		if strict && (tcorruptedKey > 0 || tcorruptedBlock > 0) {
 			droppedTable++
-			s.logf("table@recovery dropped @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			info.Dropped = true
+			s.log(opt.LogWarn, "table@recovery dropped", lfTable(fd.Num), lf("good_keys", tgoodKey), lf("corrupted_keys", tcorruptedKey), lf("corrupted_blocks", tcorruptedBlock), lfSize(size), lfSeq(tSeq))
 			return nil
 		}
 
 		if tgoodKey > 0 {
-			if tcorruptedKey > 0 || tcorruptedBlock > 0 {
+			info.Rebuilt = tcorruptedKey > 0 || tcorruptedBlock > 0
+			if info.Rebuilt && !dryRun {
 				// Rebuild the table.
-				s.logf("table@recovery rebuilding @%d", fd.Num)
+				s.log(opt.LogInfo, "table@recovery rebuilding", lfTable(fd.Num))
 				iter := tr.NewIterator(nil, nil)
 				tmpFd, newSize, err := buildTable(iter)
 				iter.Release()
@@ -450,10 +530,11 @@ func recoverTable(s *session, o *opt.Options) error {
 			recoveredKey += tgoodKey
 			// Add table to level 0.
 			rec.addTable(0, fd.Num, size, imin, imax)
-			s.logf("table@recovery recovered @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			s.log(opt.LogInfo, "table@recovery recovered", lfTable(fd.Num), lf("good_keys", tgoodKey), lf("corrupted_keys", tcorruptedKey), lf("corrupted_blocks", tcorruptedBlock), lfSize(size), lfSeq(tSeq))
 		} else {
 			droppedTable++
-			s.logf("table@recovery unrecoverable @%d Ck·%d Cb·%d S·%d", fd.Num, tcorruptedKey, tcorruptedBlock, size)
+			info.Dropped = true
+			s.log(opt.LogWarn, "table@recovery unrecoverable", lfTable(fd.Num), lf("corrupted_keys", tcorruptedKey), lf("corrupted_blocks", tcorruptedBlock), lfSize(size))
 		}
 
 		return nil
@@ -461,7 +542,7 @@ func recoverTable(s *session, o *opt.Options) error {
 
 	// Recover all tables.
 	if len(fds) > 0 {
-		s.logf("table@recovery F·%d", len(fds))
+		s.log(opt.LogInfo, "table@recovery", lfFiles(len(fds)))
 
 		// Mark file number as used.
 		s.markFileNum(fds[len(fds)-1].Num)
@@ -472,7 +553,16 @@ func recoverTable(s *session, o *opt.Options) error {
 			}
 		}
 
-		s.logf("table@recovery recovered F·%d N·%d Gk·%d Ck·%d Q·%d", len(fds), recoveredKey, goodKey, corruptedKey, maxSeq)
+		s.log(opt.LogInfo, "table@recovery done", lfFiles(len(fds)), lf("keys", recoveredKey), lf("good_keys", goodKey), lf("corrupted_keys", corruptedKey), lfSeq(maxSeq))
+	}
+	report.RecoveredKeys = recoveredKey
+	report.GoodKeys = goodKey
+	report.CorruptedKeys = corruptedKey
+	report.CorruptedBlocks = corruptedBlock
+	report.DroppedTables = droppedTable
+	report.MaxSeq = maxSeq
+	if dryRun {
+		return nil
 	}
 
 	// Set sequence number.
@@ -510,7 +600,7 @@ func (db *DB) recoverJournal() error {
 
 	// Recover journals.
 	if len(fds) > 0 {
-		db.logf("journal@recovery F·%d", len(fds))
+		db.log(opt.LogInfo, "journal@recovery", lfFiles(len(fds)))
 
 		// Mark file number as used.
 		db.s.markFileNum(fds[len(fds)-1].Num)
@@ -529,7 +619,7 @@ func (db *DB) recoverJournal() error {
 		)
 
 		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+			db.log(opt.LogInfo, "journal@recovery recovering", lf("journal", fd.Num))
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
@@ -594,7 +684,8 @@ func (db *DB) recoverJournal() error {
 				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
+						db.log(opt.LogWarn, "journal@recovery skipped error", lfErr(err))
+						db.s.reportSkippedRecord(fd, buf.Len(), err)
 						// We won't apply sequence number as it might be corrupted.
 						continue
 					}
@@ -683,7 +774,7 @@ func (db *DB) recoverJournalRO() error {
 
 	// Recover journals.
 	if len(fds) > 0 {
-		db.logf("journal@recovery RO·Mode F·%d", len(fds))
+		db.log(opt.LogInfo, "journal@recovery read-only", lfFiles(len(fds)))
 
 		var (
 			jr       *journal.Reader
@@ -693,7 +784,7 @@ func (db *DB) recoverJournalRO() error {
 		)
 
 		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+			db.log(opt.LogInfo, "journal@recovery recovering", lf("journal", fd.Num))
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
@@ -734,7 +825,7 @@ func (db *DB) recoverJournalRO() error {
 				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
+						db.log(opt.LogWarn, "journal@recovery skipped error", lfErr(err))
 						// We won't apply sequence number as it might be corrupted.
 						continue
 					}
@@ -778,12 +869,18 @@ func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byt
 	return
 }
 
-func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
-	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+// memLookup looks up the given internal key in the auxiliary memdb and
+// then in the effective and frozen memdbs. If found, f is called with the
+// value and error of the entry while the memdb is still referenced.
+func (db *DB) memLookup(auxm *memdb.DB, ikey internalKey, pc *opt.PerfContext, f func(mv []byte, me error)) bool {
+	if pc != nil {
+		defer perfSince(&pc.MemdbTime, time.Now())
+	}
 
 	if auxm != nil {
 		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+			f(mv, me)
+			return true
 		}
 	}
 
@@ -795,13 +892,37 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		defer m.decref()
 
 		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+			f(mv, me)
+			return true
 		}
 	}
+	return false
+}
+
+func perfSince(d *time.Duration, start time.Time) {
+	*d += time.Since(start)
+}
+
+func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+	pc := ro.GetPerfContext()
 
+	if db.memLookup(auxm, ikey, pc, func(mv []byte, me error) {
+		value, err = append([]byte(nil), mv...), me
+	}) {
+		return
+	}
+
+	var start time.Time
+	if pc != nil {
+		start = time.Now()
+	}
 	v := db.s.version()
 	value, cSched, err := v.get(auxt, ikey, ro, false)
 	v.release()
+	if pc != nil {
+		perfSince(&pc.TableTime, start)
+	}
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
@@ -818,28 +939,24 @@ func nilIfNotFound(err error) error {
 
 func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+	pc := ro.GetPerfContext()
 
-	if auxm != nil {
-		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return me == nil, nilIfNotFound(me)
-		}
+	if db.memLookup(auxm, ikey, pc, func(_ []byte, me error) {
+		ret, err = me == nil, nilIfNotFound(me)
+	}) {
+		return
 	}
 
-	em, fm := db.getMems()
-	for _, m := range [...]*memDB{em, fm} {
-		if m == nil {
-			continue
-		}
-		defer m.decref()
-
-		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return me == nil, nilIfNotFound(me)
-		}
+	var start time.Time
+	if pc != nil {
+		start = time.Now()
 	}
-
 	v := db.s.version()
 	_, cSched, err := v.get(auxt, ikey, ro, true)
 	v.release()
+	if pc != nil {
+		perfSince(&pc.TableTime, start)
+	}
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
@@ -864,6 +981,7 @@ func (db *DB) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
 		return
 	}
 
+	defer db.lat.since(latGet, db.lat.now())
 	se := db.acquireSnapshot()
 	defer db.releaseSnapshot(se)
 	return db.get(nil, nil, key, se.seq, ro)
@@ -878,6 +996,7 @@ func (db *DB) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
 		return
 	}
 
+	defer db.lat.since(latHas, db.lat.now())
 	se := db.acquireSnapshot()
 	defer db.releaseSnapshot(se)
 	return db.has(nil, nil, key, se.seq, ro)
@@ -952,6 +1071,13 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns number of alive snapshots.
 //	leveldb.aliveiters
 //		Returns number of alive iterators.
+//	leveldb.latency
+//		Returns latency histograms summary of all operations, only available
+//		if opt.Options.LatencyHistograms is set. The operations are: get, has,
+//		write, write.lock, write.journal, write.sync, write.memdb, iter.seek,
+//		iter.next, memdb.flush and table.compaction.
+//	leveldb.latency.{op}
+//		Returns latency histogram summary of operation 'op'.
 func (db *DB) GetProperty(name string) (value string, err error) {
 	err = db.ok()
 	if err != nil {
@@ -1024,7 +1150,7 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		value = fmt.Sprintf("%v", db.s.tops.blockBuffer)
 	case p == "cachedblock":
 		if db.s.tops.blockCache != nil {
-			value = fmt.Sprintf("%d", db.s.tops.blockCache.Size())
+			value = fmt.Sprintf("%d", db.s.tops.blockCacheSize())
 		} else {
 			value = "<nil>"
 		}
@@ -1034,6 +1160,17 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveSnaps))
 	case p == "aliveiters":
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveIters))
+	case p == "latency" && db.lat != nil:
+		value = db.lat.String()
+	case strings.HasPrefix(p, "latency.") && db.lat != nil:
+		err = ErrNotFound
+		for kind, name := range latencyNames {
+			if name == p[len("latency."):] {
+				s := db.lat[kind].snapshot()
+				value, err = s.String(), nil
+				break
+			}
+		}
 	default:
 		err = ErrNotFound
 	}
@@ -1053,6 +1190,9 @@ type DBStats struct {
 	IOWrite uint64
 	IORead  uint64
 
+	// BlockCacheSize is the size of the blocks of this DB in the block
+	// cache, while BlockCache holds the stats of the whole block cache,
+	// which may be shared, see opt.Options.BlockCache.
 	BlockCacheSize    int
 	OpenedTablesCount int
 
@@ -1069,6 +1209,54 @@ type DBStats struct {
 	Level0Comp    uint32
 	NonLevel0Comp uint32
 	SeekComp      uint32
+
+	Tickers Tickers
+
+	// Latencies holds latency histograms keyed by operation name, see
+	// the 'leveldb.latency' property for the names. It is nil unless
+	// opt.Options.LatencyHistograms is set.
+	Latencies map[string]LatencyHistogram
+}
+
+// Tickers are engine-wide statistics counters. They count from the time
+// the DB is opened or from the last call to DB.ResetStats.
+type Tickers struct {
+	DataBlockCacheHits     uint64
+	DataBlockCacheMisses   uint64
+	IndexBlockCacheHits    uint64
+	IndexBlockCacheMisses  uint64
+	FilterBlockCacheHits   uint64
+	FilterBlockCacheMisses uint64
+
+	// FilterUseful is the number of filter lookups that avoided reading a
+	// data block, and FilterFullPositive is the number of filter lookups
+	// that reported the key may be present.
+	FilterUseful       uint64
+	FilterFullPositive uint64
+
+	// Bytes read from tables by user reads and by compactions.
+	UserReadBytes       uint64
+	CompactionReadBytes uint64
+
+	// LevelKeysWritten is the number of keys written into each level by
+	// memdb flushes, compactions and transactions.
+	LevelKeysWritten []uint64
+
+	// Tables verified and bytes read by the background scrubber, the number
+	// of full passes it completed and the corrupted tables it found.
+	ScrubbedTables   uint64
+	ScrubbedBytes    uint64
+	ScrubPasses      uint64
+	ScrubCorruptions uint64
+
+	// QuarantinedTables is the number of corrupted tables quarantined, see
+	// opt.Options.QuarantineCorruptedTables.
+	QuarantinedTables uint64
+
+	// Row cache lookups by DB.Get and DB.Has, see
+	// opt.Options.RowCacheCapacity.
+	RowCacheHits   uint64
+	RowCacheMisses uint64
 }
 
 // Stats populates s with database statistics.
@@ -1086,7 +1274,7 @@ func (db *DB) Stats(s *DBStats) error {
 
 	s.OpenedTablesCount = db.s.tops.fileCache.Size()
 	if db.s.tops.blockCache != nil {
-		s.BlockCacheSize = db.s.tops.blockCache.Size()
+		s.BlockCacheSize = db.s.tops.blockCacheSize()
 	} else {
 		s.BlockCacheSize = 0
 	}
@@ -1123,6 +1311,40 @@ func (db *DB) Stats(s *DBStats) error {
 	s.Level0Comp = atomic.LoadUint32(&db.level0Comp)
 	s.NonLevel0Comp = atomic.LoadUint32(&db.nonLevel0Comp)
 	s.SeekComp = atomic.LoadUint32(&db.seekComp)
+	rs := &db.s.tops.readStats
+	s.Tickers = Tickers{
+		DataBlockCacheHits:     rs.CacheHits(table.DataBlock),
+		DataBlockCacheMisses:   rs.CacheMisses(table.DataBlock),
+		IndexBlockCacheHits:    rs.CacheHits(table.IndexBlock),
+		IndexBlockCacheMisses:  rs.CacheMisses(table.IndexBlock),
+		FilterBlockCacheHits:   rs.CacheHits(table.FilterBlock),
+		FilterBlockCacheMisses: rs.CacheMisses(table.FilterBlock),
+		FilterUseful:           rs.FilterUseful(),
+		FilterFullPositive:     rs.FilterFullPositive(),
+		UserReadBytes:          rs.ReadBytes(),
+		CompactionReadBytes:    rs.BackgroundReadBytes(),
+		LevelKeysWritten:       db.tickers.getLevelKeys(s.Tickers.LevelKeysWritten, len(v.levels)),
+		ScrubbedTables:         atomic.LoadUint64(&db.tickers.scrubTables),
+		ScrubbedBytes:          atomic.LoadUint64(&db.tickers.scrubBytes),
+		ScrubPasses:            atomic.LoadUint64(&db.tickers.scrubPasses),
+		ScrubCorruptions:       atomic.LoadUint64(&db.tickers.scrubCorruptions),
+		QuarantinedTables:      atomic.LoadUint64(&db.tickers.quarantinedTables),
+		RowCacheHits:           atomic.LoadUint64(&db.s.tops.rowHits),
+		RowCacheMisses:         atomic.LoadUint64(&db.s.tops.rowMisses),
+	}
+	s.Latencies = db.lat.snapshot()
+	return nil
+}
+
+// ResetStats resets the statistics tickers reported by DB.Stats.
+func (db *DB) ResetStats() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	db.s.tops.readStats.Reset()
+	atomic.StoreUint64(&db.s.tops.rowHits, 0)
+	atomic.StoreUint64(&db.s.tops.rowMisses, 0)
+	db.tickers.reset()
 	return nil
 }
 
@@ -1174,7 +1396,7 @@ func (db *DB) Close() error {
 	}
 
 	start := time.Now()
-	db.log("db@close closing")
+	db.log(opt.LogInfo, "db@close closing")
 
 	// Clear the finalizer.
 	runtime.SetFinalizer(db, nil)
@@ -1189,6 +1411,11 @@ func (db *DB) Close() error {
 	default:
 	}
 
+	// Leave the write buffer manager.
+	if db.wbmUnreg != nil {
+		db.wbmUnreg()
+	}
+
 	// Signal all goroutines.
 	close(db.closeC)
 
@@ -1212,12 +1439,12 @@ func (db *DB) Close() error {
 	}
 
 	if db.writeDelayN > 0 {
-		db.logf("db@write was delayed N·%d T·%v", db.writeDelayN, db.writeDelay)
+		db.log(opt.LogInfo, "db@write was delayed", lf("count", db.writeDelayN), lfDuration(db.writeDelay))
 	}
 
 	// Close session.
 	db.s.close()
-	db.logf("db@close done T·%v", time.Since(start))
+	db.log(opt.LogInfo, "db@close done", lfDuration(time.Since(start)))
 	db.s.release()
 
 	if db.closer != nil {
@@ -15,10 +15,12 @@ import (
 	"github.com/golang-update/goleveldb/leveldb/errors"
 	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/table"
 )
 
 var (
 	errCompactionTransactExiting = errors.New("leveldb: compaction transact exiting")
+	errCompactionCanceled        = errors.New("leveldb: compaction canceled")
 )
 
 type cStat struct {
@@ -84,13 +86,62 @@ func (p *cStats) getStat(level int) (duration time.Duration, read, write int64)
 	return
 }
 
+type tickers struct {
+	scrubTables       uint64
+	scrubBytes        uint64
+	scrubPasses       uint64
+	scrubCorruptions  uint64
+	quarantinedTables uint64
+
+	lk        sync.Mutex
+	levelKeys []uint64
+}
+
+func (p *tickers) addLevelKeys(level, n int) {
+	p.lk.Lock()
+	if level >= len(p.levelKeys) {
+		newKeys := make([]uint64, level+1)
+		copy(newKeys, p.levelKeys)
+		p.levelKeys = newKeys
+	}
+	p.levelKeys[level] += uint64(n)
+	p.lk.Unlock()
+}
+
+func (p *tickers) getLevelKeys(dst []uint64, numLevels int) []uint64 {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	dst = append(dst[:0], p.levelKeys...)
+	for len(dst) < numLevels {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+func (p *tickers) reset() {
+	atomic.StoreUint64(&p.scrubTables, 0)
+	atomic.StoreUint64(&p.scrubBytes, 0)
+	atomic.StoreUint64(&p.scrubPasses, 0)
+	atomic.StoreUint64(&p.scrubCorruptions, 0)
+	atomic.StoreUint64(&p.quarantinedTables, 0)
+	p.lk.Lock()
+	p.levelKeys = nil
+	p.lk.Unlock()
+}
+
 func (db *DB) compactionError() {
-	var err error
+	var (
+		err      error
+		listener = db.s.o.GetEventListener()
+	)
 noerr:
 	// No error.
 	for {
 		select {
 		case err = <-db.compErrSetC:
+			if err != nil && err != ErrReadOnly {
+				listener.OnBackgroundError(err)
+			}
 			switch {
 			case err == nil:
 			case err == ErrReadOnly, errors.IsCorrupted(err):
@@ -108,6 +159,9 @@ haserr:
 		select {
 		case db.compErrC <- err:
 		case err = <-db.compErrSetC:
+			if err != nil && err != ErrReadOnly {
+				listener.OnBackgroundError(err)
+			}
 			switch {
 			case err == nil:
 				goto noerr
@@ -149,12 +203,14 @@ type compactionTransactInterface interface {
 	revert() error
 }
 
-func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
+// compactionTransact runs the transaction until it succeeds. It returns
+// errCompactionCanceled if the transaction was canceled and reverted.
+func (db *DB) compactionTransact(name string, t compactionTransactInterface) error {
 	defer func() {
 		if x := recover(); x != nil {
 			if x == errCompactionTransactExiting {
 				if err := t.revert(); err != nil {
-					db.logf("%s revert error %q", name, err)
+					db.log(opt.LogError, name+" revert error", lfErr(err))
 				}
 			}
 			panic(x)
@@ -176,17 +232,33 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 	for n := 0; ; n++ {
 		// Check whether the DB is closed.
 		if db.isClosed() {
-			db.logf("%s exiting", name)
+			db.log(opt.LogInfo, name+" exiting")
 			db.compactionExitTransact()
 		} else if n > 0 {
-			db.logf("%s retrying N·%d", name, n)
+			db.log(opt.LogInfo, name+" retrying", lf("retry", n))
 		}
 
 		// Execute.
 		cnt := compactionTransactCounter(0)
 		err := t.run(&cnt)
+		if err == errCompactionCanceled {
+			db.log(opt.LogInfo, name+" canceled", lf("iterations", int(cnt)))
+			if err := t.revert(); err != nil {
+				db.log(opt.LogError, name+" revert error", lfErr(err))
+			}
+			return err
+		}
 		if err != nil {
-			db.logf("%s error I·%d %q", name, cnt, err)
+			db.log(opt.LogError, name+" error", lf("iterations", int(cnt)), lfErr(err))
+		}
+		if errors.IsCorrupted(err) && db.quarantineCorrupted(err) {
+			// The corrupted table is no longer part of the DB, the
+			// compaction is picked again without it.
+			db.log(opt.LogInfo, name+" canceled on quarantine", lf("iterations", int(cnt)))
+			if err := t.revert(); err != nil {
+				db.log(opt.LogError, name+" revert error", lfErr(err))
+			}
+			return errCompactionCanceled
 		}
 
 		// Set compaction error status.
@@ -194,18 +266,18 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 		case db.compErrSetC <- err:
 		case perr := <-db.compPerErrC:
 			if err != nil {
-				db.logf("%s exiting (persistent error %q)", name, perr)
+				db.log(opt.LogError, name+" exiting on persistent error", lfErr(perr))
 				db.compactionExitTransact()
 			}
 		case <-db.closeC:
-			db.logf("%s exiting", name)
+			db.log(opt.LogInfo, name+" exiting")
 			db.compactionExitTransact()
 		}
 		if err == nil {
-			return
+			return nil
 		}
 		if errors.IsCorrupted(err) {
-			db.logf("%s exiting (corruption detected)", name)
+			db.log(opt.LogError, name+" exiting on corruption")
 			db.compactionExitTransact()
 		}
 
@@ -227,7 +299,7 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 			select {
 			case <-backoffT.C:
 			case <-db.closeC:
-				db.logf("%s exiting", name)
+				db.log(opt.LogInfo, name+" exiting")
 				db.compactionExitTransact()
 			}
 		}
@@ -250,39 +322,54 @@ func (t *compactionTransactFunc) revert() error {
 	return nil
 }
 
-func (db *DB) compactionTransactFunc(name string, run func(cnt *compactionTransactCounter) error, revert func() error) {
-	db.compactionTransact(name, &compactionTransactFunc{run, revert})
+func (db *DB) compactionTransactFunc(name string, run func(cnt *compactionTransactCounter) error, revert func() error) error {
+	return db.compactionTransact(name, &compactionTransactFunc{run, revert})
 }
 
 func (db *DB) compactionExitTransact() {
 	panic(errCompactionTransactExiting)
 }
 
-func (db *DB) compactionCommit(name string, rec *sessionRecord) {
+// Commits the compaction record. Returns false, without committing, if one
+// of the tables it deletes is no longer live, i.e. it was quarantined while
+// compacted; the compaction output must then be discarded.
+func (db *DB) compactionCommit(name string, rec *sessionRecord) bool {
 	db.compCommitLk.Lock()
 	defer db.compCommitLk.Unlock() // Defer is necessary.
+	if !db.s.hasTables(rec.deletedTables) {
+		db.log(opt.LogWarn, name+"@commit canceled, input no longer live")
+		return false
+	}
 	db.compactionTransactFunc(name+"@commit", func(cnt *compactionTransactCounter) error {
 		return db.s.commit(rec, true)
 	}, nil)
+	return true
 }
 
 func (db *DB) memCompaction() {
+	defer db.bgLeave()
+	db.bgEnter()
+
 	mdb := db.getFrozenMem()
 	if mdb == nil {
 		return
 	}
 	defer mdb.decref()
 
-	db.logf("memdb@flush N·%d S·%s", mdb.Len(), shortenb(int64(mdb.Size())))
+	db.log(opt.LogInfo, "memdb@flush", lfEntries(mdb.Len()), lfSize(int64(mdb.Size())))
 
 	// Don't compact empty memdb.
 	if mdb.Len() == 0 {
-		db.logf("memdb@flush skipping")
+		db.log(opt.LogInfo, "memdb@flush skipping")
 		// drop frozen memdb
 		db.dropFrozenMem()
 		return
 	}
 
+	listener := db.s.o.GetEventListener()
+	flushInfo := opt.FlushInfo{Entries: mdb.Len(), Size: int64(mdb.Size())}
+	listener.OnFlushBegin(flushInfo)
+
 	// Pause table compaction.
 	resumeC := make(chan struct{})
 	select {
@@ -293,6 +380,15 @@ func (db *DB) memCompaction() {
 	case <-db.closeC:
 		db.compactionExitTransact()
 	}
+	// The pause above only reaches one table compaction, the ones running
+	// in their own goroutine wait on the flush at their next table.
+	db.compFlushMu.Lock()
+	flushing := true
+	defer func() {
+		if flushing {
+			db.compFlushMu.Unlock()
+		}
+	}()
 
 	var (
 		rec        = &sessionRecord{}
@@ -308,8 +404,8 @@ func (db *DB) memCompaction() {
 		return
 	}, func() error {
 		for _, r := range rec.addedTables {
-			db.logf("memdb@flush revert @%d", r.num)
-			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+			db.log(opt.LogWarn, "memdb@flush revert", lfTable(r.num))
+			if _, err := db.s.removeFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
 				return err
 			}
 		}
@@ -324,19 +420,32 @@ func (db *DB) memCompaction() {
 	db.compactionCommit("memdb", rec)
 	stats.stopTimer()
 
-	db.logf("memdb@flush committed F·%d T·%v", len(rec.addedTables), stats.duration)
+	db.log(opt.LogInfo, "memdb@flush committed", lfFiles(len(rec.addedTables)), lfLevel(flushLevel), lfDuration(stats.duration))
 
 	// Save compaction stats
 	for _, r := range rec.addedTables {
 		stats.write += r.size
 	}
 	db.compStats.addStat(flushLevel, stats)
+	db.tickers.addLevelKeys(flushLevel, flushInfo.Entries)
 	atomic.AddUint32(&db.memComp, 1)
 
+	flushInfo.Level = flushLevel
+	flushInfo.Duration = stats.duration
+	db.lat.record(latMemdbFlush, stats.duration)
+	for _, r := range rec.addedTables {
+		t := opt.TableFileInfo{Num: r.num, Level: r.level, Size: r.size}
+		flushInfo.Tables = append(flushInfo.Tables, t)
+		listener.OnTableFileCreated(t)
+	}
+	listener.OnFlushCompleted(flushInfo)
+
 	// Drop frozen memdb.
 	db.dropFrozenMem()
 
 	// Resume table compaction.
+	db.compFlushMu.Unlock()
+	flushing = false
 	if resumeC != nil {
 		select {
 		case <-resumeC:
@@ -350,6 +459,29 @@ func (db *DB) memCompaction() {
 	db.compTrigger(db.tcompCmdC)
 }
 
+// Dictionaries are trained from up to compressionDictSampleRatio times
+// their maximum size of sample data.
+const compressionDictSampleRatio = 100
+
+// trainCompressionDict trains a compression dictionary from the first data
+// blocks of the compaction. It returns nil if the compression doesn't
+// support dictionaries or the training fails.
+func (db *DB) trainCompressionDict(c *compaction, compression opt.Compression) *table.CompressionDict {
+	if _, ok := compression.Codec().(opt.DictCodec); !ok {
+		return nil
+	}
+	iter := c.newIterator()
+	defer iter.Release()
+	size := db.s.o.GetCompressionDictSize()
+	dict, err := table.TrainCompressionDict(iter, db.s.o.tableOptions(compression), size, size*compressionDictSampleRatio)
+	if err != nil {
+		db.log(opt.LogWarn, "table@compaction training compression dictionary failed", lfErr(err))
+		return nil
+	}
+	db.log(opt.LogDebug, "table@compaction compression dictionary trained", lfSize(int64(len(dict.Bytes()))))
+	return dict
+}
+
 type tableCompactionBuilder struct {
 	db    *DB
 	s     *session
@@ -366,15 +498,22 @@ type tableCompactionBuilder struct {
 
 	kerrCnt int
 	dropCnt int
+	keyCnt  int
 
-	minSeq    uint64
-	strict    bool
-	tableSize int
+	minSeq      uint64
+	strict      bool
+	tableSize   int
+	compression opt.Compression
+	dict        *table.CompressionDict
 
 	tw *tWriter
 }
 
 func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
+	if b.db != nil && atomic.LoadUint32(&b.db.bgCancel) != 0 {
+		return errCompactionCanceled
+	}
+
 	// Create new table if not already.
 	if b.tw == nil {
 		// Check for pause event.
@@ -386,14 +525,21 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 				b.db.compactionExitTransact()
 			default:
 			}
+			b.db.pauseForFlush()
+			b.db.bgSafePoint()
 		}
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create(b.tableSize)
+		b.tw, err = b.s.tops.create(b.tableSize, b.compression)
 		if err != nil {
 			return err
 		}
+		if b.dict != nil {
+			if err := b.tw.tw.SetCompressionDict(b.dict); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Write key/value into table.
@@ -409,9 +555,10 @@ func (b *tableCompactionBuilder) flush() error {
 	if err != nil {
 		return err
 	}
-	b.rec.addTableFile(b.c.sourceLevel+1, t)
+	b.rec.addTableFile(b.c.targetLevel, t)
 	b.stat1.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(t.size), t.imin, t.imax)
+	b.keyCnt += b.tw.tw.EntriesLen()
+	b.s.log(opt.LogDebug, "table@build created", lfLevel(b.c.targetLevel), lfTable(t.fd.Num), lfEntries(b.tw.tw.EntriesLen()), lfSize(t.size), lfMin(t.imin), lfMax(t.imax))
 	b.tw = nil
 	return nil
 }
@@ -544,8 +691,8 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 
 func (b *tableCompactionBuilder) revert() error {
 	for _, at := range b.rec.addedTables {
-		b.s.logf("table@build revert @%d", at.num)
-		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
+		b.s.log(opt.LogWarn, "table@build revert", lfTable(at.num))
+		if _, err := b.s.removeFile(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
 			return err
 		}
 	}
@@ -555,54 +702,101 @@ func (b *tableCompactionBuilder) revert() error {
 func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	defer c.release()
 
+	defer db.bgLeave()
+	db.bgEnter()
+
 	rec := &sessionRecord{}
 	rec.addCompPtr(c.sourceLevel, c.imax)
 
+	var (
+		listener = db.s.o.GetEventListener()
+		info     = newCompactionInfo(c, noTrivial)
+		start    = time.Now()
+	)
+
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		db.log(opt.LogInfo, "table@move", lfTable(t.fd.Num), lf("source_level", c.sourceLevel), lf("target_level", c.targetLevel))
+		info.Trivial = true
+		info.ReadBytes = 0
+		listener.OnCompactionBegin(info)
 		rec.delTable(c.sourceLevel, t.fd.Num)
-		rec.addTableFile(c.sourceLevel+1, t)
-		db.compactionCommit("table-move", rec)
+		rec.addTableFile(c.targetLevel, t)
+		if !db.compactionCommit("table-move", rec) {
+			info.Canceled = true
+			info.Duration = time.Since(start)
+			listener.OnCompactionCompleted(info)
+			return
+		}
+		info.Outputs = []opt.TableFileInfo{{Num: t.fd.Num, Level: c.targetLevel, Size: t.size}}
+		info.Duration = time.Since(start)
+		listener.OnCompactionCompleted(info)
 		return
 	}
 
 	var stats [2]cStatStaging
 	for i, tables := range c.levels {
+		level := c.sourceLevel
+		if i > 0 {
+			level = c.targetLevel
+		}
 		for _, t := range tables {
 			stats[i].read += t.size
 			// Insert deleted tables into record
-			rec.delTable(c.sourceLevel+i, t.fd.Num)
+			rec.delTable(level, t.fd.Num)
 		}
 	}
 	sourceSize := stats[0].read + stats[1].read
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
+	db.log(opt.LogInfo, "table@compaction", lf("source_level", c.sourceLevel), lf("source_files", len(c.levels[0])), lf("target_level", c.targetLevel), lf("target_files", len(c.levels[1])), lfSize(sourceSize), lfSeq(minSeq))
 
 	b := &tableCompactionBuilder{
-		db:        db,
-		s:         db.s,
-		c:         c,
-		rec:       rec,
-		stat1:     &stats[1],
-		minSeq:    minSeq,
-		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		db:          db,
+		s:           db.s,
+		c:           c,
+		rec:         rec,
+		stat1:       &stats[1],
+		minSeq:      minSeq,
+		strict:      db.s.o.GetStrict(opt.StrictCompaction),
+		tableSize:   db.s.o.GetCompactionTableSize(c.targetLevel),
+		compression: db.s.o.levelCompression(c.targetLevel, c.bottommost()),
+	}
+	if c.bottommost() && db.s.o.GetCompressionDictSize() > 0 {
+		if b.dict = db.trainCompressionDict(c, b.compression); b.dict != nil {
+			defer b.dict.Release()
+		}
+	}
+	listener.OnCompactionBegin(info)
+	err := db.compactionTransact("table@build", b)
+	if err == errCompactionCanceled {
+		info.Canceled = true
+		info.Duration = time.Since(start)
+		listener.OnCompactionCompleted(info)
+		return
 	}
-	db.compactionTransact("table@build", b)
 
 	// Commit.
 	stats[1].startTimer()
-	db.compactionCommit("table", rec)
+	committed := db.compactionCommit("table", rec)
 	stats[1].stopTimer()
+	if !committed {
+		if err := b.revert(); err != nil {
+			db.log(opt.LogError, "table@build revert error", lfErr(err))
+		}
+		info.Canceled = true
+		info.Duration = time.Since(start)
+		listener.OnCompactionCompleted(info)
+		return
+	}
 
 	resultSize := stats[1].write
-	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
+	db.log(opt.LogInfo, "table@compaction committed", lf("files_delta", len(rec.addedTables)-len(rec.deletedTables)), lf("size_delta", resultSize-sourceSize), lf("key_errors", b.kerrCnt), lf("dropped", b.dropCnt), lfDuration(stats[1].duration))
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.compStats.addStat(c.targetLevel, &stats[i])
 	}
+	db.tickers.addLevelKeys(c.targetLevel, b.keyCnt)
 	switch c.typ {
 	case level0Compaction:
 		atomic.AddUint32(&db.level0Comp, 1)
@@ -611,10 +805,48 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	case seekCompaction:
 		atomic.AddUint32(&db.seekComp, 1)
 	}
+
+	for _, r := range rec.addedTables {
+		t := opt.TableFileInfo{Num: r.num, Level: r.level, Size: r.size}
+		info.Outputs = append(info.Outputs, t)
+		listener.OnTableFileCreated(t)
+	}
+	info.WriteBytes = resultSize
+	info.Duration = time.Since(start)
+	db.lat.record(latTableCompaction, info.Duration)
+	listener.OnCompactionCompleted(info)
+}
+
+func newCompactionInfo(c *compaction, manual bool) opt.CompactionInfo {
+	info := opt.CompactionInfo{
+		SourceLevel: c.sourceLevel,
+		TargetLevel: c.targetLevel,
+	}
+	switch {
+	case manual:
+		info.Reason = opt.CompactionReasonManual
+	case c.typ == level0Compaction:
+		info.Reason = opt.CompactionReasonLevel0
+	case c.typ == seekCompaction:
+		info.Reason = opt.CompactionReasonSeek
+	default:
+		info.Reason = opt.CompactionReasonSize
+	}
+	for i, tables := range c.levels {
+		level := c.sourceLevel
+		if i > 0 {
+			level = c.targetLevel
+		}
+		for _, t := range tables {
+			info.Inputs = append(info.Inputs, opt.TableFileInfo{Num: t.fd.Num, Level: level, Size: t.size})
+			info.ReadBytes += t.size
+		}
+	}
+	return info
 }
 
 func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
-	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
+	db.log(opt.LogInfo, "table@compaction range", lfLevel(level), lf("min", logUkey(umin)), lf("max", logUkey(umax)))
 	if level >= 0 {
 		if c := db.s.getCompactionRange(level, umin, umax, true); c != nil {
 			db.tableCompaction(c, true)
@@ -651,10 +883,38 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 	return nil
 }
 
-func (db *DB) tableAutoCompaction() {
-	if c := db.s.pickCompaction(); c != nil {
+// tableAutoCompaction picks a table compaction and runs it. If async is true
+// the compaction runs in its own goroutine, which will signal tcompDoneC once
+// done. Returns false if there is nothing to compact.
+func (db *DB) tableAutoCompaction(async bool) bool {
+	c := db.s.pickCompaction()
+	if c == nil {
+		return false
+	}
+	if async {
+		db.closeW.Add(1)
+		go db.tableCompactionWorker(c)
+	} else {
 		db.tableCompaction(c, false)
 	}
+	return true
+}
+
+func (db *DB) tableCompactionWorker(c *compaction) {
+	defer func() {
+		if x := recover(); x != nil {
+			if x != errCompactionTransactExiting {
+				panic(x)
+			}
+		}
+		select {
+		case db.tcompDoneC <- struct{}{}:
+		case <-db.closeC:
+		}
+		db.closeW.Done()
+	}()
+
+	db.tableCompaction(c, false)
 }
 
 func (db *DB) tableNeedCompaction() bool {
@@ -670,6 +930,13 @@ func (db *DB) resumeWrite() bool {
 	return v.tLen(0) < db.s.o.GetWriteL0PauseTrigger()
 }
 
+// Waits for the running memdb flush, if any, so that memdb flushes take
+// priority over concurrent table compactions.
+func (db *DB) pauseForFlush() {
+	db.compFlushMu.RLock()
+	db.compFlushMu.RUnlock()
+}
+
 func (db *DB) pauseCompaction(ch chan<- struct{}) {
 	select {
 	case ch <- struct{}{}:
@@ -678,6 +945,155 @@ func (db *DB) pauseCompaction(ch chan<- struct{}) {
 	}
 }
 
+// Waits until background work is resumed, then accounts the caller as a
+// running background job; bgMu must be held. Returns false if the DB is
+// closed while waiting.
+func (db *DB) bgWaitResumeLocked() bool {
+	for db.bgResumeC != nil {
+		resumeC := db.bgResumeC
+		db.bgMu.Unlock()
+		closed := false
+		select {
+		case <-resumeC:
+		case ch := <-db.tcompPauseC:
+			// Let memdb compaction proceed, it may have started before
+			// the pause.
+			select {
+			case ch <- struct{}{}:
+			case <-db.closeC:
+				closed = true
+			}
+		case <-db.closeC:
+			closed = true
+		}
+		db.bgMu.Lock()
+		if closed {
+			db.bgRunning++
+			return false
+		}
+	}
+	db.bgRunning++
+	return true
+}
+
+// Marks the start of a background job that may change files on disk. It
+// blocks while background work is paused. Must be paired with bgLeave, even
+// if it exits because the DB is closed, so the bgLeave must be deferred
+// before calling it.
+func (db *DB) bgEnter() {
+	db.bgMu.Lock()
+	ok := db.bgWaitResumeLocked()
+	db.bgMu.Unlock()
+	if !ok {
+		db.compactionExitTransact()
+	}
+}
+
+// Like bgEnter, but doesn't block. Returns false, without marking the start
+// of a job, if background work is paused, or if the DB is read-only or has
+// hit a persistent error.
+func (db *DB) bgTryEnter() bool {
+	select {
+	case <-db.compPerErrC:
+		return false
+	default:
+	}
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	if db.bgResumeC != nil {
+		return false
+	}
+	db.bgRunning++
+	return true
+}
+
+// Marks the end of a background job.
+func (db *DB) bgLeave() {
+	db.bgMu.Lock()
+	db.bgRunning--
+	if db.bgRunning == 0 && db.bgIdleC != nil {
+		close(db.bgIdleC)
+		db.bgIdleC = nil
+	}
+	db.bgMu.Unlock()
+}
+
+// Parks the calling background job until background work is resumed, if
+// paused. Must only be called by a job at a safe point, where it doesn't
+// hold any file open for writing.
+func (db *DB) bgSafePoint() {
+	db.bgMu.Lock()
+	paused := db.bgResumeC != nil
+	db.bgMu.Unlock()
+	if paused {
+		db.bgLeave()
+		db.bgEnter()
+	}
+}
+
+// PauseBackgroundWork pauses table compaction, memdb compaction and removal
+// of obsolete files. It waits until running compactions reach a safe point,
+// after which no file is created or removed by background work until
+// ContinueBackgroundWork is called. If cancel is true, in-flight table
+// compactions are aborted instead, and their partial output is discarded.
+//
+// While paused, writes may block once the memdb is full, and so may
+// CompactRange. Calls may be nested, each one must be paired with a call to
+// ContinueBackgroundWork.
+func (db *DB) PauseBackgroundWork(cancel bool) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	db.bgMu.Lock()
+	if db.bgPaused == 0 {
+		db.bgResumeC = make(chan struct{})
+		db.s.pauseFileDeletion()
+	}
+	db.bgPaused++
+	var idleC chan struct{}
+	if db.bgRunning > 0 {
+		if db.bgIdleC == nil {
+			db.bgIdleC = make(chan struct{})
+		}
+		idleC = db.bgIdleC
+	}
+	if cancel {
+		atomic.AddUint32(&db.bgCancel, 1)
+		defer atomic.AddUint32(&db.bgCancel, ^uint32(0))
+	}
+	db.bgMu.Unlock()
+
+	if idleC != nil {
+		select {
+		case <-idleC:
+		case <-db.closeC:
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// ContinueBackgroundWork resumes background work paused by
+// PauseBackgroundWork. File removals deferred while paused are carried out.
+func (db *DB) ContinueBackgroundWork() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	if db.bgPaused == 0 {
+		return errors.New("leveldb: background work not paused")
+	}
+	if db.bgPaused--; db.bgPaused == 0 {
+		close(db.bgResumeC)
+		db.bgResumeC = nil
+		db.s.resumeFileDeletion()
+	}
+	return nil
+}
+
 type cCmd interface {
 	ack(err error)
 }
@@ -799,6 +1215,14 @@ func (db *DB) tCompaction() {
 	var (
 		x     cCmd
 		waitQ []cCmd
+
+		// Number of compactions running in their own goroutine.
+		running int
+		// Whether no compaction could be picked, either because all of them
+		// conflict with running ones or the concurrency limit is reached.
+		stalled bool
+
+		concurrency = db.s.o.GetCompactionConcurrency()
 	)
 
 	defer func() {
@@ -818,12 +1242,14 @@ func (db *DB) tCompaction() {
 	}()
 
 	for {
-		if db.tableNeedCompaction() {
+		if !stalled && db.tableNeedCompaction() {
 			select {
 			case x = <-db.tcompCmdC:
 			case ch := <-db.tcompPauseC:
 				db.pauseCompaction(ch)
 				continue
+			case <-db.tcompDoneC:
+				running--
 			case <-db.closeC:
 				return
 			default:
@@ -837,16 +1263,22 @@ func (db *DB) tCompaction() {
 				waitQ = waitQ[:0]
 			}
 		} else {
-			for i := range waitQ {
-				waitQ[i].ack(nil)
-				waitQ[i] = nil
+			if running == 0 || db.resumeWrite() {
+				for i := range waitQ {
+					waitQ[i].ack(nil)
+					waitQ[i] = nil
+				}
+				waitQ = waitQ[:0]
 			}
-			waitQ = waitQ[:0]
 			select {
 			case x = <-db.tcompCmdC:
 			case ch := <-db.tcompPauseC:
 				db.pauseCompaction(ch)
 				continue
+			case <-db.tcompDoneC:
+				running--
+				stalled = false
+				continue
 			case <-db.closeC:
 				return
 			}
@@ -863,12 +1295,28 @@ func (db *DB) tCompaction() {
 					}
 				}
 			case cRange:
+				// Range compaction doesn't check for conflicts, so wait
+				// for running compactions first.
+				for ; running > 0; running-- {
+					select {
+					case <-db.tcompDoneC:
+					case <-db.closeC:
+						return
+					}
+				}
 				x.ack(db.tableRangeCompaction(cmd.level, cmd.min, cmd.max))
 			default:
 				panic("leveldb: unknown command")
 			}
 			x = nil
 		}
-		db.tableAutoCompaction()
+		if running < concurrency {
+			stalled = !db.tableAutoCompaction(concurrency > 1)
+			if !stalled && concurrency > 1 {
+				running++
+			}
+		} else {
+			stalled = true
+		}
 	}
 }
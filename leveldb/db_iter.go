@@ -79,12 +79,16 @@ func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Rang
 		seq:             seq,
 		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
 		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
+		perf:            ro.GetPerfContext(),
 		key:             make([]byte, 0),
 		value:           make([]byte, 0),
 	}
 	if !iter.disableSampling {
 		iter.samplingGap = db.iterSamplingRate()
 	}
+	if err := db.s.checkLostRange(slice); err != nil {
+		iter.err = err
+	}
 	atomic.AddInt32(&db.aliveIters, 1)
 	runtime.SetFinalizer(iter, (*dbIter).Release)
 	return iter
@@ -112,6 +116,7 @@ type dbIter struct {
 	seq             uint64
 	strict          bool
 	disableSampling bool
+	perf            *opt.PerfContext
 
 	samplingGap int
 	dir         dir
@@ -134,6 +139,18 @@ func (i *dbIter) sampleSeek() {
 	}
 }
 
+// skipped accounts an internal key skipped by the iterator.
+func (i *dbIter) skipped(tombstone bool) {
+	if i.perf == nil {
+		return
+	}
+	if tombstone {
+		i.perf.TombstonesSkipped++
+	} else {
+		i.perf.InternalKeysSkipped++
+	}
+}
+
 func (i *dbIter) setErr(err error) {
 	i.err = err
 	i.key = nil
@@ -190,6 +207,7 @@ func (i *dbIter) Seek(key []byte) bool {
 		i.err = ErrIterReleased
 		return false
 	}
+	defer i.db.lat.since(latIterSeek, i.db.lat.now())
 
 	ikey := makeInternalKey(nil, key, i.seq, keyTypeSeek)
 	if i.iter.Seek(ikey) {
@@ -211,6 +229,7 @@ func (i *dbIter) next() bool {
 					// Skip deleted key.
 					i.key = append(i.key[:0], ukey...)
 					i.dir = dirForward
+					i.skipped(true)
 				case keyTypeVal:
 					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
 						i.key = append(i.key[:0], ukey...)
@@ -218,7 +237,10 @@ func (i *dbIter) next() bool {
 						i.dir = dirForward
 						return true
 					}
+					i.skipped(false)
 				}
+			} else {
+				i.skipped(false)
 			}
 		} else if i.strict {
 			i.setErr(kerr)
@@ -240,6 +262,7 @@ func (i *dbIter) Next() bool {
 		i.err = ErrIterReleased
 		return false
 	}
+	defer i.db.lat.since(latIterNext, i.db.lat.now())
 
 	if !i.iter.Next() || (i.dir == dirBackward && !i.iter.Next()) {
 		i.dir = dirEOI
@@ -260,11 +283,20 @@ func (i *dbIter) prev() bool {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
 						return true
 					}
+					if kt == keyTypeDel {
+						i.skipped(true)
+					}
+					if !del {
+						// The older entry of the same key is shadowed.
+						i.skipped(false)
+					}
 					del = (kt == keyTypeDel)
 					if !del {
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
 					}
+				} else {
+					i.skipped(false)
 				}
 			} else if i.strict {
 				i.setErr(kerr)
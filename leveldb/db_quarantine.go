@@ -0,0 +1,99 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync/atomic"
+
+	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+)
+
+// Quarantines the corrupted table the error is about, if enabled by
+// Options.QuarantineCorruptedTables. Returns true if the table has been
+// quarantined. compCommitLk must not be held.
+func (db *DB) quarantineCorrupted(err error) bool {
+	if !db.s.o.GetQuarantineCorruptedTables() {
+		return false
+	}
+	cerr, ok := err.(*errors.ErrCorrupted)
+	if !ok || cerr.Fd.Type != storage.TypeTable {
+		return false
+	}
+	return db.quarantineTable(cerr.Fd.Num, err, true)
+}
+
+// Removes the live table with the given number from the DB, and records its
+// key range as lost. The file is moved aside once it isn't used anymore.
+// Returns false if the table isn't live or the record couldn't be committed.
+//
+// Inputs of in-progress compactions are only quarantined if own is true,
+// by the compaction that found the corruption, which is then canceled. Other
+// compactions would commit their output, or move the table back, over the
+// lost range; those that start while the table is quarantined are canceled
+// at commit instead. compCommitLk must not be held.
+func (db *DB) quarantineTable(num int64, cause error, own bool) bool {
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+
+	if !own && db.s.isCompacting(num) {
+		db.log(opt.LogWarn, "table@quarantine skipped, compacting", lfTable(num))
+		return false
+	}
+
+	v := db.s.version()
+	var (
+		t     *tFile
+		level int
+	)
+	for l, tables := range v.levels {
+		for _, x := range tables {
+			if x.fd.Num == num {
+				t, level = x, l
+			}
+		}
+	}
+	v.release()
+	if t == nil {
+		return false
+	}
+
+	// Tables only hold entries up to the last flushed sequence number, so
+	// entries with greater ones are known to be written afterward.
+	rec := &sessionRecord{}
+	rec.delTable(level, num)
+	rec.addLostRange(level, num, db.s.stSeqNum, t.imin, t.imax)
+	if err := db.s.commit(rec, true); err != nil {
+		db.log(opt.LogError, "table@quarantine commit failed", lfTable(num), lfErr(err))
+		return false
+	}
+	atomic.AddUint64(&db.tickers.quarantinedTables, 1)
+	db.log(opt.LogError, "table@quarantine", lfTable(num), lfLevel(level), lfMin(t.imin), lfMax(t.imax), lfErr(cause))
+	return true
+}
+
+// ClearDataLoss forgets the key ranges of the tables quarantined so far, so
+// that reads touching them no longer return ErrDataLoss. The quarantined
+// files are still moved aside, not removed, and can be inspected or removed
+// afterward.
+func (db *DB) ClearDataLoss() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	select {
+	case err := <-db.compPerErrC:
+		return err
+	default:
+	}
+
+	rec := &sessionRecord{}
+	rec.clearLostRanges()
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	return db.s.commit(rec, true)
+}
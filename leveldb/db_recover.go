@@ -0,0 +1,174 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"io"
+
+	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/journal"
+	"github.com/golang-update/goleveldb/leveldb/memdb"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/util"
+)
+
+// RecoveryReport describes what was salvaged from a DB by RecoverWithReport,
+// or would be by RecoverDryRun.
+type RecoveryReport struct {
+	// DryRun is true if the DB was only analyzed, without being modified.
+	DryRun bool
+
+	// Tables describes the table files found, in file number order.
+	Tables []TableRecovery
+
+	// SkippedJournalRecords are the journal chunks and records skipped
+	// because they are corrupted, in journal order.
+	SkippedJournalRecords []SkippedJournalRecord
+
+	// Totals over all tables: the keys recovered, the good and corrupted
+	// keys read, the corrupted blocks and the dropped tables.
+	RecoveredKeys   int
+	GoodKeys        int
+	CorruptedKeys   int
+	CorruptedBlocks int
+	DroppedTables   int
+
+	// MaxSeq is the greatest sequence number of the recovered tables.
+	MaxSeq uint64
+}
+
+// DroppedFiles returns the table files dropped from the DB.
+func (r *RecoveryReport) DroppedFiles() []storage.FileDesc {
+	var fds []storage.FileDesc
+	for _, t := range r.Tables {
+		if t.Dropped {
+			fds = append(fds, t.Fd)
+		}
+	}
+	return fds
+}
+
+// TableRecovery describes the recovery of a table file.
+type TableRecovery struct {
+	Fd storage.FileDesc
+
+	// Size is the size of the file before recovery.
+	Size int64
+
+	// Min and Max are the smallest and largest user keys of the good
+	// entries, and MaxSeq is their greatest sequence number.
+	Min, Max []byte
+	MaxSeq   uint64
+
+	GoodKeys      int
+	CorruptedKeys int
+
+	// CorruptedBlocks are the offsets of the corrupted blocks.
+	CorruptedBlocks []int64
+
+	// Rebuilt is true if the table is rewritten without its corrupted
+	// entries. Dropped is true if the table is removed from the DB, either
+	// because it has no good entry, or because it is corrupted and
+	// opt.StrictRecovery is set.
+	Rebuilt bool
+	Dropped bool
+}
+
+// SkippedJournalRecord describes a corrupted journal chunk or record skipped
+// while replaying journals.
+type SkippedJournalRecord struct {
+	Fd storage.FileDesc
+
+	// Size is the number of bytes skipped, or zero if unknown.
+	Size int
+
+	Err error
+}
+
+// RecoverDryRun analyzes the DB of the given storage as Recover would,
+// without modifying it, and returns a report of what Recover would salvage.
+// To analyze a DB on disk, the storage can be opened read-only with
+// storage.OpenFile.
+func RecoverDryRun(stor storage.Storage, o *opt.Options) (*RecoveryReport, error) {
+	s, err := newSession(stor, o)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.close()
+		s.release()
+	}()
+
+	report := &RecoveryReport{DryRun: true}
+	s.recReport = report
+	if err := recoverTable(s, o, report, true); err != nil {
+		return report, err
+	}
+	return report, recoverJournalDryRun(s, report.MaxSeq)
+}
+
+// Replays all journals as the DB opened by Recover would, starting at the
+// given sequence number, without writing anything.
+func recoverJournalDryRun(s *session, seq uint64) error {
+	fds, err := s.stor.List(storage.TypeJournal)
+	if err != nil {
+		return err
+	}
+	sortFds(fds)
+
+	var (
+		strict      = s.o.GetStrict(opt.StrictJournal)
+		checksum    = s.o.GetStrict(opt.StrictJournalChecksum)
+		writeBuffer = s.o.GetWriteBuffer()
+
+		mdb = memdb.New(s.icmp, writeBuffer)
+		buf = &util.Buffer{}
+	)
+	for _, fd := range fds {
+		s.log(opt.LogInfo, "journal@recovery analyzing", lf("journal", fd.Num))
+		fr, err := s.stor.Open(fd)
+		if err != nil {
+			return err
+		}
+		jr := journal.NewReader(fr, dropper{s, fd}, strict, checksum)
+		for {
+			r, err := jr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				buf.Reset()
+				_, err = buf.ReadFrom(r)
+				if err == io.ErrUnexpectedEOF {
+					// Dropped, with strict == false.
+					continue
+				}
+			}
+			if err != nil {
+				fr.Close()
+				return errors.SetFd(err, fd)
+			}
+			batchSeq, batchLen, err := decodeBatchToMem(buf.Bytes(), seq, mdb)
+			if err != nil {
+				if !strict && errors.IsCorrupted(err) {
+					s.log(opt.LogWarn, "journal@recovery skipped error", lfErr(err))
+					s.reportSkippedRecord(fd, buf.Len(), err)
+					continue
+				}
+				fr.Close()
+				return errors.SetFd(err, fd)
+			}
+			seq = batchSeq + uint64(batchLen)
+			if mdb.Size() >= writeBuffer {
+				mdb.Reset()
+			}
+		}
+		fr.Close()
+	}
+	return nil
+}
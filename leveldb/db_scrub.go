@@ -0,0 +1,182 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+)
+
+// How long the scrubber waits before looking again for tables to verify,
+// when the DB has none.
+const scrubIdleInterval = 10 * time.Second
+
+// How often the scrubber records its progress in the manifest. It is also
+// recorded at the end of each pass and when the DB is closed.
+const scrubCommitInterval = time.Minute
+
+// The scrubber verifies every live table in turn, in ascending file number
+// order, starting after the table recorded in the manifest by the previous
+// run. Tables created during a pass have greater numbers and are verified
+// by the same pass. Progress is only recorded if persist is true.
+func (db *DB) scrubber(persist bool) {
+	defer db.closeW.Done()
+
+	bandwidth := db.s.o.GetScrubBandwidth()
+	ptr := db.s.stScrubPtr
+	committed, lastCommit := ptr, time.Now()
+	commit := func(force bool) {
+		if !persist || ptr == committed || (!force && time.Since(lastCommit) < scrubCommitInterval) {
+			return
+		}
+		if db.scrubCommit(ptr) {
+			committed = ptr
+		}
+		lastCommit = time.Now()
+	}
+	defer func() { commit(true) }()
+
+	lim := &scrubLimiter{db: db, bandwidth: bandwidth}
+	db.log(opt.LogInfo, "scrub@start", lf("bandwidth", bandwidth), lf("after", ptr))
+	for {
+		num, ok := db.scrubNext(ptr, lim)
+		if db.isClosed() {
+			// The table may not have been fully verified.
+			return
+		}
+		if !ok {
+			if ptr == 0 {
+				// No table at all.
+				if !db.scrubSleep(scrubIdleInterval) {
+					return
+				}
+				continue
+			}
+			atomic.AddUint64(&db.tickers.scrubPasses, 1)
+			db.log(opt.LogInfo, "scrub@pass done")
+			num = 0
+		}
+		ptr = num
+		commit(ptr == 0)
+	}
+}
+
+// scrubLimiter limits the read bandwidth of the scrubber, it is only used
+// by the scrubber goroutine.
+type scrubLimiter struct {
+	db        *DB
+	bandwidth int
+	start     time.Time
+	read      int64
+}
+
+func (l *scrubLimiter) reset() {
+	l.start, l.read = time.Now(), 0
+}
+
+// Charges n bytes read, then sleeps until the bytes read since the last
+// reset fit the bandwidth. Returns ErrClosed if the DB is closed.
+func (l *scrubLimiter) wait(n int) error {
+	atomic.AddUint64(&l.db.tickers.scrubBytes, uint64(n))
+	l.read += int64(n)
+	if !l.db.scrubSleep(time.Duration(float64(l.read)/float64(l.bandwidth)*float64(time.Second)) - time.Since(l.start)) {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Verifies the live table with the smallest number greater than ptr, and
+// returns its number. Returns false if there is no such table.
+func (db *DB) scrubNext(ptr int64, lim *scrubLimiter) (num int64, ok bool) {
+	// Hold the version, so that the table isn't removed while verified.
+	v := db.s.version()
+	defer v.release()
+
+	var (
+		t     *tFile
+		level int
+	)
+	for l, tables := range v.levels {
+		for _, x := range tables {
+			if x.fd.Num > ptr && (t == nil || x.fd.Num < t.fd.Num) {
+				t, level = x, l
+			}
+		}
+	}
+	if t == nil {
+		return 0, false
+	}
+
+	lim.reset()
+	err := db.s.tops.verify(t, lim.wait)
+	if err == ErrClosed {
+		return t.fd.Num, true
+	}
+	atomic.AddUint64(&db.tickers.scrubTables, 1)
+	switch {
+	case err == nil:
+	case errors.IsCorrupted(err):
+		atomic.AddUint64(&db.tickers.scrubCorruptions, 1)
+		db.log(opt.LogError, "scrub@corrupted", lfFile(t.fd), lfLevel(level), lfErr(err))
+		db.s.o.GetEventListener().OnCorruption(opt.CorruptionInfo{
+			Table: opt.TableFileInfo{Num: t.fd.Num, Level: level, Size: t.size},
+			Err:   err,
+		})
+		if db.s.o.GetQuarantineCorruptedTables() && db.bgTryEnter() {
+			db.quarantineTable(t.fd.Num, err, false)
+			db.bgLeave()
+		}
+	default:
+		// E.g. an I/O error. The table is verified again by the next pass.
+		db.log(opt.LogWarn, "scrub@verify failed", lfFile(t.fd), lfErr(err))
+	}
+	return t.fd.Num, true
+}
+
+// Records the scrubber progress in the manifest. It is skipped while
+// background work is paused, or if the DB is read-only or has hit a
+// persistent error. Returns false if the progress wasn't recorded.
+func (db *DB) scrubCommit(ptr int64) bool {
+	if !db.bgTryEnter() {
+		return false
+	}
+	defer db.bgLeave()
+
+	rec := &sessionRecord{}
+	rec.setScrubPtr(ptr)
+	db.compCommitLk.Lock()
+	err := db.s.commit(rec, true)
+	db.compCommitLk.Unlock()
+	if err != nil {
+		db.log(opt.LogWarn, "scrub@commit failed", lfErr(err))
+		return false
+	}
+	return true
+}
+
+// Sleeps for the given duration. Returns false if the DB is closed.
+func (db *DB) scrubSleep(d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-db.closeC:
+			return false
+		default:
+			return true
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-db.closeC:
+		return false
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/golang-update/goleveldb/leveldb/journal"
 	"github.com/golang-update/goleveldb/leveldb/memdb"
+	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
 )
 
@@ -198,10 +199,12 @@ func (db *DB) getFrozenMem() *memDB {
 // Drop frozen memdb; assume that frozen memdb isn't nil.
 func (db *DB) dropFrozenMem() {
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd); err != nil {
-		db.logf("journal@remove removing @%d %q", db.frozenJournalFd.Num, err)
+	if deferred, err := db.s.removeFile(db.frozenJournalFd); err != nil {
+		db.log(opt.LogError, "journal@remove failed", lf("journal", db.frozenJournalFd.Num), lfErr(err))
+	} else if deferred {
+		db.log(opt.LogDebug, "journal@remove deferred", lf("journal", db.frozenJournalFd.Num))
 	} else {
-		db.logf("journal@remove removed @%d", db.frozenJournalFd.Num)
+		db.log(opt.LogDebug, "journal@remove removed", lf("journal", db.frozenJournalFd.Num))
 	}
 	db.frozenJournalFd = storage.FileDesc{}
 	db.frozenMem.decref()
@@ -9,9 +9,12 @@ package leveldb
 import (
 	"bytes"
 	"container/list"
+	"context"
 	crand "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -25,12 +28,14 @@ import (
 
 	"github.com/onsi/gomega"
 
+	"github.com/golang-update/goleveldb/leveldb/cache"
 	"github.com/golang-update/goleveldb/leveldb/comparer"
 	"github.com/golang-update/goleveldb/leveldb/errors"
 	"github.com/golang-update/goleveldb/leveldb/filter"
 	"github.com/golang-update/goleveldb/leveldb/iterator"
 	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/table"
 	"github.com/golang-update/goleveldb/leveldb/testutil"
 	"github.com/golang-update/goleveldb/leveldb/util"
 )
@@ -2611,7 +2616,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create(0)
+		tw, err := s.tops.create(0, s.o.GetCompression())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -3000,3 +3005,1667 @@ func TestDB_GracefulClose(t *testing.T) {
 	iter.Release()
 	closeWait.Wait()
 }
+
+func TestDB_ConcurrentCompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction:  true,
+		Compression:                   opt.NoCompression,
+		CompactionConcurrency:         4,
+		CompactionTableSize:           64 * opt.KiB,
+		CompactionTotalSize:           128 * opt.KiB,
+		CompactionTotalSizeMultiplier: 2,
+		WriteBuffer:                   128 * opt.KiB,
+	})
+	defer h.close()
+
+	var (
+		maxRunning int32
+		done       = make(chan struct{})
+		sampleWait sync.WaitGroup
+	)
+	sampleWait.Add(1)
+	go func() {
+		defer sampleWait.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			h.db.s.cmu.Lock()
+			n := int32(len(h.db.s.stCompactions))
+			h.db.s.cmu.Unlock()
+			if n > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, n)
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	// Writes are clustered into disjoint key regions, so that compactions
+	// of different regions don't conflict with each other.
+	const regions, n = 8, 2000
+	rnd := rand.New(rand.NewSource(0))
+	want := make(map[string][]byte)
+	for i := 0; i < 4*regions*n; i++ {
+		key := fmt.Sprintf("%02d-%08d", (i/n)%regions, rnd.Intn(n))
+		value := randomString(rnd, 64)
+		if err := h.db.Put([]byte(key), value, h.wo); err != nil {
+			t.Fatal("Put error: ", err)
+		}
+		want[key] = value
+	}
+	h.compactMem()
+	h.waitCompaction()
+	close(done)
+	sampleWait.Wait()
+	t.Logf("max concurrent compactions: %d, tables: %s", atomic.LoadInt32(&maxRunning), h.getTablesPerLevel())
+	if n := atomic.LoadInt32(&maxRunning); n <= 1 {
+		t.Errorf("compactions never overlapped, max concurrent compactions: %d", n)
+	}
+
+	v := h.db.s.version()
+	for level, tables := range v.levels {
+		if level == 0 {
+			continue
+		}
+		for i := 1; i < len(tables); i++ {
+			if h.db.s.icmp.Compare(tables[i-1].imax, tables[i].imin) >= 0 {
+				t.Errorf("level %d: table @%d overlaps with @%d", level, tables[i-1].fd.Num, tables[i].fd.Num)
+			}
+		}
+	}
+	v.release()
+
+	for key, value := range want {
+		h.getVal(key, string(value))
+	}
+	h.assertNumKeys(len(want))
+}
+
+func TestDB_DynamicLevelBytes(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction:  true,
+		Compression:                   opt.NoCompression,
+		CompactionDynamicLevelBytes:   true,
+		CompactionNumLevels:           5,
+		CompactionTableSize:           32 * opt.KiB,
+		CompactionTotalSize:           64 * opt.KiB,
+		CompactionTotalSizeMultiplier: 4,
+		WriteBuffer:                   64 * opt.KiB,
+	})
+	defer h.close()
+
+	rnd := rand.New(rand.NewSource(0))
+	want := make(map[string][]byte)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("%08d", rnd.Intn(10000))
+		value := randomString(rnd, 64)
+		if err := h.db.Put([]byte(key), value, h.wo); err != nil {
+			t.Fatal("Put error: ", err)
+		}
+		want[key] = value
+	}
+	h.compactMem()
+	h.waitCompaction()
+	t.Logf("tables: %s", h.getTablesPerLevel())
+
+	v := h.db.s.version()
+	if v.baseLevel <= 1 {
+		t.Errorf("base level is %d, want it above level-1", v.baseLevel)
+	}
+	for level := 1; level < v.baseLevel && level < len(v.levels); level++ {
+		if n := len(v.levels[level]); n > 0 {
+			t.Errorf("level %d above base level %d has %d tables", level, v.baseLevel, n)
+		}
+	}
+	if len(v.levels) > 5 {
+		t.Errorf("got %d levels, want at most 5", len(v.levels))
+	}
+	v.release()
+
+	for key, value := range want {
+		h.getVal(key, string(value))
+	}
+	h.assertNumKeys(len(want))
+}
+
+func TestDB_PauseBackgroundWork(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.NoCompression,
+		CompactionTableSize:          32 * opt.KiB,
+		CompactionTotalSize:          64 * opt.KiB,
+		WriteBuffer:                  64 * opt.KiB,
+	})
+	defer h.close()
+
+	listFiles := func() map[storage.FileDesc]bool {
+		fds, err := h.stor.List(storage.TypeTable | storage.TypeJournal | storage.TypeManifest)
+		if err != nil {
+			t.Fatal("List error: ", err)
+		}
+		m := make(map[storage.FileDesc]bool)
+		for _, fd := range fds {
+			m[fd] = true
+		}
+		return m
+	}
+
+	rnd := rand.New(rand.NewSource(0))
+	want := make(map[string][]byte)
+	put := func(n int) {
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("%08d", rnd.Intn(5000))
+			value := randomString(rnd, 64)
+			if err := h.db.Put([]byte(key), value, h.wo); err != nil {
+				t.Fatal("Put error: ", err)
+			}
+			want[key] = value
+		}
+	}
+
+	for _, cancel := range []bool{false, true} {
+		put(10000)
+		if err := h.db.PauseBackgroundWork(cancel); err != nil {
+			t.Fatal("PauseBackgroundWork error: ", err)
+		}
+		before := listFiles()
+		// Writes within the memdb capacity don't need background work.
+		put(100)
+		time.Sleep(100 * time.Millisecond)
+		after := listFiles()
+		for fd := range before {
+			if !after[fd] {
+				t.Errorf("cancel=%v: %s-%d removed while paused", cancel, fd.Type, fd.Num)
+			}
+		}
+		for fd := range after {
+			if !before[fd] {
+				t.Errorf("cancel=%v: %s-%d created while paused", cancel, fd.Type, fd.Num)
+			}
+		}
+		if err := h.db.ContinueBackgroundWork(); err != nil {
+			t.Fatal("ContinueBackgroundWork error: ", err)
+		}
+	}
+	if err := h.db.ContinueBackgroundWork(); err == nil {
+		t.Error("ContinueBackgroundWork: expecting error when not paused")
+	}
+
+	h.compactMem()
+	h.waitCompaction()
+	for key, value := range want {
+		h.getVal(key, string(value))
+	}
+	h.assertNumKeys(len(want))
+
+	h.reopenDB()
+	for key, value := range want {
+		h.getVal(key, string(value))
+	}
+}
+
+func TestDB_PauseBackgroundWorkCancel(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		Compression:                  opt.NoCompression,
+	})
+	defer h.close()
+
+	for i := 0; i < 4; i++ {
+		if i == 3 {
+			// Block the compaction triggered by the fourth level-0 table.
+			h.stor.Stall(testutil.ModeRead, storage.TypeTable)
+		}
+		for j := 0; j < 100; j++ {
+			h.put(fmt.Sprintf("%03d", j), fmt.Sprint(i))
+		}
+		h.compactMem()
+	}
+	// Wait for the compaction to start.
+	for i := 0; ; i++ {
+		h.db.compTrigger(h.db.tcompCmdC)
+		h.db.s.cmu.Lock()
+		n := len(h.db.s.stCompactions)
+		h.db.s.cmu.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("compaction not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pauseDone := make(chan error)
+	go func() {
+		pauseDone <- h.db.PauseBackgroundWork(true)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	h.stor.Release(testutil.ModeRead, storage.TypeTable)
+	if err := <-pauseDone; err != nil {
+		t.Fatal("PauseBackgroundWork error: ", err)
+	}
+
+	h.tablesPerLevel("4")
+	fds, err := h.stor.List(storage.TypeTable)
+	if err != nil {
+		t.Fatal("List error: ", err)
+	}
+	if len(fds) != 4 {
+		t.Errorf("got %d table files after canceled compaction, want 4", len(fds))
+	}
+
+	if err := h.db.ContinueBackgroundWork(); err != nil {
+		t.Fatal("ContinueBackgroundWork error: ", err)
+	}
+	h.waitCompaction()
+	h.tablesPerLevel("0,1")
+	h.assertNumKeys(100)
+	h.getVal("000", "3")
+}
+
+type testEventListener struct {
+	mu              sync.Mutex
+	flushBegin      []opt.FlushInfo
+	flushCompleted  []opt.FlushInfo
+	compBegin       []opt.CompactionInfo
+	compCompleted   []opt.CompactionInfo
+	created         map[int64]opt.TableFileInfo
+	deleted         map[int64]bool
+	writeStalls     []opt.WriteStallInfo
+	backgroundError []error
+	corruptions     []opt.CorruptionInfo
+}
+
+func (l *testEventListener) OnFlushBegin(info opt.FlushInfo) {
+	l.mu.Lock()
+	l.flushBegin = append(l.flushBegin, info)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnFlushCompleted(info opt.FlushInfo) {
+	l.mu.Lock()
+	l.flushCompleted = append(l.flushCompleted, info)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnCompactionBegin(info opt.CompactionInfo) {
+	l.mu.Lock()
+	l.compBegin = append(l.compBegin, info)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnCompactionCompleted(info opt.CompactionInfo) {
+	l.mu.Lock()
+	l.compCompleted = append(l.compCompleted, info)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnTableFileCreated(info opt.TableFileInfo) {
+	l.mu.Lock()
+	l.created[info.Num] = info
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnTableFileDeleted(info opt.TableFileInfo) {
+	l.mu.Lock()
+	l.deleted[info.Num] = true
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnWriteStall(info opt.WriteStallInfo) {
+	l.mu.Lock()
+	l.writeStalls = append(l.writeStalls, info)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnBackgroundError(err error) {
+	l.mu.Lock()
+	l.backgroundError = append(l.backgroundError, err)
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) OnCorruption(info opt.CorruptionInfo) {
+	l.mu.Lock()
+	l.corruptions = append(l.corruptions, info)
+	l.mu.Unlock()
+}
+
+func TestDB_EventListener(t *testing.T) {
+	l := &testEventListener{
+		created: make(map[int64]opt.TableFileInfo),
+		deleted: make(map[int64]bool),
+	}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableCompactionBackoff:     true,
+		Compression:                  opt.NoCompression,
+		EventListener:                l,
+		WriteBuffer:                  64 * opt.KiB,
+		WriteL0SlowdownTrigger:       2,
+	})
+	defer h.close()
+
+	rnd := rand.New(rand.NewSource(0))
+	for i := 0; i < 5000; i++ {
+		h.put(fmt.Sprintf("%08d", rnd.Intn(2000)), string(randomString(rnd, 64)))
+	}
+	h.compactMem()
+	h.waitCompaction()
+	h.put(fmt.Sprintf("%08d", 0), "v")
+	h.put(fmt.Sprintf("%08d", 1999), "v")
+	h.compactRange("", "")
+
+	// Tables are deleted asynchronously.
+	for i := 0; i < 100; i++ {
+		l.mu.Lock()
+		n := len(l.deleted)
+		l.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l.mu.Lock()
+	if len(l.flushBegin) == 0 || len(l.flushBegin) != len(l.flushCompleted) {
+		t.Errorf("got %d flush begin and %d flush completed events", len(l.flushBegin), len(l.flushCompleted))
+	}
+	for _, info := range l.flushCompleted {
+		if info.Entries == 0 || len(info.Tables) == 0 {
+			t.Errorf("invalid flush event: %+v", info)
+		}
+		for _, tf := range info.Tables {
+			if _, ok := l.created[tf.Num]; !ok {
+				t.Errorf("no created event for flushed table @%d", tf.Num)
+			}
+		}
+	}
+
+	if len(l.compBegin) == 0 || len(l.compBegin) != len(l.compCompleted) {
+		t.Errorf("got %d compaction begin and %d compaction completed events", len(l.compBegin), len(l.compCompleted))
+	}
+	var manual bool
+	for _, info := range l.compCompleted {
+		if info.Reason == opt.CompactionReasonManual {
+			manual = true
+		}
+		if len(info.Inputs) == 0 || info.TargetLevel <= info.SourceLevel {
+			t.Errorf("invalid compaction event: %+v", info)
+		}
+		for _, tf := range info.Outputs {
+			if tf.Level != info.TargetLevel {
+				t.Errorf("output table @%d at level %d, want %d", tf.Num, tf.Level, info.TargetLevel)
+			}
+		}
+	}
+	if !manual {
+		t.Error("no manual compaction event")
+	}
+
+	v := h.db.s.version()
+	for _, tables := range v.levels {
+		for _, tf := range tables {
+			if _, ok := l.created[tf.fd.Num]; !ok {
+				t.Errorf("no created event for table @%d", tf.fd.Num)
+			}
+			if l.deleted[tf.fd.Num] {
+				t.Errorf("deleted event for live table @%d", tf.fd.Num)
+			}
+		}
+	}
+	v.release()
+	if len(l.deleted) == 0 {
+		t.Error("no deleted events")
+	}
+
+	if len(l.writeStalls) == 0 || l.writeStalls[0].Cur != opt.WriteStallDelayed {
+		t.Errorf("invalid write stall events: %v", l.writeStalls)
+	}
+	l.mu.Unlock()
+
+	h.stor.EmulateErrorOnce(testutil.ModeSync, storage.TypeTable, errors.New("sync error"))
+	h.put("foo", "v")
+	// The error may or may not be seen by the caller.
+	for i := 0; h.db.CompactRange(util.Range{}) != nil; i++ {
+		if i == 100 {
+			t.Fatal("compaction error not recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.mu.Lock()
+	if len(l.backgroundError) == 0 {
+		t.Error("no background error events")
+	}
+	l.mu.Unlock()
+}
+
+type testLogRecord struct {
+	level  opt.LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	records []testLogRecord
+}
+
+func (l *testLogger) Log(level opt.LogLevel, msg string, fields ...opt.LogField) {
+	r := testLogRecord{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		r.fields[f.Key] = f.Value
+	}
+	l.mu.Lock()
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+}
+
+func (l *testLogger) find(msg string) []testLogRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []testLogRecord
+	for _, r := range l.records {
+		if r.msg == msg {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func TestDB_Logger(t *testing.T) {
+	l := &testLogger{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Logger:                       l,
+	})
+	defer h.close()
+
+	for i := 0; i < 4; i++ {
+		h.put("foo", fmt.Sprint(i))
+		h.put("bar", fmt.Sprint(i))
+		h.compactMem()
+	}
+	h.compactRange("", "")
+
+	flushes := l.find("memdb@flush created")
+	if len(flushes) == 0 {
+		t.Fatal("no memdb flush records")
+	}
+	for _, r := range flushes {
+		if r.level != opt.LogDebug {
+			t.Errorf("memdb flush record level is %v", r.level)
+		}
+		if _, ok := r.fields["table"].(int64); !ok {
+			t.Errorf("memdb flush record has no table number: %v", r.fields)
+		}
+		if _, ok := r.fields["size"].(int64); !ok {
+			t.Errorf("memdb flush record has no size: %v", r.fields)
+		}
+	}
+	compactions := l.find("table@compaction")
+	if len(compactions) == 0 {
+		t.Fatal("no table compaction records")
+	}
+	for _, r := range compactions {
+		if r.fields["source_level"] != 0 || r.fields["target_level"] != 1 {
+			t.Errorf("unexpected table compaction record: %v", r.fields)
+		}
+	}
+}
+
+func TestDB_SlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	})
+	logger := slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	h := newDbHarnessWopt(t, &opt.Options{
+		Logger: opt.NewSlogLogger(logger),
+	})
+	h.put("foo", "v1")
+	h.compactMem()
+	h.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		if rec["level"] == "DEBUG" {
+			t.Errorf("debug record logged: %s", line)
+		}
+		if rec["msg"] == "memdb@flush committed" {
+			found = true
+			if rec["files"] != float64(1) {
+				t.Errorf("unexpected memdb flush record: %s", line)
+			}
+		}
+	}
+	if !found {
+		t.Error("no memdb flush record")
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestDB_LatencyHistograms(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		LatencyHistograms:            true,
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), "v")
+	}
+	if err := h.db.Put([]byte("sync"), []byte("v"), &opt.WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	h.getVal("k000", "v")
+	if ok, err := h.db.Has([]byte("k001"), nil); !ok || err != nil {
+		t.Fatalf("Has: ok=%v err=%v", ok, err)
+	}
+	iter := h.db.NewIterator(nil, nil)
+	iter.Seek([]byte("k050"))
+	for iter.Next() {
+	}
+	iter.Release()
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+
+	s := &DBStats{}
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal(err)
+	}
+	for name, min := range map[string]uint64{
+		"get":              1,
+		"has":              1,
+		"write":            101,
+		"write.lock":       1,
+		"write.journal":    1,
+		"write.sync":       1,
+		"write.memdb":      1,
+		"iter.seek":        1,
+		"iter.next":        50,
+		"memdb.flush":      1,
+		"table.compaction": 1,
+	} {
+		l, ok := s.Latencies[name]
+		if !ok {
+			t.Errorf("missing latency histogram %q", name)
+			continue
+		}
+		if l.Count < min {
+			t.Errorf("latency %q: got count %d, want at least %d", name, l.Count, min)
+		}
+		if l.Count > 0 && (l.Percentile(99) <= 0 || l.Percentile(99) > l.Max) {
+			t.Errorf("latency %q: invalid p99 %v (max %v)", name, l.Percentile(99), l.Max)
+		}
+	}
+
+	v, err := h.db.GetProperty("leveldb.latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(v, "write.sync count=1 ") {
+		t.Errorf("unexpected leveldb.latency property:\n%s", v)
+	}
+	v, err = h.db.GetProperty("leveldb.latency.get")
+	if err != nil || !strings.HasPrefix(v, "count=1 ") {
+		t.Errorf("unexpected leveldb.latency.get property: %q, %v", v, err)
+	}
+	if _, err := h.db.GetProperty("leveldb.latency.foo"); err != ErrNotFound {
+		t.Errorf("leveldb.latency.foo: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestDB_LatencyHistogramsDisabled(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("foo", "v")
+	h.getVal("foo", "v")
+	s := &DBStats{}
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Latencies != nil {
+		t.Errorf("got latencies %v, want nil", s.Latencies)
+	}
+	if _, err := h.db.GetProperty("leveldb.latency"); err != ErrNotFound {
+		t.Errorf("leveldb.latency: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestDB_PerfContext(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Filter:                       filter.NewBloomFilter(10),
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), "v")
+	}
+	h.compactMem()
+
+	pc := &opt.PerfContext{}
+	ro := &opt.ReadOptions{PerfContext: pc}
+	if v, err := h.db.Get([]byte("k050"), ro); err != nil || string(v) != "v" {
+		t.Fatalf("Get: %q, %v", v, err)
+	}
+	if pc.TablesProbed != 1 || pc.FilterChecks != 1 || pc.FilterHits != 1 {
+		t.Errorf("Get: unexpected table and filter counts: %v", pc)
+	}
+	if pc.BlocksRead == 0 || pc.BlockReadBytes == 0 || pc.BlockCacheMisses == 0 || pc.BlockCacheHits != 0 || pc.TableTime == 0 {
+		t.Errorf("Get: unexpected block counts: %v", pc)
+	}
+
+	pc.Reset()
+	if _, err := h.db.Get([]byte("k051"), ro); err != nil {
+		t.Fatal(err)
+	}
+	if pc.BlocksRead != 0 || pc.BlockCacheHits == 0 || pc.BlockCacheMisses != 0 {
+		t.Errorf("Get: blocks not read from cache: %v", pc)
+	}
+
+	pc.Reset()
+	for i := 0; i < 99; i++ {
+		if _, err := h.db.Get([]byte(fmt.Sprintf("k%03dx", i)), ro); err != ErrNotFound {
+			t.Fatalf("Get: got %v, want ErrNotFound", err)
+		}
+	}
+	if pc.FilterChecks != 99 || pc.FilterHits >= pc.FilterChecks {
+		t.Errorf("Get: unexpected filter counts for missing keys: %v", pc)
+	}
+
+	// Memdb hits don't probe tables.
+	h.put("k050", "v2")
+	pc.Reset()
+	if ok, err := h.db.Has([]byte("k050"), ro); !ok || err != nil {
+		t.Fatalf("Has: %v, %v", ok, err)
+	}
+	if pc.TablesProbed != 0 {
+		t.Errorf("Has: unexpected tables probed: %v", pc)
+	}
+
+	h.delete("k051")
+	pc.Reset()
+	iter := h.db.NewIterator(&util.Range{Start: []byte("k050"), Limit: []byte("k053")}, ro)
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	if got, want := strings.Join(keys, ","), "k050,k052"; got != want {
+		t.Errorf("Iterator: got keys %q, want %q", got, want)
+	}
+	if pc.InternalKeysSkipped != 2 || pc.TombstonesSkipped != 1 {
+		t.Errorf("Iterator: unexpected skip counts: %v", pc)
+	}
+
+	pc.Reset()
+	iter = h.db.NewIterator(&util.Range{Start: []byte("k050"), Limit: []byte("k053")}, ro)
+	keys = keys[:0]
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	if got, want := strings.Join(keys, ","), "k052,k050"; got != want {
+		t.Errorf("Iterator: got reverse keys %q, want %q", got, want)
+	}
+	if pc.InternalKeysSkipped != 2 || pc.TombstonesSkipped != 1 {
+		t.Errorf("Iterator: unexpected reverse skip counts: %v", pc)
+	}
+}
+
+func TestDB_StatsTickers(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Filter:                       filter.NewBloomFilter(10),
+	})
+	defer h.close()
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value)
+	}
+	h.compactMem()
+	h.getVal("k010", value)
+	h.getVal("k011", value)
+	for i := 0; i < 50; i++ {
+		h.getr(h.db, fmt.Sprintf("k%03dx", i), false)
+	}
+
+	s := &DBStats{}
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal(err)
+	}
+	tk := s.Tickers
+	if tk.DataBlockCacheMisses == 0 || tk.DataBlockCacheHits == 0 {
+		t.Errorf("unexpected data block cache counts: %+v", tk)
+	}
+	if tk.IndexBlockCacheMisses != 1 || tk.IndexBlockCacheHits == 0 {
+		t.Errorf("unexpected index block cache counts: %+v", tk)
+	}
+	if tk.FilterBlockCacheMisses != 1 || tk.FilterBlockCacheHits == 0 {
+		t.Errorf("unexpected filter block cache counts: %+v", tk)
+	}
+	if tk.FilterFullPositive < 2 || tk.FilterUseful == 0 || tk.FilterFullPositive+tk.FilterUseful != 52 {
+		t.Errorf("unexpected filter counts: %+v", tk)
+	}
+	if tk.UserReadBytes == 0 || tk.CompactionReadBytes != 0 {
+		t.Errorf("unexpected read bytes: %+v", tk)
+	}
+	if len(tk.LevelKeysWritten) != len(s.LevelSizes) || tk.LevelKeysWritten[0] != 100 {
+		t.Errorf("unexpected level keys written: %v", tk.LevelKeysWritten)
+	}
+
+	h.compactRangeAt(0, "", "")
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Tickers.CompactionReadBytes == 0 || s.Tickers.UserReadBytes != tk.UserReadBytes {
+		t.Errorf("unexpected read bytes after compaction: %+v", s.Tickers)
+	}
+	if s.Tickers.DataBlockCacheMisses != tk.DataBlockCacheMisses || s.Tickers.IndexBlockCacheMisses != tk.IndexBlockCacheMisses {
+		t.Errorf("compaction reads counted as cache misses: %+v", s.Tickers)
+	}
+	if s.Tickers.LevelKeysWritten[1] != 100 {
+		t.Errorf("unexpected level keys written after compaction: %v", s.Tickers.LevelKeysWritten)
+	}
+
+	if err := h.db.ResetStats(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.db.Stats(s); err != nil {
+		t.Fatal(err)
+	}
+	tk = s.Tickers
+	if tk.DataBlockCacheHits != 0 || tk.FilterUseful != 0 || tk.UserReadBytes != 0 || tk.CompactionReadBytes != 0 || tk.LevelKeysWritten[0] != 0 {
+		t.Errorf("tickers not reset: %+v", tk)
+	}
+}
+
+func TestDB_SetOptions(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+	})
+	defer h.close()
+
+	value := strings.Repeat("x", 1000)
+	h.put("a", value)
+	h.compactMem()
+
+	if err := h.db.SetOptions(map[string]string{
+		"WriteBuffer":            "1048576",
+		"CompactionL0Trigger":    "2",
+		"WriteL0SlowdownTrigger": "16",
+		"WriteL0PauseTrigger":    "24",
+		"BlockCacheCapacity":     "1024",
+		"Compression":            "none",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	o := h.db.s.o
+	if o.GetWriteBuffer() != 1048576 || o.GetCompactionL0Trigger() != 2 || o.GetWriteL0SlowdownTrigger() != 16 ||
+		o.GetWriteL0PauseTrigger() != 24 || o.GetCompression() != opt.NoCompression {
+		t.Errorf("options not applied")
+	}
+	if c := h.db.s.tops.blockCache.Capacity(); c != 1024 {
+		t.Errorf("block cache capacity: got %d, want 1024", c)
+	}
+
+	// New tables are written uncompressed.
+	h.put("b", value)
+	h.compactMem()
+	v := h.db.s.version()
+	tables := v.levels[0]
+	if len(tables) != 2 || tables[0].size < 4*tables[1].size {
+		t.Errorf("new table is compressed: %v", tables)
+	}
+	v.release()
+	h.getVal("a", value)
+	h.getVal("b", value)
+
+	for _, x := range []struct {
+		options map[string]string
+		errStr  string
+	}{
+		{map[string]string{"BlockSize": "8192"}, "can't be changed at runtime"},
+		{map[string]string{"Foo": "1"}, "unknown option"},
+		{map[string]string{"WriteBuffer": "x"}, "invalid value"},
+		{map[string]string{"WriteBuffer": "0"}, "invalid value"},
+		{map[string]string{"Compression": "lz77"}, "invalid value"},
+		{map[string]string{"WriteBuffer": "2048", "WriteL0SlowdownTrigger": "32"}, "must not be greater"},
+	} {
+		err := h.db.SetOptions(x.options)
+		if err == nil || !strings.Contains(err.Error(), x.errStr) {
+			t.Errorf("SetOptions(%v): got error %v, want %q", x.options, err, x.errStr)
+		}
+	}
+	if o.GetWriteBuffer() != 1048576 || o.GetWriteL0SlowdownTrigger() != 16 {
+		t.Errorf("options changed by failed SetOptions")
+	}
+}
+
+func TestDB_SetOptionsL0Trigger(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+	})
+	defer h.close()
+
+	for i := 0; i < 2; i++ {
+		h.put("a", fmt.Sprintf("v%d", i))
+		h.compactMem()
+	}
+	h.tablesPerLevel("2")
+
+	// Lowering the trigger starts a level-0 compaction without any write.
+	if err := h.db.SetOptions(map[string]string{"CompactionL0Trigger": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); h.db.s.tLen(0) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("level-0 not compacted, got %d tables", h.db.s.tLen(0))
+		}
+	}
+	h.getVal("a", "v1")
+}
+
+func TestDB_OptionsFile(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction:          true,
+		Comparer:                              numberComparer{},
+		Filter:                                filter.NewBloomFilter(10),
+		BlockSize:                             8192,
+		CompactionTableSizeMultiplierPerLevel: []float64{1, 2.5},
+	})
+	defer h.close()
+
+	h.put("[1]", "one")
+	h.reopenDB()
+	if err := h.db.SetOptions(map[string]string{"WriteBuffer": "1048576"}); err != nil {
+		t.Fatal(err)
+	}
+	fds, err := h.stor.List(storage.TypeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fds) != 1 {
+		t.Fatalf("got %d OPTIONS files, want 1", len(fds))
+	}
+
+	if _, err := LoadOptions(h.stor, nil, nil); err == nil || !strings.Contains(err.Error(), "unknown comparer") {
+		t.Errorf("LoadOptions without comparer: got error %v", err)
+	}
+	o, err := LoadOptions(h.stor, []comparer.Comparer{numberComparer{}}, []filter.Filter{filter.NewBloomFilter(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.GetComparer().Name() != "test.NumberComparer" || o.GetFilter().Name() != "leveldb.BuiltinBloomFilter" {
+		t.Errorf("LoadOptions: got comparer %q, filter %v", o.GetComparer().Name(), o.GetFilter())
+	}
+	if o.BlockSize != 8192 || o.WriteBuffer != 1048576 || o.Strict != opt.DefaultStrict ||
+		len(o.CompactionTableSizeMultiplierPerLevel) != 2 || o.CompactionTableSizeMultiplierPerLevel[1] != 2.5 {
+		t.Errorf("LoadOptions: got %+v", o)
+	}
+	diffs, err := CheckOptions(h.stor, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("CheckOptions with loaded options: got %v", diffs)
+	}
+
+	h.o = &opt.Options{
+		DisableLargeBatchTransaction:          true,
+		Comparer:                              numberComparer{},
+		BlockSize:                             4096,
+		CompactionNumLevels:                   5,
+		CompactionTableSizeMultiplierPerLevel: []float64{1, 2.5},
+		WriteBuffer:                           1048576,
+	}
+	diffs, err = CheckOptions(h.stor, h.o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diffs {
+		got = append(got, fmt.Sprintf("%s:%v", d.Name, d.Dangerous))
+	}
+	if want := "BlockSize:false CompactionNumLevels:true Filter:true"; strings.Join(got, " ") != want {
+		t.Errorf("CheckOptions: got %v, want %s", got, want)
+	}
+
+	// Dropping the filter is safe if it is kept as an alternative.
+	diffs, err = CheckOptions(h.stor, &opt.Options{
+		DisableLargeBatchTransaction:          true,
+		Comparer:                              numberComparer{},
+		BlockSize:                             8192,
+		Compression:                           opt.NoCompression,
+		CompactionTableSizeMultiplierPerLevel: []float64{1, 2.5},
+		WriteBuffer:                           1048576,
+		AltFilters:                            []filter.Filter{o.GetFilter()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, d := range diffs {
+		got = append(got, fmt.Sprintf("%s:%v", d.Name, d.Dangerous))
+	}
+	if want := "AltFilters:false Compression:true Filter:false"; strings.Join(got, " ") != want {
+		t.Errorf("CheckOptions with alternative filter: got %v, want %s", got, want)
+	}
+
+	// Dangerous differences are only logged by default.
+	h.reopenDB()
+	h.getVal("[1]", "one")
+	h.closeDB()
+	h.o.CompactionNumLevels = 0
+	h.openDB()
+	h.closeDB()
+
+	h.o.CompactionNumLevels = 5
+	h.o.ErrorIfOptionsMismatch = true
+	if db, err := Open(h.stor, h.o); err == nil {
+		db.Close()
+		t.Error("Open with incompatible options: expect error")
+	} else if !strings.Contains(err.Error(), ErrIncompatibleOptions.Error()) {
+		t.Errorf("Open with incompatible options: got error %v", err)
+	}
+	h.db = nil
+}
+
+func TestDB_CompressionPerLevel(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Compression:                  opt.SnappyCompression,
+		CompressionPerLevel:          []opt.Compression{opt.NoCompression, opt.LZ4Compression},
+		BottommostCompression:        opt.ZstdCompression,
+	})
+	defer h.close()
+	h.db.memdbMaxLevel = 2
+
+	// levelCompressions returns the compression of the tables of each
+	// level, as recorded in the trailer of their index block.
+	levelCompressions := func() string {
+		var res []string
+		v := h.db.s.version()
+		defer v.release()
+		for level, tables := range v.levels {
+			for _, t := range tables {
+				r, err := h.stor.Open(t.fd)
+				if err != nil {
+					h.t.Fatal(err)
+				}
+				b := make([]byte, 1)
+				_, err = r.ReadAt(b, t.size-48-5)
+				r.Close()
+				if err != nil {
+					h.t.Fatal(err)
+				}
+				name := fmt.Sprint(b[0])
+				for _, c := range []opt.Compression{opt.NoCompression, opt.SnappyCompression, opt.ZstdCompression, opt.LZ4Compression} {
+					if c.BlockType() == b[0] {
+						name = c.String()
+					}
+				}
+				res = append(res, fmt.Sprintf("%d:%s", level, name))
+			}
+		}
+		return strings.Join(res, " ")
+	}
+
+	value := strings.Repeat("v", 200)
+	h.put("a", value)
+	h.put("b", value)
+	h.compactMem()
+	// Flushes don't use the bottommost compression.
+	if got, want := levelCompressions(), "2:snappy"; got != want {
+		t.Errorf("after first flush: got %q, want %q", got, want)
+	}
+	h.put("a", value+"1")
+	h.compactMem()
+	h.put("a", value+"2")
+	h.compactMem()
+	if got, want := levelCompressions(), "0:none 1:lz4 2:snappy"; got != want {
+		t.Errorf("after flushes: got %q, want %q", got, want)
+	}
+
+	h.compactRangeAt(0, "", "")
+	if got, want := levelCompressions(), "1:lz4 2:snappy"; got != want {
+		t.Errorf("after level-0 compaction: got %q, want %q", got, want)
+	}
+	h.compactRangeAt(1, "", "")
+	if got, want := levelCompressions(), "2:zstd"; got != want {
+		t.Errorf("after bottommost compaction: got %q, want %q", got, want)
+	}
+	h.getVal("a", value+"2")
+	h.getVal("b", value)
+}
+
+func TestDB_CompressionDict(t *testing.T) {
+	tablesSize := func(dictSize int) int64 {
+		h := newDbHarnessWopt(t, &opt.Options{
+			DisableLargeBatchTransaction: true,
+			Compression:                  opt.SnappyCompression,
+			BottommostCompression:        opt.ZstdCompression,
+			CompressionDictSize:          dictSize,
+			BlockSize:                    1024,
+		})
+		defer h.close()
+
+		for i := 0; i < 2000; i++ {
+			h.put(fmt.Sprintf("k%05d", i), fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user%d@example.com","active":%v}`, i, i*7, i*13, i%2 == 0))
+		}
+		h.compactMem()
+		h.compactRangeAt(0, "", "")
+		h.tablesPerLevel("0,1")
+		for i := 0; i < 2000; i += 99 {
+			h.getVal(fmt.Sprintf("k%05d", i), fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user%d@example.com","active":%v}`, i, i*7, i*13, i%2 == 0))
+		}
+
+		// The dictionary survives reopening.
+		h.reopenDB()
+		h.getVal("k01000", `{"id":1000,"name":"user-7000","email":"user13000@example.com","active":true}`)
+
+		v := h.db.s.version()
+		defer v.release()
+		return v.levels[1].size()
+	}
+
+	plain, withDict := tablesSize(0), tablesSize(4096)
+	if withDict >= plain {
+		t.Errorf("dictionary compression doesn't reduce size: got %d, want less than %d", withDict, plain)
+	}
+}
+
+func TestDB_PartitionedIndex(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockSize:                    256,
+		IndexPartitionSize:           256,
+		Filter:                       filter.NewBloomFilter(10),
+	})
+	defer h.close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+
+	const n = 2000
+	for i := 0; i < n; i++ {
+		h.put(key(i), key(i))
+	}
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+	check := func() {
+		for i := 0; i < n; i += 7 {
+			h.getVal(key(i), key(i))
+			h.get(key(i)+".missing", false)
+		}
+		iter := h.db.NewIterator(&util.Range{Start: []byte(key(100)), Limit: []byte(key(1900))}, nil)
+		var cnt int
+		for iter.Next() {
+			if want := key(100 + cnt); string(iter.Key()) != want {
+				t.Fatalf("iterator: got key %q, want %q", iter.Key(), want)
+			}
+			cnt++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator: ", err)
+		}
+		if cnt != 1800 {
+			t.Errorf("iterator: got %d keys, want 1800", cnt)
+		}
+	}
+	check()
+	if corrupted, err := h.db.VerifyChecksum(context.Background()); err != nil || len(corrupted) > 0 {
+		t.Fatalf("VerifyChecksum: corrupted=%v err=%v", corrupted, err)
+	}
+
+	// Partitioned tables stay readable without the option.
+	h.o.IndexPartitionSize = 0
+	h.reopenDB()
+	check()
+}
+
+func TestDB_BlockCachePriority(t *testing.T) {
+	for _, x := range []struct {
+		name    string
+		pinning opt.PinPolicy
+		ratio   float64
+		evicted bool
+	}{
+		{"none", opt.PinNone, 0, true},
+		{"pinL0", opt.PinL0, 0, false},
+		{"pinAll", opt.PinAll, 0, false},
+		{"highPriority", opt.PinNone, 0.2, false},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			h := newDbHarnessWopt(t, &opt.Options{
+				DisableLargeBatchTransaction: true,
+				BlockCacheCapacity:           64 * opt.KiB,
+				BlockCachePinning:            x.pinning,
+				BlockCacheHighPriorityRatio:  x.ratio,
+				Compression:                  opt.NoCompression,
+				Filter:                       filter.NewBloomFilter(10),
+			})
+			defer h.close()
+
+			for i := 0; i < 2000; i++ {
+				h.put(fmt.Sprintf("key%06d", i), strings.Repeat("v", 200))
+			}
+			h.compactMem()
+			h.tablesPerLevel("1")
+
+			stats := &h.db.s.tops.readStats
+			h.getVal("key000000", strings.Repeat("v", 200))
+			indexMisses, filterMisses := stats.CacheMisses(table.IndexBlock), stats.CacheMisses(table.FilterBlock)
+
+			// A scan goes through the whole block cache.
+			iter := h.db.NewIterator(nil, nil)
+			for iter.Next() {
+			}
+			iter.Release()
+			for i := 0; i < 2000; i += 100 {
+				h.getVal(fmt.Sprintf("key%06d", i), strings.Repeat("v", 200))
+			}
+			evicted := stats.CacheMisses(table.IndexBlock) > indexMisses || stats.CacheMisses(table.FilterBlock) > filterMisses
+			if evicted != x.evicted {
+				t.Errorf("index or filter block evicted=%v, want %v", evicted, x.evicted)
+			}
+		})
+	}
+}
+
+func TestDB_SharedBlockCache(t *testing.T) {
+	shared := cache.NewCache(cache.NewLRU(opt.MiB))
+	defer shared.Close(false)
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockCache:                   shared,
+		Compression:                  opt.NoCompression,
+	}
+	h1, h2 := newDbHarnessWopt(t, o), newDbHarnessWopt(t, o)
+	defer h2.close()
+
+	// Both DBs have tables with the same file numbers, their blocks must
+	// not collide in the shared cache.
+	for i, h := range []*dbHarness{h1, h2} {
+		for j := 0; j < 100; j++ {
+			h.put(fmt.Sprintf("key%06d", j), fmt.Sprintf("db%d", i+1))
+		}
+		h.compactMem()
+		h.tablesPerLevel("1")
+	}
+	for i, h := range []*dbHarness{h1, h2} {
+		for j := 0; j < 100; j += 10 {
+			h.getVal(fmt.Sprintf("key%06d", j), fmt.Sprintf("db%d", i+1))
+		}
+	}
+
+	var s1, s2 DBStats
+	if err := h1.db.Stats(&s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h2.db.Stats(&s2); err != nil {
+		t.Fatal(err)
+	}
+	if s1.BlockCacheSize == 0 || s2.BlockCacheSize == 0 {
+		t.Fatalf("block cache size: got %d and %d, want non-zero", s1.BlockCacheSize, s2.BlockCacheSize)
+	}
+	if got, want := s1.BlockCacheSize+s2.BlockCacheSize, shared.Size(); got != want {
+		t.Errorf("sum of block cache sizes: got %d, want %d", got, want)
+	}
+	if err := h1.db.SetOptions(map[string]string{"BlockCacheCapacity": "1024"}); err == nil {
+		t.Error("SetOptions: BlockCacheCapacity of a shared block cache changed")
+	}
+
+	// Closing a DB evicts its own blocks, and leaves the shared cache open.
+	h1.close()
+	if got, want := shared.Size(), s2.BlockCacheSize; got != want {
+		t.Errorf("shared cache size after close: got %d, want %d", got, want)
+	}
+	h2.getVal("key000050", "db2")
+}
+
+func TestDB_WriteBufferManager(t *testing.T) {
+	wbm := opt.NewWriteBufferManager(64 * opt.KiB)
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBufferManager:           wbm,
+	}
+	h1, h2 := newDbHarnessWopt(t, o), newDbHarnessWopt(t, o)
+	defer h1.close()
+	defer h2.close()
+
+	value := strings.Repeat("v", 1000)
+	for i := 0; i < 40; i++ {
+		h1.put(fmt.Sprintf("key%06d", i), value)
+	}
+	for i := 0; i < 20; i++ {
+		h2.put(fmt.Sprintf("key%06d", i), value)
+	}
+	if h1.totalTables() != 0 || h2.totalTables() != 0 {
+		t.Fatalf("flushed below the write buffer capacity, usage %d", wbm.Usage())
+	}
+
+	// Exceeding the capacity flushes the largest memdb, writes stall until
+	// the usage is back under the capacity.
+	for i := 40; i < 50; i++ {
+		h1.put(fmt.Sprintf("key%06d", i), value)
+		if usage := wbm.Usage(); usage > wbm.Capacity()+4*opt.KiB {
+			t.Fatalf("write didn't stall, usage %d", usage)
+		}
+	}
+	for start := time.Now(); h1.totalTables() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("largest memdb not flushed, usage %d", wbm.Usage())
+		}
+	}
+	h1.waitMemCompaction()
+	if n := h2.totalTables(); n != 0 {
+		t.Errorf("smaller memdb flushed, got %d tables", n)
+	}
+	if usage := wbm.Usage(); usage > wbm.Capacity() {
+		t.Errorf("usage after flush: got %d, want at most %d", usage, wbm.Capacity())
+	}
+	h1.getVal("key000045", value)
+
+	// Closed DBs are no longer accounted.
+	if err := h2.db.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+	if usage := wbm.Usage(); usage > 16*opt.KiB {
+		t.Errorf("usage after close: got %d", usage)
+	}
+}
+
+func TestDB_WriteBufferManagerPaused(t *testing.T) {
+	wbm := opt.NewWriteBufferManager(64 * opt.KiB)
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBufferManager:           wbm,
+	}
+	h1, h2 := newDbHarnessWopt(t, o), newDbHarnessWopt(t, o)
+	defer h1.close()
+	defer h2.close()
+
+	// The memdb of a DB whose background work is paused can't be flushed,
+	// it must not stall the writes to the other DB.
+	if err := h1.db.PauseBackgroundWork(false); err != nil {
+		t.Fatal("PauseBackgroundWork: ", err)
+	}
+	value := strings.Repeat("v", 1000)
+	for i := 0; i < 80; i++ {
+		h1.put(fmt.Sprintf("key%06d", i), value)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 80; i++ {
+			if err := h2.db.Put([]byte(fmt.Sprintf("key%06d", i)), []byte(value), nil); err != nil {
+				t.Error("Put: ", err)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("writes stalled by the paused DB, usage %d", wbm.Usage())
+	}
+	if n := h1.totalTables(); n != 0 {
+		t.Errorf("paused DB flushed, got %d tables", n)
+	}
+	if err := h1.db.ContinueBackgroundWork(); err != nil {
+		t.Fatal("ContinueBackgroundWork: ", err)
+	}
+}
+
+func TestDB_RowCache(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		RowCacheCapacity:             opt.MiB,
+	})
+	defer h.close()
+	rowStats := func() (hits, misses uint64) {
+		var s DBStats
+		if err := h.db.Stats(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Tickers.RowCacheHits, s.Tickers.RowCacheMisses
+	}
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("key%06d", i), "v1")
+	}
+	h.compactMem()
+
+	h.getVal("key000010", "v1")
+	if hits, misses := rowStats(); hits != 0 || misses != 1 {
+		t.Fatalf("first lookup: got %d hits and %d misses, want 0 and 1", hits, misses)
+	}
+	_, v := h.get("key000010", true)
+	v[0] = 'x'
+	h.getVal("key000010", "v1")
+	if ret, err := h.db.Has([]byte("key000010"), nil); err != nil || !ret {
+		t.Fatalf("Has: got %v, %v", ret, err)
+	}
+	if hits, misses := rowStats(); hits != 3 || misses != 1 {
+		t.Fatalf("cached lookups: got %d hits and %d misses, want 3 and 1", hits, misses)
+	}
+
+	// A newer entry of the same table isn't visible to an older snapshot.
+	snap := h.getSnapshot()
+	h.put("key000010", "v2")
+	h.delete("key000020")
+	h.compactMem()
+	h.compactRange("", "")
+	h.getVal("key000010", "v2")
+	h.getValr(snap, "key000010", "v1")
+	h.get("key000020", false)
+	h.getValr(snap, "key000020", "v1")
+	snap.Release()
+
+	// The rows of compacted away tables are evicted.
+	if live := h.totalTables(); live != 1 {
+		t.Fatalf("got %d tables, want 1", live)
+	}
+	for start := time.Now(); h.db.s.tops.rowCache.Nodes() > 2; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("row cache nodes: got %d, want at most 2", h.db.s.tops.rowCache.Nodes())
+		}
+	}
+
+	if err := h.db.ResetStats(); err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := rowStats(); hits != 0 || misses != 0 {
+		t.Errorf("after reset: got %d hits and %d misses", hits, misses)
+	}
+}
+
+func TestDB_SharedRowCache(t *testing.T) {
+	shared := cache.NewCache(cache.NewLRU(opt.MiB))
+	defer shared.Close(false)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockCache:                   shared,
+		RowCacheCapacity:             opt.MiB,
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("key%06d", i), "v1")
+	}
+	h.compactMem()
+
+	// The rows are held in the DB's own scope of the shared cache, and
+	// DB.Has doesn't fill it.
+	tops := h.db.s.tops
+	if tops.rowCache != shared || tops.rowScope == nil {
+		t.Fatal("row cache isn't a scope of the shared block cache")
+	}
+	if ret, err := h.db.Has([]byte("key000010"), nil); err != nil || !ret {
+		t.Fatalf("Has: got %v, %v", ret, err)
+	}
+	if n := tops.rowScope.Size(); n != 0 {
+		t.Fatalf("row cache size after Has: got %d, want 0", n)
+	}
+	h.getVal("key000010", "v1")
+	if n := tops.rowScope.Size(); n == 0 {
+		t.Fatal("row cache size after Get: got 0, want non-zero")
+	}
+	if got, want := tops.rowScope.Size()+tops.blockCacheSize(), shared.Size(); got > want {
+		t.Errorf("row and block cache size: got %d, want at most %d", got, want)
+	}
+}
+
+func TestDB_EncryptedStorage(t *testing.T) {
+	dbpath := filepath.Join(os.TempDir(), fmt.Sprintf("goleveldbtestEncryptedStorage-%d", os.Getuid()))
+	if err := os.RemoveAll(dbpath); err != nil {
+		t.Fatal("cannot remove old db: ", err)
+	}
+	defer os.RemoveAll(dbpath)
+
+	kp := &storage.StaticKeyProvider{
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 16),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	value := strings.Repeat("secret-value-", 10)
+	run := func(i int, encrypted bool, f func(db *DB)) {
+		stor, err := storage.OpenFile(dbpath, false)
+		if err != nil {
+			t.Fatalf("(%d) cannot open storage: %s", i, err)
+		}
+		defer stor.Close()
+		if encrypted {
+			if stor, err = storage.NewEncryptedStorage(stor, kp); err != nil {
+				t.Fatalf("(%d) cannot open encrypted storage: %s", i, err)
+			}
+		}
+		db, err := Open(stor, nil)
+		if err != nil {
+			t.Fatalf("(%d) cannot open db: %s", i, err)
+		}
+		defer db.Close()
+		for j := 0; j < i*100; j++ {
+			key := fmt.Sprintf("k%d", j)
+			if got, err := db.Get([]byte(key), nil); err != nil || string(got) != value {
+				t.Fatalf("(%d) Get %q: got %q, err=%v", i, key, got, err)
+			}
+		}
+		for j := i * 100; j < (i+1)*100; j++ {
+			if err := db.Put([]byte(fmt.Sprintf("k%d", j)), []byte(value), nil); err != nil {
+				t.Fatalf("(%d) cannot write to db: %s", i, err)
+			}
+		}
+		if f != nil {
+			f(db)
+		}
+	}
+	reencrypt := func(current string) {
+		kp.Current = current
+		stor, err := storage.OpenFile(dbpath, false)
+		if err != nil {
+			t.Fatal("cannot open storage: ", err)
+		}
+		defer stor.Close()
+		if err := storage.Reencrypt(stor, kp); err != nil {
+			t.Fatal("Reencrypt: ", err)
+		}
+	}
+
+	// Start with a plaintext DB, which is encrypted afterwards.
+	run(0, false, func(db *DB) {
+		if err := db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: ", err)
+		}
+	})
+	reencrypt("k1")
+	run(1, true, func(db *DB) {
+		if err := db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: ", err)
+		}
+	})
+
+	// No file has the plaintext values.
+	files, err := os.ReadDir(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if b, err := os.ReadFile(filepath.Join(dbpath, f.Name())); err == nil && bytes.Contains(b, []byte("secret-value")) {
+			t.Errorf("file %s contains plaintext", f.Name())
+		}
+	}
+
+	// Rotate the key, after which the old key is no longer needed.
+	reencrypt("k2")
+	delete(kp.Keys, "k1")
+	run(2, true, nil)
+	run(3, true, nil)
+}
+
+// encrypterStorage is a storage wrapper forwarding storage.Encrypter.
+type encrypterStorage struct {
+	storage.Storage
+}
+
+func (s encrypterStorage) Encrypted() bool { return storage.IsEncrypted(s.Storage) }
+
+func TestDB_EncryptedStorageLog(t *testing.T) {
+	kp := &storage.StaticKeyProvider{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)}}
+	for _, encrypted := range []bool{false, true} {
+		stor := storage.Storage(storage.NewMemStorage())
+		if encrypted {
+			var err error
+			if stor, err = storage.NewEncryptedStorage(stor, kp); err != nil {
+				t.Fatal("cannot open encrypted storage: ", err)
+			}
+		}
+		stor = encrypterStorage{stor}
+		l := &testLogger{}
+		db, err := Open(stor, &opt.Options{Logger: l})
+		if err != nil {
+			t.Fatal("cannot open db: ", err)
+		}
+		if err := db.Put([]byte("secret"), []byte("v"), nil); err != nil {
+			t.Fatal("Put: ", err)
+		}
+		if err := db.CompactRange(util.Range{Start: []byte("secret")}); err != nil {
+			t.Fatal("CompactRange: ", err)
+		}
+		ikey := makeInternalKey(nil, []byte("secret"), 1, keyTypeVal)
+		db.s.log(opt.LogError, "test@error", lfErr(newErrInternalKeyCorrupted(ikey, "bad")))
+		db.s.log(opt.LogError, "test@error", lfErr(&ErrDataLoss{Min: []byte("secret"), Max: []byte("secret")}))
+		db.Close()
+
+		records := append(l.find("memdb@flush created"), l.find("table@compaction range")...)
+		if len(records) < 2 {
+			t.Fatalf("encrypted=%v: missing records, got %d", encrypted, len(records))
+		}
+		for _, r := range records {
+			leaked := strings.Contains(fmt.Sprint(r.fields["min"]), "secret")
+			if leaked == encrypted {
+				t.Errorf("encrypted=%v: %s record has min=%v", encrypted, r.msg, r.fields["min"])
+			}
+		}
+		errRecords := l.find("test@error")
+		if len(errRecords) != 2 {
+			t.Fatalf("encrypted=%v: missing error records, got %d", encrypted, len(errRecords))
+		}
+		for _, r := range errRecords {
+			err, ok := r.fields["error"].(error)
+			if !ok {
+				t.Fatalf("encrypted=%v: error field is %T, want error", encrypted, r.fields["error"])
+			}
+			if leaked := strings.Contains(err.Error(), "secret"); leaked == encrypted {
+				t.Errorf("encrypted=%v: error record has error=%v", encrypted, err)
+			}
+		}
+	}
+}
+
+func TestDB_TableFormatVersion(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockChecksum:                opt.XXH3Checksum,
+	})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprintf("v%03d", i))
+	}
+	h.compactMem()
+
+	// Tables of both formats are read regardless of the options.
+	h.o.BlockChecksum = opt.DefaultChecksum
+	h.reopenDB()
+	for i := 0; i < 100; i += 2 {
+		h.put(fmt.Sprintf("k%03d", i), fmt.Sprintf("v%03d.2", i))
+	}
+	h.compactMem()
+	for i := 0; i < 100; i++ {
+		want := fmt.Sprintf("v%03d", i)
+		if i%2 == 0 {
+			want += ".2"
+		}
+		h.getVal(fmt.Sprintf("k%03d", i), want)
+	}
+	if corrupted, err := h.db.VerifyChecksum(context.Background()); err != nil || len(corrupted) != 0 {
+		t.Fatalf("VerifyChecksum: got %v, err=%v", corrupted, err)
+	}
+	h.compactRangeAt(0, "", "")
+	h.getVal("k042", "v042.2")
+	h.getVal("k043", "v043")
+}
+
+func TestDB_ScrubberProgress(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+	})
+	defer h.close()
+
+	for i := 0; i < 3; i++ {
+		h.put(fmt.Sprintf("k%d", i), "v")
+		h.compactMem()
+	}
+	h.closeDB()
+	tables, _ := h.stor.List(storage.TypeTable)
+	sortFds(tables)
+	if len(tables) != 3 {
+		t.Fatalf("got %d tables, want 3", len(tables))
+	}
+
+	// With a small bandwidth the scrubber takes about a second per table,
+	// so it is closed while verifying the second one.
+	scrubOne := func() {
+		t.Helper()
+		h.o.ScrubBandwidth = 256
+		h.openDB()
+		var s DBStats
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if err := h.db.Stats(&s); err != nil {
+				t.Fatal("Stats: got error: ", err)
+			}
+			if s.Tickers.ScrubbedTables > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("scrubber didn't verify any table")
+			}
+		}
+		h.closeDB()
+	}
+	for i := 0; i < 2; i++ {
+		scrubOne()
+		// The progress is recorded in the manifest.
+		h.o.ScrubBandwidth = 0
+		h.openDB()
+		if ptr := h.db.s.stScrubPtr; ptr != tables[i].Num {
+			t.Fatalf("scrub pointer: got %d, want %d", ptr, tables[i].Num)
+		}
+		h.closeDB()
+	}
+}
@@ -28,6 +28,7 @@ type Transaction struct {
 	ikScratch []byte
 	rec       sessionRecord
 	stats     cStatStaging
+	keyCnt    int
 	closed    bool
 }
 
@@ -94,7 +95,7 @@ func (tr *Transaction) flush() error {
 	if tr.mem.Len() != 0 {
 		tr.stats.startTimer()
 		iter := tr.mem.NewIterator(nil)
-		t, n, err := tr.db.s.tops.createFrom(iter)
+		t, n, err := tr.db.s.tops.createFrom(iter, tr.db.s.o.levelCompression(0, false))
 		iter.Release()
 		tr.stats.stopTimer()
 		if err != nil {
@@ -110,7 +111,8 @@ func (tr *Transaction) flush() error {
 		tr.tables = append(tr.tables, t)
 		tr.rec.addTableFile(0, t)
 		tr.stats.write += t.size
-		tr.db.logf("transaction@flush created L0@%d N·%d S·%s %q:%q", t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
+		tr.keyCnt += n
+		tr.db.log(opt.LogDebug, "transaction@flush created", lfLevel(0), lfTable(t.fd.Num), lfEntries(n), lfSize(t.size), lfMin(t.imin), lfMax(t.imax))
 	}
 	return nil
 }
@@ -214,11 +216,11 @@ func (tr *Transaction) Commit() error {
 		for retry := 0; retry < 3; retry++ {
 			cerr = tr.db.s.commit(&tr.rec, false)
 			if cerr != nil {
-				tr.db.logf("transaction@commit error R·%d %q", retry, cerr)
+				tr.db.log(opt.LogError, "transaction@commit error", lf("retry", retry), lfErr(cerr))
 				select {
 				case <-time.After(time.Second):
 				case <-tr.db.closeC:
-					tr.db.logf("transaction@commit exiting")
+					tr.db.log(opt.LogInfo, "transaction@commit exiting")
 					tr.db.compCommitLk.Unlock()
 					return cerr
 				}
@@ -237,6 +239,7 @@ func (tr *Transaction) Commit() error {
 
 		// Update compaction stats. This is safe as long as we hold compCommitLk.
 		tr.db.compStats.addStat(0, &tr.stats)
+		tr.db.tickers.addLevelKeys(0, tr.keyCnt)
 
 		// Trigger table auto-compaction.
 		tr.db.compTrigger(tr.db.tcompCmdC)
@@ -254,7 +257,7 @@ func (tr *Transaction) Commit() error {
 func (tr *Transaction) discard() {
 	// Discard transaction.
 	for _, t := range tr.tables {
-		tr.db.logf("transaction@discard @%d", t.fd.Num)
+		tr.db.log(opt.LogInfo, "transaction@discard", lfTable(t.fd.Num))
 		// Iterator may still use the table, so we use tOps.remove here.
 		tr.db.s.tops.remove(t.fd)
 	}
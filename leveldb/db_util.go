@@ -34,8 +34,9 @@ func (sizes Sizes) Sum() int64 {
 }
 
 // Logging.
-func (db *DB) log(v ...interface{})                 { db.s.log(v...) }
-func (db *DB) logf(format string, v ...interface{}) { db.s.logf(format, v...) }
+func (db *DB) log(level opt.LogLevel, msg string, fields ...opt.LogField) {
+	db.s.log(level, msg, fields...)
+}
 
 // Check and clean files.
 func (db *DB) checkAndCleanFiles() error {
@@ -67,6 +68,8 @@ func (db *DB) checkAndCleanFiles() error {
 			} else {
 				keep = fd.Num >= db.journalFd.Num
 			}
+		case storage.TypeOptions:
+			keep = fd.Num == db.s.optionsFd.Num
 		case storage.TypeTable:
 			_, keep = tmap[fd.Num]
 			if keep {
@@ -85,16 +88,16 @@ func (db *DB) checkAndCleanFiles() error {
 		for num, present := range tmap {
 			if !present {
 				mfds = append(mfds, storage.FileDesc{Type: storage.TypeTable, Num: num})
-				db.logf("db@janitor table missing @%d", num)
+				db.log(opt.LogError, "db@janitor table missing", lfTable(num))
 			}
 		}
 		return errors.NewErrCorrupted(storage.FileDesc{}, &errors.ErrMissingFiles{Fds: mfds})
 	}
 
-	db.logf("db@janitor F·%d G·%d", len(fds), len(rem))
+	db.log(opt.LogInfo, "db@janitor", lfFiles(len(fds)), lf("garbage", len(rem)))
 	for _, fd := range rem {
-		db.logf("db@janitor removing %s-%d", fd.Type, fd.Num)
-		if err := db.s.stor.Remove(fd); err != nil {
+		db.log(opt.LogInfo, "db@janitor removing", lfFile(fd))
+		if _, err := db.s.removeFile(fd); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,169 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/journal"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/table"
+)
+
+// CorruptedFile is a corrupted file found by DB.VerifyChecksum.
+type CorruptedFile struct {
+	Fd storage.FileDesc
+
+	// Level is the level of the table, or -1 for journals.
+	Level int
+
+	// Err is the error found while verifying the file.
+	Err error
+}
+
+func (f CorruptedFile) String() string {
+	if f.Fd.Type == storage.TypeTable {
+		return fmt.Sprintf("%s (level %d): %v", f.Fd, f.Level, f.Err)
+	}
+	return fmt.Sprintf("%s: %v", f.Fd, f.Err)
+}
+
+// Verifies the block checksums of the given table, and its whole-file
+// checksum if recorded in the manifest. The onRead function, if not nil,
+// is called with the size of each read, see table.Reader.VerifyChecksum.
+func (t *tOps) verify(f *tFile, onRead func(n int) error) error {
+	ch, err := t.open(f)
+	if err != nil {
+		return err
+	}
+	defer ch.Release()
+	tr := ch.Value().(*table.Reader)
+	if err := tr.VerifyChecksum(onRead); err != nil {
+		return err
+	}
+	if f.hasChecksum {
+		checksum, err := tr.FileChecksum(onRead)
+		if err != nil {
+			return err
+		}
+		if checksum != f.checksum {
+			return errors.NewErrCorrupted(f.fd, fmt.Errorf("leveldb: file checksum mismatch, want=%#x got=%#x", f.checksum, checksum))
+		}
+	}
+	return nil
+}
+
+// Verifies the record checksums of the given journal.
+func (db *DB) verifyJournal(fd storage.FileDesc) error {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	jr := journal.NewReader(r, dropper{db.s, fd}, true, true)
+	for {
+		rr, err := jr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, rr)
+		}
+		if err != nil {
+			if cerr, ok := err.(*errors.ErrCorrupted); ok && cerr.Fd.Zero() {
+				cerr.Fd = fd
+			}
+			return err
+		}
+	}
+}
+
+// Returns the live journals, excluding the journal being written, which
+// may end with a partially written record.
+func (db *DB) verifiableJournals() ([]storage.FileDesc, error) {
+	fds, err := db.s.stor.List(storage.TypeJournal)
+	if err != nil {
+		return nil, err
+	}
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	minFd := db.journalFd
+	if !db.frozenJournalFd.Zero() {
+		minFd = db.frozenJournalFd
+	}
+	var journals []storage.FileDesc
+	for _, fd := range fds {
+		if fd.Num >= minFd.Num && fd != db.journalFd {
+			journals = append(journals, fd)
+		}
+	}
+	sortFds(journals)
+	return journals, nil
+}
+
+// VerifyChecksum rereads every live table and journal of the DB, and
+// verifies the checksum of every block and journal record, and the
+// whole-file checksum of tables written since checksums are recorded in
+// the manifest. The journal being written isn't verified.
+//
+// It returns the corrupted files found, the returned error is non-nil only
+// if the verification couldn't be completed, e.g. because the context is
+// done or the DB is closed.
+func (db *DB) VerifyChecksum(ctx context.Context) ([]CorruptedFile, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	var corrupted []CorruptedFile
+	report := func(fd storage.FileDesc, level int, err error) {
+		db.log(opt.LogError, "db@verify corrupted", lfFile(fd), lfLevel(level), lfErr(err))
+		corrupted = append(corrupted, CorruptedFile{Fd: fd, Level: level, Err: err})
+	}
+
+	v := db.s.version()
+	defer v.release()
+	var nTables int
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			if err := ctx.Err(); err != nil {
+				return corrupted, err
+			}
+			if err := db.ok(); err != nil {
+				return corrupted, err
+			}
+			if err := db.s.tops.verify(t, nil); err != nil {
+				report(t.fd, level, err)
+			}
+			nTables++
+		}
+	}
+
+	journals, err := db.verifiableJournals()
+	if err != nil {
+		return corrupted, err
+	}
+	for _, fd := range journals {
+		if err := ctx.Err(); err != nil {
+			return corrupted, err
+		}
+		if err := db.verifyJournal(fd); err != nil {
+			// The frozen journal may have been removed after being flushed.
+			if os.IsNotExist(err) {
+				continue
+			}
+			report(fd, -1, err)
+		}
+	}
+
+	db.log(opt.LogInfo, "db@verify done", lf("tables", nTables), lf("journals", len(journals)), lf("corrupted", len(corrupted)))
+	return corrupted, nil
+}
@@ -16,6 +16,7 @@ import (
 )
 
 func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
+	start := db.lat.now()
 	wr, err := db.journal.Next()
 	if err != nil {
 		return err
@@ -26,7 +27,9 @@ func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
 	if err := db.journal.Flush(); err != nil {
 		return err
 	}
+	db.lat.since(latWriteJournal, start)
 	if sync {
+		defer db.lat.since(latWriteSync, db.lat.now())
 		return db.journalWriter.Sync()
 	}
 	return nil
@@ -63,6 +66,16 @@ retry:
 	return
 }
 
+// Sets write throttling state and notifies the event listener if it changes;
+// need write lock.
+func (db *DB) setWriteStall(cond opt.WriteStallCondition) {
+	if db.writeStall != cond {
+		info := opt.WriteStallInfo{Prev: db.writeStall, Cur: cond}
+		db.writeStall = cond
+		db.s.o.GetEventListener().OnWriteStall(info)
+	}
+}
+
 func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 	delayed := false
 	slowdownTrigger := db.s.o.GetWriteL0SlowdownTrigger()
@@ -84,11 +97,13 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 		switch {
 		case tLen >= slowdownTrigger && !delayed:
 			delayed = true
+			db.setWriteStall(opt.WriteStallDelayed)
 			time.Sleep(time.Millisecond)
 		case mdbFree >= n:
 			return false
 		case tLen >= pauseTrigger:
 			delayed = true
+			db.setWriteStall(opt.WriteStallStopped)
 			// Set the write paused flag explicitly.
 			atomic.StoreInt32(&db.inWritePaused, 1)
 			err = db.compTriggerWait(db.tcompCmdC)
@@ -121,7 +136,8 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 		db.writeDelay += time.Since(start)
 		db.writeDelayN++
 	} else if db.writeDelayN > 0 {
-		db.logf("db@write was delayed N·%d T·%v", db.writeDelayN, db.writeDelay)
+		db.setWriteStall(opt.WriteStallNormal)
+		db.log(opt.LogInfo, "db@write was delayed", lf("count", db.writeDelayN), lfDuration(db.writeDelay))
 		atomic.AddInt32(&db.cWriteDelayN, int32(db.writeDelayN))
 		atomic.AddInt64(&db.cWriteDelay, int64(db.writeDelay))
 		db.writeDelay = 0
@@ -234,12 +250,14 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	// Put batches.
+	start := db.lat.now()
 	for _, batch := range batches {
 		if err := batch.putMem(seq, mdb.DB); err != nil {
 			panic(err)
 		}
 		seq += uint64(batch.Len())
 	}
+	db.lat.since(latWriteMemdb, start)
 
 	// Incr seq number.
 	db.addSeq(uint64(batchesLen(batches)))
@@ -252,10 +270,111 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 		}
 	}
 
+	// Merged batches are charged to the write buffer check too.
+	var written int
+	if db.wbm != nil {
+		for _, b := range batches {
+			written += b.internalLen
+		}
+	}
+
 	db.unlockWrite(overflow, merged, nil)
+
+	if db.wbm != nil {
+		db.writeBufferCheck(written)
+	}
 	return nil
 }
 
+// The shared write buffer usage is checked every 1/writeBufferCheckRatio of
+// its capacity written to the DB.
+const writeBufferCheckRatio = 64
+
+// Flushes the largest memdb if the shared write buffer is exceeded, and
+// stalls the write until flushes bring it back under the capacity. Must be
+// called without holding the write lock, which the flush needs.
+func (db *DB) writeBufferCheck(n int) {
+	step := int64(db.wbm.Capacity() / writeBufferCheckRatio)
+	if atomic.AddInt64(&db.wbmUnchecked, int64(n)) < step {
+		return
+	}
+	atomic.StoreInt64(&db.wbmUnchecked, 0)
+	for db.wbm.Check() {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-db.compPerErrC:
+			return
+		case <-db.closeC:
+			return
+		}
+	}
+}
+
+// Returns the memory held by the memdbs, accounted by the write buffer
+// manager.
+func (db *DB) writeBufferUsage() int {
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	var n int
+	if db.mem != nil {
+		n += db.mem.Size()
+	}
+	if db.frozenMem != nil {
+		n += db.frozenMem.Size()
+	}
+	return n
+}
+
+// Called by the write buffer manager; rotates the effective memdb in the
+// background, unless a memdb compaction is already pending. Returns false if
+// the memdb can't be flushed, because background work is paused or the DB
+// hit a persistent error.
+func (db *DB) flushWriteBuffer() bool {
+	select {
+	case <-db.compPerErrC:
+		return false
+	default:
+	}
+	db.bgMu.Lock()
+	paused := db.bgResumeC != nil
+	db.bgMu.Unlock()
+	if paused {
+		return false
+	}
+	if !atomic.CompareAndSwapUint32(&db.wbmFlushing, 0, 1) {
+		return true
+	}
+	go func() {
+		defer atomic.StoreUint32(&db.wbmFlushing, 0)
+		select {
+		case db.writeLockC <- struct{}{}:
+		case <-db.closeC:
+			return
+		}
+		defer func() { <-db.writeLockC }()
+
+		if fmem := db.getFrozenMem(); fmem != nil {
+			fmem.decref()
+			// The trigger of the rotation may have been missed by a
+			// memdb compaction that was just finishing.
+			db.compTrigger(db.mcompCmdC)
+			return
+		}
+		mem := db.getEffectiveMem()
+		n := mem.Size()
+		mem.decref()
+		if n == 0 {
+			return
+		}
+		if _, err := db.rotateMem(0, false); err != nil {
+			db.log(opt.LogWarn, "memdb@flush failed", lfErr(err))
+			return
+		}
+		db.log(opt.LogDebug, "memdb@flush requested", lfSize(int64(n)))
+	}()
+	return true
+}
+
 // Write apply the given batch to the DB. The batch records will be applied
 // sequentially. Write might be used concurrently, when used concurrently and
 // batch is small enough, write will try to merge the batches. Set NoWriteMerge
@@ -267,6 +386,8 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	start := db.lat.now()
+	defer db.lat.since(latWrite, start)
 
 	// If the batch size is larger than write buffer, it may justified to write
 	// using transaction instead. Using transaction the batch will be written
@@ -317,6 +438,7 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 		}
 	}
 
+	db.lat.since(latWriteLock, start)
 	return db.writeLocked(batch, nil, merge, sync)
 }
 
@@ -324,6 +446,8 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 	if err := db.ok(); err != nil {
 		return err
 	}
+	start := db.lat.now()
+	defer db.lat.since(latWrite, start)
 
 	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
@@ -359,6 +483,7 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 		}
 	}
 
+	db.lat.since(latWriteLock, start)
 	batch := db.batchPool.Get().(*Batch)
 	batch.Reset()
 	batch.appendRec(kt, key, value)
@@ -7,7 +7,10 @@
 package leveldb
 
 import (
+	"fmt"
+
 	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/storage"
 )
 
 // Common errors.
@@ -17,4 +20,31 @@ var (
 	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
 	ErrIterReleased     = errors.New("leveldb: iterator released")
 	ErrClosed           = errors.New("leveldb: closed")
+
+	// ErrIncompatibleOptions is returned by Open if the given options
+	// differ dangerously from the options the DB was last opened with,
+	// and opt.Options.ErrorIfOptionsMismatch is set.
+	ErrIncompatibleOptions = errors.New("leveldb: incompatible options")
 )
+
+// ErrDataLoss is returned by reads that touch the key range of a table
+// quarantined because it was corrupted, see
+// opt.Options.QuarantineCorruptedTables.
+type ErrDataLoss struct {
+	// Fd is the quarantined table file.
+	Fd storage.FileDesc
+
+	// Min and Max are the smallest and largest user keys of the table.
+	Min, Max []byte
+}
+
+func (e *ErrDataLoss) Error() string {
+	return fmt.Sprintf("leveldb: data lost in key range [%q, %q] of quarantined table %v", e.Min, e.Max, e.Fd)
+}
+
+func (e *ErrDataLoss) format(redact bool) string {
+	if !redact {
+		return e.Error()
+	}
+	return fmt.Sprintf("leveldb: data lost in key range [<redacted>] of quarantined table %v", e.Fd)
+}
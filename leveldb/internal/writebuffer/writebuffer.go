@@ -0,0 +1,107 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package writebuffer implements the write buffer manager shared by DB
+// instances, see opt.WriteBufferManager. It is internal so its hooks for
+// the DB aren't part of the public API.
+package writebuffer
+
+import (
+	"sort"
+	"sync"
+)
+
+type member struct {
+	usage func() int
+	flush func() bool
+}
+
+// Manager caps the total 'memdb' memory of the DB instances registered
+// with it. opt.WriteBufferManager is defined as a Manager.
+type Manager struct {
+	capacity int
+
+	mu      sync.Mutex
+	members map[*member]struct{}
+}
+
+// New creates a new manager with the given capacity in bytes.
+func New(capacity int) *Manager {
+	return &Manager{
+		capacity: capacity,
+		members:  make(map[*member]struct{}),
+	}
+}
+
+// Capacity returns the capacity of the manager.
+func (m *Manager) Capacity() int {
+	return m.capacity
+}
+
+// Usage returns the total 'memdb' memory of the registered DB instances.
+func (m *Manager) Usage() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var usage int
+	for x := range m.members {
+		usage += x.usage()
+	}
+	return usage
+}
+
+// Register registers a DB instance, given a function returning its 'memdb'
+// memory and a function requesting it to flush its 'memdb'. The flush
+// function must not block on the flush, and returns false if the DB can't
+// flush, e.g. because its background work is paused. It returns a function
+// that unregisters the DB instance.
+func (m *Manager) Register(usage func() int, flush func() bool) (unregister func()) {
+	x := &member{usage: usage, flush: flush}
+	m.mu.Lock()
+	m.members[x] = struct{}{}
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		delete(m.members, x)
+		m.mu.Unlock()
+	}
+}
+
+// Check requests the DB instance with the largest 'memdb' memory that can
+// flush to flush it, if the total usage exceeds the capacity. Returns true
+// if a flush was requested, in which case the caller should stall its
+// writes and check again later. DB instances that can't flush are skipped,
+// so they don't stall the others.
+func (m *Manager) Check() bool {
+	type candidate struct {
+		usage int
+		flush func() bool
+	}
+	var (
+		usage      int
+		candidates []candidate
+	)
+	m.mu.Lock()
+	for x := range m.members {
+		n := x.usage()
+		usage += n
+		if n > 0 {
+			candidates = append(candidates, candidate{n, x.flush})
+		}
+	}
+	m.mu.Unlock()
+	if usage <= m.capacity {
+		return false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].usage > candidates[j].usage
+	})
+	for _, c := range candidates {
+		if c.flush() {
+			return true
+		}
+	}
+	return false
+}
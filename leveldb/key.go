@@ -24,6 +24,13 @@ func (e *ErrInternalKeyCorrupted) Error() string {
 	return fmt.Sprintf("leveldb: internal key %q corrupted: %s", e.Ikey, e.Reason)
 }
 
+func (e *ErrInternalKeyCorrupted) format(redact bool) string {
+	if !redact {
+		return e.Error()
+	}
+	return fmt.Sprintf("leveldb: internal key %s corrupted: %s", logKey(e.Ikey).format(true), e.Reason)
+}
+
 func newErrInternalKeyCorrupted(ikey []byte, reason string) error {
 	return errors.NewErrCorrupted(storage.FileDesc{}, &ErrInternalKeyCorrupted{append([]byte(nil), ikey...), reason})
 }
@@ -0,0 +1,233 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type latencyKind int
+
+const (
+	latGet latencyKind = iota
+	latHas
+	latWrite
+	latWriteLock
+	latWriteJournal
+	latWriteSync
+	latWriteMemdb
+	latIterSeek
+	latIterNext
+	latMemdbFlush
+	latTableCompaction
+	numLatencyKinds
+)
+
+var latencyNames = [numLatencyKinds]string{
+	latGet:             "get",
+	latHas:             "has",
+	latWrite:           "write",
+	latWriteLock:       "write.lock",
+	latWriteJournal:    "write.journal",
+	latWriteSync:       "write.sync",
+	latWriteMemdb:      "write.memdb",
+	latIterSeek:        "iter.seek",
+	latIterNext:        "iter.next",
+	latMemdbFlush:      "memdb.flush",
+	latTableCompaction: "table.compaction",
+}
+
+// Histogram buckets are log-linear over nanoseconds: each power of two is
+// split into latencySubBuckets buckets, which bounds the relative error of
+// a bucket to 1/latencySubBuckets. Durations beyond latencyMaxBits are
+// accounted in the last bucket.
+const (
+	latencySubBits    = 2
+	latencySubBuckets = 1 << latencySubBits
+	latencyMaxBits    = 40 // ~18 minutes
+	numLatencyBuckets = latencySubBuckets * (latencyMaxBits - latencySubBits + 1)
+)
+
+func latencyBucket(d time.Duration) int {
+	if d < latencySubBuckets {
+		if d < 0 {
+			return 0
+		}
+		return int(d)
+	}
+	v := uint64(d)
+	e := bits.Len64(v) - 1
+	if e >= latencyMaxBits {
+		return numLatencyBuckets - 1
+	}
+	sub := int(v>>uint(e-latencySubBits)) & (latencySubBuckets - 1)
+	return latencySubBuckets*(e-latencySubBits+1) + sub
+}
+
+// latencyBucketBounds returns the inclusive lower bound and exclusive upper
+// bound of bucket i.
+func latencyBucketBounds(i int) (lower, upper time.Duration) {
+	if i < latencySubBuckets {
+		return time.Duration(i), time.Duration(i + 1)
+	}
+	e := i/latencySubBuckets + latencySubBits - 1
+	sub := i % latencySubBuckets
+	shift := uint(e - latencySubBits)
+	lower = time.Duration(latencySubBuckets+sub) << shift
+	upper = time.Duration(latencySubBuckets+sub+1) << shift
+	return
+}
+
+// latencyHistogram is a lock-free latency histogram.
+type latencyHistogram struct {
+	count   uint64
+	sum     int64
+	max     int64
+	buckets [numLatencyBuckets]uint64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	atomic.AddUint64(&h.buckets[latencyBucket(d)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	s := LatencyHistogram{
+		Sum: time.Duration(atomic.LoadInt64(&h.sum)),
+		Max: time.Duration(atomic.LoadInt64(&h.max)),
+	}
+	// Count is summed from the buckets so that the snapshot is consistent
+	// with itself even when racing with record.
+	for i := range h.buckets {
+		n := atomic.LoadUint64(&h.buckets[i])
+		if n == 0 {
+			continue
+		}
+		lower, upper := latencyBucketBounds(i)
+		s.Buckets = append(s.Buckets, LatencyBucket{Lower: lower, Upper: upper, Count: n})
+		s.Count += n
+	}
+	return s
+}
+
+// latencyHistograms holds the latency histograms of a DB. A nil
+// *latencyHistograms records nothing, which is the case unless
+// opt.Options.LatencyHistograms is set.
+type latencyHistograms [numLatencyKinds]latencyHistogram
+
+func newLatencyHistograms(enabled bool) *latencyHistograms {
+	if !enabled {
+		return nil
+	}
+	return &latencyHistograms{}
+}
+
+// now returns the current time, or zero time if recording is disabled.
+func (l *latencyHistograms) now() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (l *latencyHistograms) since(kind latencyKind, start time.Time) {
+	if l == nil {
+		return
+	}
+	l[kind].record(time.Since(start))
+}
+
+func (l *latencyHistograms) record(kind latencyKind, d time.Duration) {
+	if l == nil {
+		return
+	}
+	l[kind].record(d)
+}
+
+func (l *latencyHistograms) snapshot() map[string]LatencyHistogram {
+	if l == nil {
+		return nil
+	}
+	m := make(map[string]LatencyHistogram, numLatencyKinds)
+	for kind := range l {
+		m[latencyNames[kind]] = l[kind].snapshot()
+	}
+	return m
+}
+
+func (l *latencyHistograms) String() string {
+	var b strings.Builder
+	for kind := range l {
+		s := l[kind].snapshot()
+		fmt.Fprintf(&b, "%s %s\n", latencyNames[kind], &s)
+	}
+	return b.String()
+}
+
+// LatencyBucket is a bucket of a LatencyHistogram, counting durations within
+// [Lower, Upper).
+type LatencyBucket struct {
+	Lower, Upper time.Duration
+	Count        uint64
+}
+
+// LatencyHistogram is a snapshot of a latency histogram. Only non-empty
+// buckets are included, in ascending order.
+type LatencyHistogram struct {
+	Count   uint64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets []LatencyBucket
+}
+
+// Mean returns the mean of the recorded durations.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns an estimate of the given percentile (0-100) of the
+// recorded durations, interpolated within the matching bucket.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.Buckets[0].Lower
+	}
+	rank := p / 100 * float64(h.Count)
+	var cum float64
+	for _, b := range h.Buckets {
+		if cum+float64(b.Count) >= rank {
+			d := b.Lower + time.Duration((rank-cum)/float64(b.Count)*float64(b.Upper-b.Lower))
+			if h.Max > 0 && d > h.Max {
+				d = h.Max
+			}
+			return d
+		}
+		cum += float64(b.Count)
+	}
+	return h.Max
+}
+
+func (h *LatencyHistogram) String() string {
+	return fmt.Sprintf("count=%d mean=%v p50=%v p90=%v p99=%v p999=%v max=%v",
+		h.Count, h.Mean(), h.Percentile(50), h.Percentile(90), h.Percentile(99), h.Percentile(99.9), h.Max)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, 3, 4, 7, 8, 9, 1000, time.Microsecond * 1234, time.Millisecond, time.Second, time.Minute} {
+		i := latencyBucket(d)
+		lower, upper := latencyBucketBounds(i)
+		if d < lower || d >= upper {
+			t.Errorf("%v: bucket %d [%v, %v) doesn't contain the duration", d, i, lower, upper)
+		}
+		if upper-lower > upper/4+1 {
+			t.Errorf("%v: bucket %d [%v, %v) too wide", d, i, lower, upper)
+		}
+	}
+	if i := latencyBucket(time.Hour); i != numLatencyBuckets-1 {
+		t.Errorf("overflow duration: got bucket %d, want %d", i, numLatencyBuckets-1)
+	}
+	for i := 1; i < numLatencyBuckets; i++ {
+		_, prevUpper := latencyBucketBounds(i - 1)
+		if lower, _ := latencyBucketBounds(i); lower != prevUpper {
+			t.Fatalf("bucket %d: lower bound %v, want %v", i, lower, prevUpper)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	s := h.snapshot()
+	if s.Count != 1000 {
+		t.Fatalf("got count %d, want 1000", s.Count)
+	}
+	if s.Max != time.Millisecond {
+		t.Errorf("got max %v, want 1ms", s.Max)
+	}
+	if mean := s.Mean(); mean != 500500*time.Nanosecond {
+		t.Errorf("got mean %v, want 500.5µs", mean)
+	}
+	for _, x := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 500 * time.Microsecond}, {90, 900 * time.Microsecond}, {99, 990 * time.Microsecond}} {
+		got := s.Percentile(x.p)
+		if diff := got - x.want; diff < -x.want/8 || diff > x.want/8 {
+			t.Errorf("p%v: got %v, want about %v", x.p, got, x.want)
+		}
+	}
+}
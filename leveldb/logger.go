@@ -0,0 +1,106 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-update/goleveldb/leveldb/errors"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+)
+
+// storageLogger is the default logger, which writes to the LOG file through
+// Storage.Log.
+type storageLogger struct {
+	stor storage.Storage
+}
+
+func (l storageLogger) Log(level opt.LogLevel, msg string, fields ...opt.LogField) {
+	var b strings.Builder
+	if level >= opt.LogWarn {
+		b.WriteString(level.String())
+		b.WriteByte(' ')
+	}
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		switch v := f.Value.(type) {
+		case string:
+			fmt.Fprintf(&b, "%q", v)
+		case error:
+			fmt.Fprintf(&b, "%q", v.Error())
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	l.stor.Log(b.String())
+}
+
+// Log fields.
+
+func lf(key string, value interface{}) opt.LogField { return opt.LogField{Key: key, Value: value} }
+func lfLevel(level int) opt.LogField                { return lf("level", level) }
+func lfTable(num int64) opt.LogField                { return lf("table", num) }
+func lfFiles(n int) opt.LogField                    { return lf("files", n) }
+func lfSize(size int64) opt.LogField                { return lf("size", size) }
+func lfEntries(n int) opt.LogField                  { return lf("entries", n) }
+func lfSeq(seq uint64) opt.LogField                 { return lf("seq", seq) }
+func lfDuration(d time.Duration) opt.LogField       { return lf("duration", d) }
+func lfErr(err error) opt.LogField                  { return lf("error", err) }
+func lfFile(fd storage.FileDesc) opt.LogField       { return lf("file", fd.String()) }
+func lfMin(ik internalKey) opt.LogField             { return lf("min", logKey(ik)) }
+func lfMax(ik internalKey) opt.LogField             { return lf("max", logKey(ik)) }
+
+// userLogValue is a log field value holding a user key. It is formatted by
+// session.log, which redacts the user key of encrypted storages. Errors
+// holding user keys implement it too, see redactErr.
+type userLogValue interface {
+	format(redact bool) string
+}
+
+// logKey is an internal key log field value.
+type logKey internalKey
+
+func (k logKey) format(redact bool) string {
+	ik := internalKey(k)
+	if !redact || ik == nil {
+		return ik.String()
+	}
+	if _, seq, kt, err := parseInternalKey(ik); err == nil {
+		return fmt.Sprintf("<redacted>,%s%d", kt, seq)
+	}
+	return "<redacted>"
+}
+
+// logUkey is a user key log field value.
+type logUkey []byte
+
+func (k logUkey) format(redact bool) string {
+	if redact && k != nil {
+		return "<redacted>"
+	}
+	return string(k)
+}
+
+// redactErr returns the given error with the user keys of the errors holding
+// them redacted, including errors wrapped by errors.ErrCorrupted.
+func redactErr(err error) error {
+	switch e := err.(type) {
+	case userLogValue:
+		return errors.New(e.format(true))
+	case *errors.ErrCorrupted:
+		if r := redactErr(e.Err); r != e.Err {
+			return &errors.ErrCorrupted{Fd: e.Fd, Err: r}
+		}
+	}
+	return err
+}
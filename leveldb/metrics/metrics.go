@@ -0,0 +1,306 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package metrics exports LevelDB statistics in Prometheus text exposition
+// format and through expvar.
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-update/goleveldb/leveldb"
+	"github.com/golang-update/goleveldb/leveldb/cache"
+)
+
+const (
+	counterType = "counter"
+	gaugeType   = "gauge"
+)
+
+// Label is a metric label.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a single value of a metric family.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named group of samples that share help text and type.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string // "counter" or "gauge"
+	Samples []Sample
+}
+
+// Collector collects metrics of a DB. It implements http.Handler, serving
+// the metrics in Prometheus text exposition format.
+type Collector struct {
+	db     *leveldb.DB
+	labels []Label
+}
+
+// Names of the labels added by the collector to the samples of some
+// metrics.
+var sampleLabels = map[string]bool{
+	"cache":  true,
+	"kind":   true,
+	"level":  true,
+	"source": true,
+	"type":   true,
+}
+
+// New creates a new collector for the given DB. The given labels are added
+// to every sample, which allows exporting multiple DBs side by side. A label
+// named like one added by the collector, "cache", "kind", "level", "source"
+// or "type", is renamed by prefixing it with "exported_", as Prometheus
+// does for conflicting target labels.
+func New(db *leveldb.DB, labels map[string]string) *Collector {
+	c := &Collector{db: db}
+	for name, value := range labels {
+		if sampleLabels[name] {
+			name = "exported_" + name
+			for _, ok := labels[name]; ok; _, ok = labels[name] {
+				name = "exported_" + name
+			}
+		}
+		c.labels = append(c.labels, Label{name, value})
+	}
+	sort.Slice(c.labels, func(i, j int) bool {
+		return c.labels[i].Name < c.labels[j].Name
+	})
+	return c
+}
+
+func (c *Collector) labelsWith(extra ...Label) []Label {
+	labels := make([]Label, 0, len(c.labels)+len(extra))
+	labels = append(labels, c.labels...)
+	return append(labels, extra...)
+}
+
+func (c *Collector) family(name, help, typ string, value float64) Family {
+	return Family{name, help, typ, []Sample{{c.labelsWith(), value}}}
+}
+
+func (c *Collector) cacheFamilies(block, file cache.Stats) []Family {
+	caches := []struct {
+		name  string
+		stats cache.Stats
+	}{{"block", block}, {"file", file}}
+	metrics := []struct {
+		name, help, typ string
+		value           func(s cache.Stats) float64
+	}{
+		{"leveldb_cache_buckets", "Number of cache hash buckets.", gaugeType, func(s cache.Stats) float64 { return float64(s.Buckets) }},
+		{"leveldb_cache_nodes", "Number of cache nodes.", gaugeType, func(s cache.Stats) float64 { return float64(s.Nodes) }},
+		{"leveldb_cache_size", "Total charge of cached nodes.", gaugeType, func(s cache.Stats) float64 { return float64(s.Size) }},
+		{"leveldb_cache_grows_total", "Number of cache hash table grows.", counterType, func(s cache.Stats) float64 { return float64(s.GrowCount) }},
+		{"leveldb_cache_shrinks_total", "Number of cache hash table shrinks.", counterType, func(s cache.Stats) float64 { return float64(s.ShrinkCount) }},
+		{"leveldb_cache_hits_total", "Number of cache hits.", counterType, func(s cache.Stats) float64 { return float64(s.HitCount) }},
+		{"leveldb_cache_misses_total", "Number of cache misses.", counterType, func(s cache.Stats) float64 { return float64(s.MissCount) }},
+		{"leveldb_cache_sets_total", "Number of cache insertions.", counterType, func(s cache.Stats) float64 { return float64(s.SetCount) }},
+		{"leveldb_cache_deletes_total", "Number of cache deletions.", counterType, func(s cache.Stats) float64 { return float64(s.DelCount) }},
+	}
+	families := make([]Family, 0, len(metrics))
+	for _, m := range metrics {
+		f := Family{Name: m.name, Help: m.help, Type: m.typ}
+		for _, x := range caches {
+			f.Samples = append(f.Samples, Sample{c.labelsWith(Label{"cache", x.name}), m.value(x.stats)})
+		}
+		families = append(families, f)
+	}
+	return families
+}
+
+func (c *Collector) levelFamily(name, help, typ string, n int, value func(level int) float64) Family {
+	f := Family{Name: name, Help: help, Type: typ}
+	for level := 0; level < n; level++ {
+		f.Samples = append(f.Samples, Sample{c.labelsWith(Label{"level", strconv.Itoa(level)}), value(level)})
+	}
+	return f
+}
+
+// Collect returns the current metrics of the DB.
+func (c *Collector) Collect() ([]Family, error) {
+	s := &leveldb.DBStats{}
+	if err := c.db.Stats(s); err != nil {
+		return nil, err
+	}
+
+	b2f := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	families := []Family{
+		c.family("leveldb_write_delays_total", "Number of delayed writes.", counterType, float64(s.WriteDelayCount)),
+		c.family("leveldb_write_delay_seconds_total", "Total time writes were delayed.", counterType, s.WriteDelayDuration.Seconds()),
+		c.family("leveldb_write_paused", "Whether writes are paused.", gaugeType, b2f(s.WritePaused)),
+		c.family("leveldb_alive_snapshots", "Number of alive snapshots.", gaugeType, float64(s.AliveSnapshots)),
+		c.family("leveldb_alive_iterators", "Number of alive iterators.", gaugeType, float64(s.AliveIterators)),
+		c.family("leveldb_io_read_bytes_total", "Bytes read from storage.", counterType, float64(s.IORead)),
+		c.family("leveldb_io_write_bytes_total", "Bytes written to storage.", counterType, float64(s.IOWrite)),
+		c.family("leveldb_block_cache_size_bytes", "Size of the block cache.", gaugeType, float64(s.BlockCacheSize)),
+		c.family("leveldb_opened_tables", "Number of opened tables.", gaugeType, float64(s.OpenedTablesCount)),
+	}
+	families = append(families, c.cacheFamilies(s.BlockCache, s.FileCache)...)
+
+	n := len(s.LevelSizes)
+	families = append(families,
+		c.levelFamily("leveldb_level_size_bytes", "Total size of tables in the level.", gaugeType, n,
+			func(level int) float64 { return float64(s.LevelSizes[level]) }),
+		c.levelFamily("leveldb_level_tables", "Number of tables in the level.", gaugeType, n,
+			func(level int) float64 { return float64(s.LevelTablesCounts[level]) }),
+		c.levelFamily("leveldb_level_compaction_read_bytes_total", "Bytes read by compactions into the level.", counterType, n,
+			func(level int) float64 { return float64(s.LevelRead[level]) }),
+		c.levelFamily("leveldb_level_compaction_write_bytes_total", "Bytes written by compactions into the level.", counterType, n,
+			func(level int) float64 { return float64(s.LevelWrite[level]) }),
+		c.levelFamily("leveldb_level_compaction_seconds_total", "Time spent by compactions into the level.", counterType, n,
+			func(level int) float64 { return s.LevelDurations[level].Seconds() }),
+	)
+
+	tk := &s.Tickers
+	families = append(families,
+		c.levelFamily("leveldb_level_keys_written_total", "Number of keys written into the level.", counterType, len(tk.LevelKeysWritten),
+			func(level int) float64 { return float64(tk.LevelKeysWritten[level]) }),
+		c.family("leveldb_filter_useful_total", "Number of filter lookups that avoided a data block read.", counterType, float64(tk.FilterUseful)),
+		c.family("leveldb_filter_full_positive_total", "Number of filter lookups that reported the key may be present.", counterType, float64(tk.FilterFullPositive)),
+		c.family("leveldb_scrubbed_tables_total", "Number of tables verified by the background scrubber.", counterType, float64(tk.ScrubbedTables)),
+		c.family("leveldb_scrubbed_bytes_total", "Bytes verified by the background scrubber.", counterType, float64(tk.ScrubbedBytes)),
+		c.family("leveldb_scrub_passes_total", "Number of full passes completed by the background scrubber.", counterType, float64(tk.ScrubPasses)),
+		c.family("leveldb_scrub_corruptions_total", "Number of corrupted tables found by the background scrubber.", counterType, float64(tk.ScrubCorruptions)),
+		c.family("leveldb_quarantined_tables_total", "Number of corrupted tables quarantined.", counterType, float64(tk.QuarantinedTables)),
+		c.family("leveldb_row_cache_hits_total", "Number of row cache hits.", counterType, float64(tk.RowCacheHits)),
+		c.family("leveldb_row_cache_misses_total", "Number of row cache misses.", counterType, float64(tk.RowCacheMisses)),
+	)
+	blockCache := []struct {
+		name, help string
+		values     [3]uint64
+	}{
+		{"leveldb_block_cache_hits_total", "Number of block cache hits by block kind.",
+			[3]uint64{tk.DataBlockCacheHits, tk.IndexBlockCacheHits, tk.FilterBlockCacheHits}},
+		{"leveldb_block_cache_misses_total", "Number of block cache misses by block kind.",
+			[3]uint64{tk.DataBlockCacheMisses, tk.IndexBlockCacheMisses, tk.FilterBlockCacheMisses}},
+	}
+	for _, x := range blockCache {
+		f := Family{Name: x.name, Help: x.help, Type: counterType}
+		for i, kind := range []string{"data", "index", "filter"} {
+			f.Samples = append(f.Samples, Sample{c.labelsWith(Label{"kind", kind}), float64(x.values[i])})
+		}
+		families = append(families, f)
+	}
+	reads := Family{Name: "leveldb_table_read_bytes_total", Help: "Bytes read from tables by source.", Type: counterType}
+	for _, x := range []struct {
+		source string
+		bytes  uint64
+	}{
+		{"user", tk.UserReadBytes},
+		{"compaction", tk.CompactionReadBytes},
+	} {
+		reads.Samples = append(reads.Samples, Sample{c.labelsWith(Label{"source", x.source}), float64(x.bytes)})
+	}
+	families = append(families, reads)
+
+	comps := Family{Name: "leveldb_compactions_total", Help: "Number of compactions by type.", Type: counterType}
+	for _, x := range []struct {
+		typ   string
+		count uint32
+	}{
+		{"memdb", s.MemComp},
+		{"level0", s.Level0Comp},
+		{"nonlevel0", s.NonLevel0Comp},
+		{"seek", s.SeekComp},
+	} {
+		comps.Samples = append(comps.Samples, Sample{c.labelsWith(Label{"type", x.typ}), float64(x.count)})
+	}
+	families = append(families, comps)
+	return families, nil
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// WriteText writes the given metric families in Prometheus text exposition
+// format.
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, f.Help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l.Name, labelValueEscaper.Replace(l.Value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	families, err := c.Collect()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteText(w, families)
+}
+
+// String implements expvar.Var. The metrics are rendered as a JSON object
+// keyed by metric name. Metrics with labels other than the collector labels
+// are rendered as nested objects keyed by label value.
+func (c *Collector) String() string {
+	families, err := c.Collect()
+	if err != nil {
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(b)
+	}
+	m := make(map[string]interface{}, len(families))
+	for _, f := range families {
+		if len(f.Samples) == 1 && len(f.Samples[0].Labels) == len(c.labels) {
+			m[f.Name] = f.Samples[0].Value
+			continue
+		}
+		values := make(map[string]float64, len(f.Samples))
+		for _, s := range f.Samples {
+			values[s.Labels[len(s.Labels)-1].Value] = s.Value
+		}
+		m[f.Name] = values
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// Publish publishes the collector under the given name with expvar.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, c)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-update/goleveldb/leveldb"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/util"
+)
+
+func openDB(t *testing.T) *leveldb.DB {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("key000"), nil); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestHandler(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	c := New(db, map[string]string{"db": `a"b`})
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE leveldb_compactions_total counter\n",
+		`leveldb_compactions_total{db="a\"b",type="memdb"} 1` + "\n",
+		`leveldb_level_tables{db="a\"b",level="0"} 0` + "\n",
+		`leveldb_cache_hits_total{db="a\"b",cache="file"} `,
+		"# TYPE leveldb_opened_tables gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	db.Close()
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 503 {
+		t.Errorf("got status %d after close", rec.Code)
+	}
+}
+
+func TestLabelConflict(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	c := New(db, map[string]string{"level": "a", "type": "b", "exported_type": "c"})
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`leveldb_level_tables{exported_exported_type="b",exported_level="a",exported_type="c",level="0"} 0` + "\n",
+		`leveldb_compactions_total{exported_exported_type="b",exported_level="a",exported_type="c",type="memdb"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	c := New(db, nil)
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(c.String()), &m); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m["leveldb_opened_tables"].(float64); !ok || v < 1 {
+		t.Errorf("leveldb_opened_tables = %v", m["leveldb_opened_tables"])
+	}
+	comps, ok := m["leveldb_compactions_total"].(map[string]interface{})
+	if !ok || comps["memdb"] != float64(1) {
+		t.Errorf("leveldb_compactions_total = %v", m["leveldb_compactions_total"])
+	}
+	sizes, ok := m["leveldb_level_size_bytes"].(map[string]interface{})
+	if !ok || len(sizes) == 0 {
+		t.Errorf("leveldb_level_size_bytes = %v", m["leveldb_level_size_bytes"])
+	}
+}
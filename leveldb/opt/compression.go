@@ -0,0 +1,415 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+
+	"github.com/golang-update/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec is a 'sorted table' block compression codec, see
+// RegisterCompression.
+//
+// A Codec must be safe for concurrent use.
+type Codec interface {
+	// Encode appends the compressed src to dst and returns the resulting
+	// slice. The level is the value of Options.CompressionLevel, zero
+	// means the codec default.
+	Encode(dst, src []byte, level int) ([]byte, error)
+
+	// DecodedLen returns the length of the decoded src.
+	DecodedLen(src []byte) (int, error)
+
+	// Decode decodes src into dst, which has the length returned by
+	// DecodedLen.
+	Decode(dst, src []byte) error
+}
+
+// DictCodec is a Codec that supports compression dictionaries, see
+// Options.CompressionDictSize.
+type DictCodec interface {
+	Codec
+
+	// TrainDict trains a dictionary of at most maxSize bytes from the given
+	// samples.
+	TrainDict(samples [][]byte, maxSize int) ([]byte, error)
+
+	// WithDict returns a codec that compresses and decompresses using the
+	// given dictionary, as returned by TrainDict. If the returned codec
+	// implements io.Closer, it is closed once the dictionary is no longer
+	// used.
+	WithDict(dict []byte) (Codec, error)
+}
+
+type compressionEntry struct {
+	name      string
+	blockType byte
+	codec     Codec
+}
+
+var (
+	compressionMu     sync.RWMutex
+	compressions      = map[Compression]*compressionEntry{}
+	compressionBlocks [256]*compressionEntry
+)
+
+// Block types of the built-in codecs, these are part of the file format.
+// Block types 0-7 are reserved, and match the block types of RocksDB where
+// applicable.
+const (
+	noCompressionBlockType     = 0
+	snappyCompressionBlockType = 1
+	lz4CompressionBlockType    = 4
+	zstdCompressionBlockType   = 7
+	reservedBlockTypes         = 8
+)
+
+func init() {
+	register := func(c Compression, name string, blockType byte, codec Codec) {
+		e := &compressionEntry{name, blockType, codec}
+		compressions[c] = e
+		compressionBlocks[blockType] = e
+	}
+	register(NoCompression, "none", noCompressionBlockType, nil)
+	register(SnappyCompression, "snappy", snappyCompressionBlockType, snappyCodec{})
+	register(ZstdCompression, "zstd", zstdCompressionBlockType, &zstdCodec{})
+	register(LZ4Compression, "lz4", lz4CompressionBlockType, lz4Codec{})
+}
+
+// RegisterCompression registers a user-supplied codec, and returns the
+// Compression value that selects it. The name must be unique, and the block
+// type is the byte recorded in the trailer of each block compressed with the
+// codec, which selects the codec when the block is read. Block types below 8
+// are reserved.
+//
+// The codec must be registered before opening any DB that uses it, for
+// reading or writing, e.g. from an init function.
+func RegisterCompression(name string, blockType byte, codec Codec) (Compression, error) {
+	if codec == nil {
+		return 0, errors.New("leveldb/opt: nil compression codec")
+	}
+	if blockType < reservedBlockTypes {
+		return 0, fmt.Errorf("leveldb/opt: compression block type %d is reserved", blockType)
+	}
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	if e := compressionBlocks[blockType]; e != nil {
+		return 0, fmt.Errorf("leveldb/opt: compression block type %d is already registered by %q", blockType, e.name)
+	}
+	if _, ok := compressionByName(name); ok {
+		return 0, fmt.Errorf("leveldb/opt: compression %q is already registered", name)
+	}
+	c := nCompression
+	for compressions[c] != nil {
+		c++
+	}
+	e := &compressionEntry{name, blockType, codec}
+	compressions[c] = e
+	compressionBlocks[blockType] = e
+	return c, nil
+}
+
+func (c Compression) entry() *compressionEntry {
+	compressionMu.RLock()
+	e := compressions[c]
+	compressionMu.RUnlock()
+	return e
+}
+
+// Codec returns the codec of the compression, or nil if it doesn't compress
+// or isn't registered.
+func (c Compression) Codec() Codec {
+	if e := c.entry(); e != nil {
+		return e.codec
+	}
+	return nil
+}
+
+// BlockType returns the block trailer type byte of the compression.
+func (c Compression) BlockType() byte {
+	if e := c.entry(); e != nil {
+		return e.blockType
+	}
+	return noCompressionBlockType
+}
+
+// CompressionCodec returns the codec for the given block trailer type byte.
+// It returns a nil codec for uncompressed blocks, and false if the block
+// type isn't registered.
+func CompressionCodec(blockType byte) (Codec, bool) {
+	compressionMu.RLock()
+	e := compressionBlocks[blockType]
+	compressionMu.RUnlock()
+	if e == nil {
+		return nil, false
+	}
+	return e.codec, true
+}
+
+func compressionByName(name string) (Compression, bool) {
+	if name == "default" {
+		return DefaultCompression, true
+	}
+	for c, e := range compressions {
+		if e.name == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// CompressionByName returns the compression with the given name, as
+// returned by Compression.String.
+func CompressionByName(name string) (Compression, bool) {
+	compressionMu.RLock()
+	defer compressionMu.RUnlock()
+	return compressionByName(name)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(dst, src []byte, level int) ([]byte, error) {
+	n := len(dst)
+	if m := n + snappy.MaxEncodedLen(len(src)); cap(dst) < m {
+		dst = append(make([]byte, 0, m), dst...)
+	}
+	encoded := snappy.Encode(dst[n:cap(dst)], src)
+	return dst[:n+len(encoded)], nil
+}
+
+func (snappyCodec) DecodedLen(src []byte) (int, error) {
+	return snappy.DecodedLen(src)
+}
+
+func (snappyCodec) Decode(dst, src []byte) error {
+	decoded, err := snappy.Decode(dst, src)
+	if err == nil && len(decoded) != len(dst) {
+		err = snappy.ErrCorrupt
+	}
+	return err
+}
+
+var (
+	errDecodedLen  = errors.New("leveldb/opt: invalid decoded length")
+	errCodecClosed = errors.New("leveldb/opt: codec closed")
+)
+
+// The zstd and LZ4 codecs prefix the compressed data with its uvarint
+// encoded decoded length.
+
+func appendDecodedLen(dst []byte, n int) []byte {
+	return binary.AppendUvarint(dst, uint64(n))
+}
+
+func decodedLen(src []byte) (int, int, error) {
+	n, m := binary.Uvarint(src)
+	if m <= 0 || n > uint64(maxInt) {
+		return 0, 0, errDecodedLen
+	}
+	return int(n), m, nil
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// zstdCodec is a zstd codec. The level is a zstd compression level, which
+// is mapped to the nearest level supported by the encoder. It supports
+// dictionaries.
+type zstdCodec struct {
+	dict []byte
+
+	mu       sync.Mutex
+	encoders map[zstd.EncoderLevel]*zstd.Encoder
+	decOnce  sync.Once
+	decoder  *zstd.Decoder
+	decErr   error
+}
+
+func (c *zstdCodec) encoder(level int) (*zstd.Encoder, error) {
+	el := zstd.SpeedDefault
+	if level != 0 {
+		el = zstd.EncoderLevelFromZstd(level)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if enc := c.encoders[el]; enc != nil {
+		return enc, nil
+	}
+	eopts := []zstd.EOption{zstd.WithEncoderLevel(el), zstd.WithEncoderConcurrency(1)}
+	if c.dict != nil {
+		eopts = append(eopts, zstd.WithEncoderDict(c.dict))
+	}
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.encoders == nil {
+		c.encoders = make(map[zstd.EncoderLevel]*zstd.Encoder)
+	}
+	c.encoders[el] = enc
+	return enc, nil
+}
+
+func (c *zstdCodec) Encode(dst, src []byte, level int) ([]byte, error) {
+	enc, err := c.encoder(level)
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(src, appendDecodedLen(dst, len(src))), nil
+}
+
+func (c *zstdCodec) DecodedLen(src []byte) (int, error) {
+	n, _, err := decodedLen(src)
+	return n, err
+}
+
+func (c *zstdCodec) Decode(dst, src []byte) error {
+	c.decOnce.Do(func() {
+		// The codec of a dictionary is used by a single table reader, it
+		// is given a single block decoder, as its encoders are, so the
+		// open tables don't hold GOMAXPROCS decoders each.
+		dopts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+		if c.dict != nil {
+			dopts = []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(c.dict)}
+		}
+		c.decoder, c.decErr = zstd.NewReader(nil, dopts...)
+	})
+	if c.decErr != nil {
+		return c.decErr
+	}
+	n, m, err := decodedLen(src)
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return errDecodedLen
+	}
+	decoded, err := c.decoder.DecodeAll(src[m:], dst[:0])
+	if err != nil {
+		return err
+	}
+	if len(decoded) != n || (n > 0 && &decoded[0] != &dst[0]) {
+		return errDecodedLen
+	}
+	return nil
+}
+
+// Close releases the encoders and the decoder of the codec, it must not be
+// used afterward. Only the codecs returned by WithDict are closed.
+func (c *zstdCodec) Close() error {
+	c.mu.Lock()
+	for el, enc := range c.encoders {
+		enc.Close()
+		delete(c.encoders, el)
+	}
+	c.mu.Unlock()
+	c.decOnce.Do(func() {})
+	if c.decoder != nil {
+		c.decoder.Close()
+		c.decoder = nil
+	}
+	c.decErr = errCodecClosed
+	return nil
+}
+
+func (c *zstdCodec) TrainDict(samples [][]byte, maxSize int) ([]byte, error) {
+	// The dictionary content is the tail of the samples, which the
+	// dictionary tables are built from.
+	var history []byte
+	for i := len(samples) - 1; i >= 0 && len(history) < maxSize; i-- {
+		history = append(samples[i][:len(samples[i]):len(samples[i])], history...)
+	}
+	if len(history) > maxSize {
+		history = history[len(history)-maxSize:]
+	}
+	for {
+		if len(history) < 8 {
+			return nil, errors.New("leveldb/opt: not enough samples for a zstd dictionary")
+		}
+		dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+			// Dictionary IDs below 32768 are reserved.
+			ID:       1<<15 + crc32.ChecksumIEEE(history)%(1<<31-1<<15),
+			Contents: samples,
+			History:  history,
+			Offsets:  [3]int{1, 4, 8},
+		})
+		if err != nil || len(dict) <= maxSize {
+			return dict, err
+		}
+		// Make room for the entropy tables.
+		history = history[len(dict)-maxSize:]
+	}
+}
+
+func (c *zstdCodec) WithDict(dict []byte) (Codec, error) {
+	if _, err := zstd.InspectDictionary(dict); err != nil {
+		return nil, err
+	}
+	return &zstdCodec{dict: dict}, nil
+}
+
+// lz4Codec is an LZ4 codec. Level zero uses the fast compressor, levels
+// 1 to 9 use the high compression compressor with increasing search depth.
+type lz4Codec struct{}
+
+var lz4Compressors = sync.Pool{New: func() interface{} { return new(lz4.Compressor) }}
+
+func (lz4Codec) Encode(dst, src []byte, level int) ([]byte, error) {
+	dst = appendDecodedLen(dst, len(src))
+	n := len(dst)
+	if m := n + lz4.CompressBlockBound(len(src)); cap(dst) < m {
+		dst = append(make([]byte, 0, m), dst...)
+	}
+	var (
+		written int
+		err     error
+	)
+	if level <= 0 {
+		c := lz4Compressors.Get().(*lz4.Compressor)
+		written, err = c.CompressBlock(src, dst[n:cap(dst)])
+		lz4Compressors.Put(c)
+	} else {
+		if level > 9 {
+			level = 9
+		}
+		c := lz4.CompressorHC{Level: lz4.Level1 << (level - 1)}
+		written, err = c.CompressBlock(src, dst[n:cap(dst)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n+written], nil
+}
+
+func (lz4Codec) DecodedLen(src []byte) (int, error) {
+	n, _, err := decodedLen(src)
+	return n, err
+}
+
+func (lz4Codec) Decode(dst, src []byte) error {
+	n, m, err := decodedLen(src)
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return errDecodedLen
+	}
+	if n == 0 {
+		return nil
+	}
+	written, err := lz4.UncompressBlock(src[m:], dst)
+	if err == nil && written != n {
+		err = errDecodedLen
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"time"
+)
+
+// CompactionReason is the reason a table compaction was started.
+type CompactionReason int
+
+const (
+	// CompactionReasonLevel0 is a compaction triggered by number of
+	// level-0 tables.
+	CompactionReasonLevel0 CompactionReason = iota
+	// CompactionReasonSize is a compaction triggered by level size.
+	CompactionReasonSize
+	// CompactionReasonSeek is a compaction triggered by 'level seeks'.
+	CompactionReasonSeek
+	// CompactionReasonManual is a compaction requested by CompactRange.
+	CompactionReasonManual
+)
+
+func (r CompactionReason) String() string {
+	switch r {
+	case CompactionReasonLevel0:
+		return "level0"
+	case CompactionReasonSize:
+		return "size"
+	case CompactionReasonSeek:
+		return "seek"
+	case CompactionReasonManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// WriteStallCondition is the write throttling state of the DB.
+type WriteStallCondition int
+
+const (
+	// WriteStallNormal means writes aren't throttled.
+	WriteStallNormal WriteStallCondition = iota
+	// WriteStallDelayed means writes are slowed down because number of
+	// level-0 tables reached WriteL0SlowdownTrigger.
+	WriteStallDelayed
+	// WriteStallStopped means writes are paused because number of level-0
+	// tables reached WriteL0PauseTrigger.
+	WriteStallStopped
+)
+
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallNormal:
+		return "normal"
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// TableFileInfo describes a table file.
+type TableFileInfo struct {
+	// Num is the file number.
+	Num int64
+	// Level is the level the table belongs to, or -1 if unknown.
+	Level int
+	// Size is the file size in bytes, or zero if unknown.
+	Size int64
+}
+
+// FlushInfo describes a memdb flush.
+type FlushInfo struct {
+	// Entries is the number of entries in the memdb.
+	Entries int
+	// Size is the size of the memdb in bytes.
+	Size int64
+	// Level is the level the memdb is flushed to. Only set on completion.
+	Level int
+	// Tables are the created tables. Only set on completion.
+	Tables []TableFileInfo
+	// Duration is the time taken by the flush. Only set on completion.
+	Duration time.Duration
+}
+
+// CompactionInfo describes a table compaction.
+type CompactionInfo struct {
+	Reason CompactionReason
+	// SourceLevel and TargetLevel are the levels the compaction reads from
+	// and writes to.
+	SourceLevel int
+	TargetLevel int
+	// Inputs are the compacted tables from both levels.
+	Inputs []TableFileInfo
+	// Outputs are the created tables. Only set on completion.
+	Outputs []TableFileInfo
+	// Trivial is true if the table is moved to the target level without
+	// being rewritten.
+	Trivial bool
+	// ReadBytes and WriteBytes are bytes read from inputs and written to
+	// outputs. WriteBytes is only set on completion.
+	ReadBytes  int64
+	WriteBytes int64
+	// Duration is the time taken by the compaction. Only set on completion.
+	Duration time.Duration
+	// Canceled is true if the compaction was canceled by
+	// DB.PauseBackgroundWork, or because one of its inputs was
+	// quarantined, and its output discarded.
+	Canceled bool
+}
+
+// WriteStallInfo describes a change of write throttling state.
+type WriteStallInfo struct {
+	Prev WriteStallCondition
+	Cur  WriteStallCondition
+}
+
+// CorruptionInfo describes a corrupted table found by the background
+// scrubber.
+type CorruptionInfo struct {
+	Table TableFileInfo
+	Err   error
+}
+
+// EventListener receives events of DB background work. The callbacks are
+// called synchronously from the goroutine doing the work, so they should
+// return quickly and must not call into the DB.
+type EventListener interface {
+	OnFlushBegin(info FlushInfo)
+	OnFlushCompleted(info FlushInfo)
+	OnCompactionBegin(info CompactionInfo)
+	OnCompactionCompleted(info CompactionInfo)
+	OnTableFileCreated(info TableFileInfo)
+	OnTableFileDeleted(info TableFileInfo)
+	OnWriteStall(info WriteStallInfo)
+	OnBackgroundError(err error)
+	OnCorruption(info CorruptionInfo)
+}
+
+// NoopEventListener is an EventListener that does nothing. It can be
+// embedded to implement only some of the callbacks.
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(FlushInfo)               {}
+func (NoopEventListener) OnFlushCompleted(FlushInfo)           {}
+func (NoopEventListener) OnCompactionBegin(CompactionInfo)     {}
+func (NoopEventListener) OnCompactionCompleted(CompactionInfo) {}
+func (NoopEventListener) OnTableFileCreated(TableFileInfo)     {}
+func (NoopEventListener) OnTableFileDeleted(TableFileInfo)     {}
+func (NoopEventListener) OnWriteStall(WriteStallInfo)          {}
+func (NoopEventListener) OnBackgroundError(error)              {}
+func (NoopEventListener) OnCorruption(CorruptionInfo)          {}
@@ -0,0 +1,87 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogLevel is the severity of a log record.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// LogField is a key/value pair attached to a log record. Value holds one of
+// int, int64, uint64, float64, string, bool, time.Duration, error or a slice
+// of them.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is a leveled, structured logger.
+type Logger interface {
+	// Log writes a log record. Implementations must be safe for concurrent
+	// use.
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	var slevel slog.Level
+	switch level {
+	case LogDebug:
+		slevel = slog.LevelDebug
+	case LogWarn:
+		slevel = slog.LevelWarn
+	case LogError:
+		slevel = slog.LevelError
+	default:
+		slevel = slog.LevelInfo
+	}
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, slevel) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, slevel, msg, attrs...)
+}
+
+// NewSlogLogger returns a Logger that writes log records to the given
+// slog.Logger. If l is nil then slog.Default is used.
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogLogger{l}
+}
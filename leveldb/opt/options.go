@@ -26,9 +26,11 @@ var (
 	DefaultBlockCacheCapacity            = 8 * MiB
 	DefaultBlockRestartInterval          = 16
 	DefaultBlockSize                     = 4 * KiB
+	DefaultCompactionConcurrency         = 1
 	DefaultCompactionExpandLimitFactor   = 25
 	DefaultCompactionGPOverlapsFactor    = 10
 	DefaultCompactionL0Trigger           = 4
+	DefaultCompactionNumLevels           = 7
 	DefaultCompactionSourceLimitFactor   = 1
 	DefaultCompactionTableSize           = 2 * MiB
 	DefaultCompactionTableSizeMultiplier = 1.0
@@ -88,6 +90,10 @@ func (p *passthroughCacher) New(capacity int) cache.Cacher {
 //	    ...
 //	    db2, err2 := leveldb.OpenFile("path/to/db2", options)
 //	    ...
+//
+// Sharing a block cacher makes the DB instances evict each other's blocks
+// by capacity, use Options.BlockCache to also account the block cache
+// usage of each DB.
 func PassthroughCacher(x cache.Cacher) Cacher {
 	return &passthroughCacher{x}
 }
@@ -97,10 +103,28 @@ func NewLRU(capacity int) Cacher {
 	return PassthroughCacher(cache.NewLRU(capacity))
 }
 
+// NewCLOCK creates CLOCK 'passthrough cacher'.
+func NewCLOCK(capacity int) Cacher {
+	return PassthroughCacher(cache.NewCLOCK(capacity))
+}
+
+// NewTinyLFU creates W-TinyLFU 'passthrough cacher'.
+func NewTinyLFU(capacity int) Cacher {
+	return PassthroughCacher(cache.NewTinyLFU(capacity))
+}
+
 var (
 	// LRUCacher is the LRU-cache algorithm.
 	LRUCacher = CacherFunc(cache.NewLRU)
 
+	// CLOCKCacher is the CLOCK-cache algorithm, whose cache hits don't
+	// contend on a lock.
+	CLOCKCacher = CacherFunc(cache.NewCLOCK)
+
+	// TinyLFUCacher is the W-TinyLFU-cache algorithm, which resists scans
+	// by only admitting frequently accessed blocks.
+	TinyLFUCacher = CacherFunc(cache.NewTinyLFU)
+
 	// NoCacher is the value to disable caching algorithm.
 	NoCacher = CacherFunc(nil)
 )
@@ -109,24 +133,94 @@ var (
 type Compression uint
 
 func (c Compression) String() string {
-	switch c {
-	case DefaultCompression:
+	if c == DefaultCompression {
 		return "default"
-	case NoCompression:
-		return "none"
-	case SnappyCompression:
-		return "snappy"
+	}
+	if e := c.entry(); e != nil {
+		return e.name
 	}
 	return "invalid"
 }
 
+// Built-in compressions, other compressions may be registered with
+// RegisterCompression.
 const (
 	DefaultCompression Compression = iota
 	NoCompression
 	SnappyCompression
+	ZstdCompression
+	LZ4Compression
 	nCompression
 )
 
+// ChecksumType is the 'sorted table' block checksum algorithm to use.
+type ChecksumType uint
+
+func (c ChecksumType) String() string {
+	switch c {
+	case DefaultChecksum:
+		return "default"
+	case CRC32CChecksum:
+		return "crc32c"
+	case XXHash64Checksum:
+		return "xxhash64"
+	case XXH3Checksum:
+		return "xxh3"
+	}
+	return "invalid"
+}
+
+// Block checksum algorithms. The 64-bit hashes are truncated to their low
+// 32 bits.
+const (
+	DefaultChecksum ChecksumType = iota
+	CRC32CChecksum
+	XXHash64Checksum
+	XXH3Checksum
+	nChecksumType
+)
+
+// PinPolicy defines the tables whose index and filter blocks are pinned in
+// the block cache.
+type PinPolicy uint
+
+func (p PinPolicy) String() string {
+	switch p {
+	case PinNone:
+		return "none"
+	case PinL0:
+		return "l0"
+	case PinAll:
+		return "all"
+	}
+	return "invalid"
+}
+
+const (
+	// PinNone pins no table, index and filter blocks are evicted as any
+	// other block.
+	PinNone PinPolicy = iota
+
+	// PinL0 pins the tables which are in level-0 when their table reader
+	// is opened. Level-0 tables are read by every lookup.
+	//
+	// The level is checked against the current version once, when the
+	// reader is opened by the first read of the table, or the first one
+	// after the reader was evicted from the open files cache, see
+	// OpenFilesCacheCapacity. Memdb flushes are committed before their
+	// tables are read, so flushed level-0 tables are pinned. The check
+	// isn't repeated on version changes: a table moved out of level-0 by
+	// a trivial compaction stays pinned until its reader is closed.
+	PinL0
+
+	// PinAll pins all tables.
+	PinAll
+)
+
+// MaxTableFormatVersion is the latest 'sorted table' format version, see
+// Options.TableFormatVersion.
+const MaxTableFormatVersion = 2
+
 // Strict is the DB 'strict level'.
 type Strict uint
 
@@ -182,8 +276,19 @@ type Options struct {
 	// The default value is nil
 	AltFilters []filter.Filter
 
+	// BlockCache defines a pre-built 'cache map' for 'sorted table' block
+	// caching, which may be shared by multiple DB instances. Each DB caches
+	// its blocks within its own cache.Scope, and reports only the size of
+	// its own blocks. BlockCacher, BlockCacheCapacity and
+	// BlockCacheHighPriorityRatio are ignored when it is set, the 'cache map'
+	// is configured by its owner and isn't closed by the DB.
+	//
+	// The default value is nil, each DB builds its own block cache.
+	BlockCache *cache.Cache
+
 	// BlockCacher provides cache algorithm for LevelDB 'sorted table' block caching.
-	// Specify NoCacher to disable caching algorithm.
+	// Specify NoCacher to disable caching algorithm. CLOCKCacher avoids lock
+	// contention on cache hits, and TinyLFUCacher resists scans.
 	//
 	// The default value is LRUCacher.
 	BlockCacher Cacher
@@ -200,6 +305,31 @@ type Options struct {
 	// The default if false.
 	BlockCacheEvictRemoved bool
 
+	// BlockCacheHighPriorityRatio defines the fraction of the block cache
+	// capacity reserved for index and filter blocks, which are cached with
+	// high priority. Data blocks can't evict them as long as they fit in
+	// the reserved capacity, so that a large scan doesn't evict every index.
+	// It is only supported by cachers implementing cache.PriorityCacher,
+	// such as LRUCacher.
+	//
+	// The default value is 0, index and filter blocks are cached as data
+	// blocks.
+	BlockCacheHighPriorityRatio float64
+
+	// BlockCachePinning defines the tables whose index and filter blocks
+	// are pinned in the block cache, for as long as their table reader is
+	// open, see PinPolicy.
+	//
+	// The default value is PinNone.
+	BlockCachePinning PinPolicy
+
+	// BlockChecksum defines the 'sorted table' block checksum algorithm.
+	// Checksums other than CRC32CChecksum require table format version 1,
+	// see TableFormatVersion.
+	//
+	// The default value (DefaultChecksum) uses CRC32CChecksum.
+	BlockChecksum ChecksumType
+
 	// BlockRestartInterval is the number of keys between restart points for
 	// delta encoding of keys.
 	//
@@ -212,6 +342,34 @@ type Options struct {
 	// The default value is 4KiB.
 	BlockSize int
 
+	// BottommostCompression defines the 'sorted table' block compression to
+	// use for tables written by compactions into the last non-empty level,
+	// which usually holds most of the data and is rarely rewritten.
+	//
+	// The default value (DefaultCompression) disables it, the compression
+	// of the level is used instead.
+	BottommostCompression Compression
+
+	// CompactionConcurrency defines maximum number of table compactions that
+	// may run at the same time. Compactions only run concurrently if their
+	// levels and key ranges don't conflict with each other. Memdb flushes
+	// take priority, running table compactions pause at their next table
+	// until the flush is done.
+	//
+	// The default value is 1.
+	CompactionConcurrency int
+
+	// CompactionDynamicLevelBytes allows enable dynamic level sizing. If
+	// enabled the per-level size limits are derived backwards from the size
+	// of the last non-empty level, each level being smaller than the next
+	// one by the CompactionTotalSize multiplier, up to the 'base level'
+	// whose limit doesn't exceed CompactionTotalSize for level-1. Levels
+	// above the base level are kept empty; level-0 compaction and memdb
+	// flush go directly to the base level.
+	//
+	// The default is false.
+	CompactionDynamicLevelBytes bool
+
 	// CompactionExpandLimitFactor limits compaction size after expanded.
 	// This will be multiplied by table size limit at compaction target level.
 	//
@@ -231,6 +389,14 @@ type Options struct {
 	// The default value is 4.
 	CompactionL0Trigger int
 
+	// CompactionNumLevels defines number of levels used by dynamic level
+	// sizing. Level-0 is compacted directly into the last level until it
+	// gets large enough to need levels above it.
+	// This only applicable if CompactionDynamicLevelBytes is enabled.
+	//
+	// The default value is 7.
+	CompactionNumLevels int
+
 	// CompactionSourceLimitFactor limits compaction source size. This doesn't apply to
 	// level-0.
 	// This will be multiplied by table size limit at compaction target level.
@@ -287,10 +453,40 @@ type Options struct {
 	Comparer comparer.Comparer
 
 	// Compression defines the 'sorted table' block compression to use.
+	// Tables are readable regardless of this option, as long as the
+	// compression of each block is registered.
 	//
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// CompressionPerLevel defines per-level 'sorted table' block
+	// compression. A table flushed from memdb uses the compression of the
+	// level it is flushed into, a table written by a compaction uses the
+	// compression of the compaction output level.
+	// Use DefaultCompression to use Compression for a level, levels beyond
+	// the slice use Compression as well.
+	//
+	// The default value is nil.
+	CompressionPerLevel []Compression
+
+	// CompressionDictSize defines the maximum size in bytes of the compression
+	// dictionary trained for tables written by compactions with the
+	// BottommostCompression, or the compression of the last level if it is
+	// disabled. The dictionary is trained from the first data blocks of the
+	// compaction, stored in each output table and used to compress their data
+	// blocks, which improves the compression of small, similar values.
+	// The compression must support dictionaries (see DictCodec), such as zstd.
+	//
+	// The default value is 0, which disables dictionary compression.
+	CompressionDictSize int
+
+	// CompressionLevel defines the compression level, its meaning depends
+	// on the compression: for zstd it is a zstd compression level, for LZ4
+	// levels 1 to 9 select high compression; it is ignored by snappy.
+	//
+	// The default value is 0, which uses the default level of the compression.
+	CompressionLevel int
+
 	// DisableBufferPool allows disable use of util.BufferPool functionality.
 	//
 	// The default value is false.
@@ -322,6 +518,12 @@ type Options struct {
 	// The default is false.
 	DisableSeeksCompaction bool
 
+	// EventListener receives events of flushes, compactions, table file
+	// creation and deletion, write stalls and background errors.
+	//
+	// The default value is nil.
+	EventListener EventListener
+
 	// ErrorIfExist defines whether an error should returned if the DB already
 	// exist.
 	//
@@ -335,6 +537,14 @@ type Options struct {
 	// The default value is false.
 	ErrorIfMissing bool
 
+	// ErrorIfOptionsMismatch defines whether an error should returned if the
+	// options differ dangerously from the options the DB was last opened
+	// with, as recorded in its OPTIONS file, e.g. if the Comparer differs.
+	// Differences are logged regardless.
+	//
+	// The default value is false.
+	ErrorIfOptionsMismatch bool
+
 	// Filter defines an 'effective filter' to use. An 'effective filter'
 	// if defined will be used to generate per-table filter block.
 	// The filter name will be stored on disk.
@@ -350,6 +560,18 @@ type Options struct {
 	// The default value is nil.
 	Filter filter.Filter
 
+	// IndexPartitionSize enables partitioned index and filter blocks for
+	// newly written 'sorted tables', and defines the target size in bytes of
+	// each partition. The index and filter are then split into partitions
+	// that are read and cached on demand, and only a small top-level index
+	// of each partition kind is kept by the table reader. This keeps large
+	// tables from filling the block cache with their index and filter.
+	// Partitioned tables are written with format version 2, which readers
+	// without partitioning support reject, see TableFormatVersion.
+	//
+	// The default value is 0, which disables partitioning.
+	IndexPartitionSize int
+
 	// IteratorSamplingRate defines approximate gap (in bytes) between read
 	// sampling of an iterator. The samples will be used to determine when
 	// compaction should be triggered.
@@ -359,6 +581,19 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// LatencyHistograms enables recording of latency histograms for reads,
+	// writes, iterators and compactions. The histograms are reported by
+	// DB.Stats and the 'leveldb.latency' property.
+	//
+	// The default is false.
+	LatencyHistograms bool
+
+	// Logger defines the logger used for engine logging.
+	//
+	// The default value is nil, which writes to the LOG file of the storage
+	// through Storage.Log.
+	Logger Logger
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -381,14 +616,63 @@ type Options struct {
 	// The default value is 200 on MacOS and 500 on other.
 	OpenFilesCacheCapacity int
 
+	// QuarantineCorruptedTables allows the DB to keep working when a table
+	// is found corrupted by a compaction or by the background scrubber.
+	// Instead of setting a persistent error, the table is removed from the
+	// DB, moved aside as a file of type storage.TypeQuarantine, and its key
+	// range is recorded in the manifest. Reads touching that range then
+	// return an ErrDataLoss, unless they find data written after the table
+	// was quarantined, while the rest of the DB keeps serving reads and
+	// writes. See DB.ClearDataLoss.
+	//
+	// The default value is false.
+	QuarantineCorruptedTables bool
+
 	// If true then opens DB in read-only mode.
 	//
 	// The default value is false.
 	ReadOnly bool
 
+	// RowCacheCapacity defines the capacity of the row cache. The row cache
+	// holds the entries found by point lookups, keyed by user key and the
+	// file number of the 'sorted table' they were found in, so that
+	// DB.Get and DB.Has don't need to read and decode the same block
+	// again. Entries of removed tables are evicted along with them. The
+	// row cache uses the BlockCacher algorithm, or if BlockCache is set,
+	// holds the rows in its own scope of BlockCache, whose capacity it
+	// shares; RowCacheCapacity then only enables it. DB.Has only fills
+	// the row cache with the entries already cached by DB.Get.
+	//
+	// The default value is 0, which disables the row cache.
+	RowCacheCapacity int
+
+	// ScrubBandwidth defines the read bandwidth, in bytes per second, of the
+	// background scrubber. The scrubber continuously walks every live table
+	// and verifies the checksums of all its blocks, without filling the
+	// block cache, so that corruption of rarely read data is detected
+	// early. Corruption found is counted in DB.Stats and reported to
+	// EventListener.OnCorruption. Its progress is recorded in the manifest
+	// every minute and when the DB is closed, so that a full pass
+	// eventually finishes even if the DB is often reopened.
+	//
+	// The default value is 0, which disables the scrubber.
+	ScrubBandwidth int
+
 	// Strict defines the DB strict level.
 	Strict Strict
 
+	// TableFormatVersion defines the format version of newly written
+	// 'sorted tables'. Version 0 is the original LevelDB format, version 1
+	// records the block checksum algorithm in the table footer and mixes
+	// the offset of each block into its checksum, which catches misplaced
+	// blocks. Version 2 adds partitioned index and filter blocks, see
+	// IndexPartitionSize. Tables of any version can be read regardless of
+	// this option.
+	//
+	// The default value is 0, or the lowest version BlockChecksum and
+	// IndexPartitionSize require; greater versions are used if required.
+	TableFormatVersion int
+
 	// WriteBuffer defines maximum size of a 'memdb' before flushed to
 	// 'sorted table'. 'memdb' is an in-memory DB backed by an on-disk
 	// unsorted journal.
@@ -398,6 +682,14 @@ type Options struct {
 	// The default value is 4MiB.
 	WriteBuffer int
 
+	// WriteBufferManager caps the total 'memdb' memory of the DB instances
+	// sharing it, see WriteBufferManager. Once exceeded, the DB with the
+	// largest 'memdb' flushes it, regardless of WriteBuffer, and writes
+	// stall until the usage is back under the capacity.
+	//
+	// The default value is nil.
+	WriteBufferManager *WriteBufferManager
+
 	// WriteL0StopTrigger defines number of 'sorted table' at level-0 that will
 	// pause write.
 	//
@@ -430,6 +722,13 @@ func (o *Options) GetAltFilters() []filter.Filter {
 	return o.AltFilters
 }
 
+func (o *Options) GetBlockCache() *cache.Cache {
+	if o == nil || o.GetDisableBlockCache() {
+		return nil
+	}
+	return o.BlockCache
+}
+
 func (o *Options) GetBlockCacher() Cacher {
 	if o == nil || o.BlockCacher == nil {
 		return DefaultBlockCacher
@@ -453,6 +752,29 @@ func (o *Options) GetBlockCacheEvictRemoved() bool {
 	return o.BlockCacheEvictRemoved
 }
 
+func (o *Options) GetBlockCacheHighPriorityRatio() float64 {
+	if o == nil || o.BlockCacheHighPriorityRatio <= 0 {
+		return 0
+	} else if o.BlockCacheHighPriorityRatio > 1 {
+		return 1
+	}
+	return o.BlockCacheHighPriorityRatio
+}
+
+func (o *Options) GetBlockCachePinning() PinPolicy {
+	if o == nil || o.BlockCachePinning > PinAll {
+		return PinNone
+	}
+	return o.BlockCachePinning
+}
+
+func (o *Options) GetBlockChecksum() ChecksumType {
+	if o == nil || o.BlockChecksum <= DefaultChecksum || o.BlockChecksum >= nChecksumType {
+		return CRC32CChecksum
+	}
+	return o.BlockChecksum
+}
+
 func (o *Options) GetBlockRestartInterval() int {
 	if o == nil || o.BlockRestartInterval <= 0 {
 		return DefaultBlockRestartInterval
@@ -467,6 +789,20 @@ func (o *Options) GetBlockSize() int {
 	return o.BlockSize
 }
 
+func (o *Options) GetCompactionConcurrency() int {
+	if o == nil || o.CompactionConcurrency <= 0 {
+		return DefaultCompactionConcurrency
+	}
+	return o.CompactionConcurrency
+}
+
+func (o *Options) GetCompactionDynamicLevelBytes() bool {
+	if o == nil {
+		return false
+	}
+	return o.CompactionDynamicLevelBytes
+}
+
 func (o *Options) GetCompactionExpandLimit(level int) int {
 	factor := DefaultCompactionExpandLimitFactor
 	if o != nil && o.CompactionExpandLimitFactor > 0 {
@@ -490,6 +826,13 @@ func (o *Options) GetCompactionL0Trigger() int {
 	return o.CompactionL0Trigger
 }
 
+func (o *Options) GetCompactionNumLevels() int {
+	if o == nil || o.CompactionNumLevels < 2 {
+		return DefaultCompactionNumLevels
+	}
+	return o.CompactionNumLevels
+}
+
 func (o *Options) GetCompactionSourceLimit(level int) int {
 	factor := DefaultCompactionSourceLimitFactor
 	if o != nil && o.CompactionSourceLimitFactor > 0 {
@@ -548,12 +891,47 @@ func (o *Options) GetComparer() comparer.Comparer {
 }
 
 func (o *Options) GetCompression() Compression {
-	if o == nil || o.Compression <= DefaultCompression || o.Compression >= nCompression {
+	if o == nil || o.Compression <= DefaultCompression || o.Compression.entry() == nil {
 		return DefaultCompressionType
 	}
 	return o.Compression
 }
 
+// GetCompressionPerLevel returns the compression of the given level, or
+// DefaultCompression if Compression should be used.
+func (o *Options) GetCompressionPerLevel(level int) Compression {
+	if o == nil || level < 0 || level >= len(o.CompressionPerLevel) {
+		return DefaultCompression
+	}
+	if c := o.CompressionPerLevel[level]; c.entry() != nil {
+		return c
+	}
+	return DefaultCompression
+}
+
+// GetBottommostCompression returns the bottommost compression, or
+// DefaultCompression if it is disabled.
+func (o *Options) GetBottommostCompression() Compression {
+	if o == nil || o.BottommostCompression.entry() == nil {
+		return DefaultCompression
+	}
+	return o.BottommostCompression
+}
+
+func (o *Options) GetCompressionDictSize() int {
+	if o == nil || o.CompressionDictSize < 0 {
+		return 0
+	}
+	return o.CompressionDictSize
+}
+
+func (o *Options) GetCompressionLevel() int {
+	if o == nil {
+		return 0
+	}
+	return o.CompressionLevel
+}
+
 func (o *Options) GetDisableBufferPool() bool {
 	if o == nil {
 		return false
@@ -589,6 +967,13 @@ func (o *Options) GetDisableSeeksCompaction() bool {
 	return o.DisableSeeksCompaction
 }
 
+func (o *Options) GetEventListener() EventListener {
+	if o == nil || o.EventListener == nil {
+		return NoopEventListener{}
+	}
+	return o.EventListener
+}
+
 func (o *Options) GetErrorIfExist() bool {
 	if o == nil {
 		return false
@@ -603,6 +988,13 @@ func (o *Options) GetErrorIfMissing() bool {
 	return o.ErrorIfMissing
 }
 
+func (o *Options) GetErrorIfOptionsMismatch() bool {
+	if o == nil {
+		return false
+	}
+	return o.ErrorIfOptionsMismatch
+}
+
 func (o *Options) GetFilter() filter.Filter {
 	if o == nil {
 		return nil
@@ -610,6 +1002,13 @@ func (o *Options) GetFilter() filter.Filter {
 	return o.Filter
 }
 
+func (o *Options) GetIndexPartitionSize() int {
+	if o == nil || o.IndexPartitionSize <= 0 {
+		return 0
+	}
+	return o.IndexPartitionSize
+}
+
 func (o *Options) GetIteratorSamplingRate() int {
 	if o == nil || o.IteratorSamplingRate == 0 {
 		return DefaultIteratorSamplingRate
@@ -619,6 +1018,20 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetLatencyHistograms() bool {
+	if o == nil {
+		return false
+	}
+	return o.LatencyHistograms
+}
+
+func (o *Options) GetLogger() Logger {
+	if o == nil {
+		return nil
+	}
+	return o.Logger
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -649,6 +1062,13 @@ func (o *Options) GetOpenFilesCacheCapacity() int {
 	return o.OpenFilesCacheCapacity
 }
 
+func (o *Options) GetQuarantineCorruptedTables() bool {
+	if o == nil {
+		return false
+	}
+	return o.QuarantineCorruptedTables
+}
+
 func (o *Options) GetReadOnly() bool {
 	if o == nil {
 		return false
@@ -656,6 +1076,20 @@ func (o *Options) GetReadOnly() bool {
 	return o.ReadOnly
 }
 
+func (o *Options) GetRowCacheCapacity() int {
+	if o == nil || o.RowCacheCapacity < 0 {
+		return 0
+	}
+	return o.RowCacheCapacity
+}
+
+func (o *Options) GetScrubBandwidth() int {
+	if o == nil || o.ScrubBandwidth < 0 {
+		return 0
+	}
+	return o.ScrubBandwidth
+}
+
 func (o *Options) GetStrict(strict Strict) bool {
 	if o == nil || o.Strict == 0 {
 		return DefaultStrict&strict != 0
@@ -663,6 +1097,23 @@ func (o *Options) GetStrict(strict Strict) bool {
 	return o.Strict&strict != 0
 }
 
+func (o *Options) GetTableFormatVersion() int {
+	var version int
+	if o != nil && o.TableFormatVersion > 0 {
+		version = o.TableFormatVersion
+		if version > MaxTableFormatVersion {
+			version = MaxTableFormatVersion
+		}
+	}
+	if version < 2 && o.GetIndexPartitionSize() > 0 {
+		return 2
+	}
+	if version < 1 && o.GetBlockChecksum() != CRC32CChecksum {
+		return 1
+	}
+	return version
+}
+
 func (o *Options) GetWriteBuffer() int {
 	if o == nil || o.WriteBuffer <= 0 {
 		return DefaultWriteBuffer
@@ -670,6 +1121,13 @@ func (o *Options) GetWriteBuffer() int {
 	return o.WriteBuffer
 }
 
+func (o *Options) GetWriteBufferManager() *WriteBufferManager {
+	if o == nil {
+		return nil
+	}
+	return o.WriteBufferManager
+}
+
 func (o *Options) GetWriteL0PauseTrigger() int {
 	if o == nil || o.WriteL0PauseTrigger == 0 {
 		return DefaultWriteL0PauseTrigger
@@ -701,6 +1159,12 @@ type ReadOptions struct {
 	// The default value is false.
 	DontFillCache bool
 
+	// PerfContext, if not nil, receives counts of the work done by this
+	// 'read operation'. See PerfContext.
+	//
+	// The default value is nil.
+	PerfContext *PerfContext
+
 	// Strict will be OR'ed with global DB 'strict level' unless StrictOverride
 	// is present. Currently only StrictReader that has effect here.
 	Strict Strict
@@ -713,6 +1177,13 @@ func (ro *ReadOptions) GetDontFillCache() bool {
 	return ro.DontFillCache
 }
 
+func (ro *ReadOptions) GetPerfContext() *PerfContext {
+	if ro == nil {
+		return nil
+	}
+	return ro.PerfContext
+}
+
 func (ro *ReadOptions) GetStrict(strict Strict) bool {
 	if ro == nil {
 		return false
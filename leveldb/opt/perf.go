@@ -0,0 +1,71 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"fmt"
+	"time"
+)
+
+// PerfContext counts the work done by a single read operation. It is
+// attached to an operation through ReadOptions.PerfContext, and the
+// operation adds its counts to it. The counts are cumulative, so the same
+// PerfContext may be reused across operations, use Reset to clear it.
+//
+// A PerfContext must not be used by concurrent operations. For iterators,
+// counts are added while the iterator is used, until it is released.
+type PerfContext struct {
+	// TablesProbed is the number of tables looked up by Get or Has.
+	TablesProbed uint64
+	// FilterChecks is the number of filter lookups.
+	FilterChecks uint64
+	// FilterHits is the number of filter lookups that reported the key
+	// may be present.
+	FilterHits uint64
+
+	// BlockCacheHits is the number of blocks found in the block cache.
+	BlockCacheHits uint64
+	// BlockCacheMisses is the number of blocks not found in the block
+	// cache.
+	BlockCacheMisses uint64
+	// BlocksRead is the number of blocks read from storage.
+	BlocksRead uint64
+	// BlockReadBytes is the number of bytes read from storage by block
+	// reads.
+	BlockReadBytes uint64
+
+	// InternalKeysSkipped is the number of internal keys skipped by an
+	// iterator because they are shadowed by newer entries or are newer
+	// than the iterator snapshot.
+	InternalKeysSkipped uint64
+	// TombstonesSkipped is the number of deletion markers skipped by an
+	// iterator.
+	TombstonesSkipped uint64
+
+	// MemdbTime is the time spent looking up memdbs by Get or Has.
+	MemdbTime time.Duration
+	// TableTime is the time spent looking up tables by Get or Has,
+	// including BlockReadTime.
+	TableTime time.Duration
+	// BlockReadTime is the time spent reading and decoding blocks from
+	// storage.
+	BlockReadTime time.Duration
+}
+
+// Reset clears all counts of the PerfContext.
+func (pc *PerfContext) Reset() {
+	*pc = PerfContext{}
+}
+
+func (pc *PerfContext) String() string {
+	return fmt.Sprintf("tables_probed=%d filter_checks=%d filter_hits=%d block_cache_hits=%d block_cache_misses=%d "+
+		"blocks_read=%d block_read_bytes=%d internal_keys_skipped=%d tombstones_skipped=%d "+
+		"memdb_time=%v table_time=%v block_read_time=%v",
+		pc.TablesProbed, pc.FilterChecks, pc.FilterHits, pc.BlockCacheHits, pc.BlockCacheMisses,
+		pc.BlocksRead, pc.BlockReadBytes, pc.InternalKeysSkipped, pc.TombstonesSkipped,
+		pc.MemdbTime, pc.TableTime, pc.BlockReadTime)
+}
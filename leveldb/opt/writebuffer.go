@@ -0,0 +1,50 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"github.com/golang-update/goleveldb/leveldb/internal/writebuffer"
+)
+
+// WriteBufferManager caps the total 'memdb' memory of the DB instances
+// sharing it. Once their total usage exceeds the capacity, the DB with the
+// largest usage is asked to flush its 'memdb', and writes to any of the DB
+// instances stall until the usage is back under the capacity. DB instances
+// whose background work is paused aren't asked to flush, and don't stall
+// the writes to the others.
+//
+// The usage is checked by each DB instance every 1/64 of the capacity
+// written to it, so it may exceed the capacity by that much per instance.
+// Each check takes O(n) in the number of DB instances.
+//
+// Shared write buffer example:
+//
+//	wbm := opt.NewWriteBufferManager(64 * opt.MiB)
+//	options := &opt.Options{
+//	    WriteBufferManager: wbm,
+//	}
+//	db1, err1 := leveldb.OpenFile("path/to/db1", options)
+//	...
+//	db2, err2 := leveldb.OpenFile("path/to/db2", options)
+//	...
+type WriteBufferManager writebuffer.Manager
+
+// NewWriteBufferManager creates a new write buffer manager with the given
+// capacity in bytes.
+func NewWriteBufferManager(capacity int) *WriteBufferManager {
+	return (*WriteBufferManager)(writebuffer.New(capacity))
+}
+
+// Capacity returns the capacity of the write buffer manager.
+func (m *WriteBufferManager) Capacity() int {
+	return (*writebuffer.Manager)(m).Capacity()
+}
+
+// Usage returns the total 'memdb' memory of the registered DB instances.
+func (m *WriteBufferManager) Usage() int {
+	return (*writebuffer.Manager)(m).Usage()
+}
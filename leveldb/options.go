@@ -7,6 +7,13 @@
 package leveldb
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
 	"github.com/golang-update/goleveldb/leveldb/filter"
 	"github.com/golang-update/goleveldb/leveldb/opt"
 )
@@ -39,6 +46,12 @@ func (s *session) setOptions(o *opt.Options) {
 		no.Filter = &iFilter{filter}
 	}
 
+	// Logger.
+	s.logger = o.GetLogger()
+	if s.logger == nil {
+		s.logger = storageLogger{s.stor}
+	}
+
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
 }
@@ -53,6 +66,22 @@ type cachedOptions struct {
 	compactionSourceLimit []int
 	compactionTableSize   []int
 	compactionTotalSize   []int64
+
+	// Options that can be changed at runtime, see DB.SetOptions.
+	mu      sync.Mutex // serializes updates
+	mutable atomic.Pointer[mutableOptions]
+}
+
+type mutableOptions struct {
+	writeBuffer            int
+	compactionL0Trigger    int
+	writeL0SlowdownTrigger int
+	writeL0PauseTrigger    int
+	blockCacheCapacity     int
+	compression            opt.Compression
+
+	// Options for table writers, with the current compression.
+	table *opt.Options
 }
 
 func (co *cachedOptions) cache() {
@@ -69,6 +98,80 @@ func (co *cachedOptions) cache() {
 		co.compactionTableSize[level] = co.Options.GetCompactionTableSize(level)
 		co.compactionTotalSize[level] = co.Options.GetCompactionTotalSize(level)
 	}
+
+	co.mutable.Store(&mutableOptions{
+		writeBuffer:            co.Options.GetWriteBuffer(),
+		compactionL0Trigger:    co.Options.GetCompactionL0Trigger(),
+		writeL0SlowdownTrigger: co.Options.GetWriteL0SlowdownTrigger(),
+		writeL0PauseTrigger:    co.Options.GetWriteL0PauseTrigger(),
+		blockCacheCapacity:     co.Options.GetBlockCacheCapacity(),
+		compression:            co.Options.GetCompression(),
+		table:                  co.Options,
+	})
+}
+
+func (co *cachedOptions) GetWriteBuffer() int {
+	return co.mutable.Load().writeBuffer
+}
+
+func (co *cachedOptions) GetCompactionL0Trigger() int {
+	return co.mutable.Load().compactionL0Trigger
+}
+
+func (co *cachedOptions) GetWriteL0SlowdownTrigger() int {
+	return co.mutable.Load().writeL0SlowdownTrigger
+}
+
+func (co *cachedOptions) GetWriteL0PauseTrigger() int {
+	return co.mutable.Load().writeL0PauseTrigger
+}
+
+func (co *cachedOptions) GetBlockCacheCapacity() int {
+	return co.mutable.Load().blockCacheCapacity
+}
+
+func (co *cachedOptions) GetCompression() opt.Compression {
+	return co.mutable.Load().compression
+}
+
+// current returns the options with the current values of the mutable
+// options.
+func (co *cachedOptions) current() *opt.Options {
+	m := co.mutable.Load()
+	o := *co.Options
+	o.WriteBuffer = m.writeBuffer
+	o.CompactionL0Trigger = m.compactionL0Trigger
+	o.WriteL0SlowdownTrigger = m.writeL0SlowdownTrigger
+	o.WriteL0PauseTrigger = m.writeL0PauseTrigger
+	o.BlockCacheCapacity = m.blockCacheCapacity
+	o.Compression = m.compression
+	return &o
+}
+
+// levelCompression returns the compression for new tables of the given
+// level. Bottommost is true if the tables are written by a compaction into
+// the last non-empty level.
+func (co *cachedOptions) levelCompression(level int, bottommost bool) opt.Compression {
+	if bottommost {
+		if c := co.Options.GetBottommostCompression(); c != opt.DefaultCompression {
+			return c
+		}
+	}
+	if c := co.Options.GetCompressionPerLevel(level); c != opt.DefaultCompression {
+		return c
+	}
+	return co.GetCompression()
+}
+
+// tableOptions returns the options for new table writers with the given
+// compression.
+func (co *cachedOptions) tableOptions(compression opt.Compression) *opt.Options {
+	if m := co.mutable.Load(); compression == m.compression {
+		return m.table
+	}
+	o := *co.Options
+	o.Compression = compression
+	return &o
 }
 
 func (co *cachedOptions) GetCompactionExpandLimit(level int) int {
@@ -105,3 +208,121 @@ func (co *cachedOptions) GetCompactionTotalSize(level int) int64 {
 	}
 	return co.Options.GetCompactionTotalSize(level)
 }
+
+// SetOptions changes options of an open DB. The options are given by their
+// opt.Options field name, and the values are parsed as decimal integers,
+// or as a compression name, e.g. "default", "none" or "snappy", for
+// Compression.
+//
+// The options that can be changed are:
+//
+//	WriteBuffer
+//		Applies to memdbs created afterwards.
+//	CompactionL0Trigger
+//		Applies to compactions picked afterwards. Lowering it starts a
+//		level-0 compaction right away if the new trigger is reached.
+//	WriteL0SlowdownTrigger, WriteL0PauseTrigger
+//		Applies to writes immediately.
+//	BlockCacheCapacity
+//		Resizes the block cache. It can't be used if the block cache is
+//		disabled.
+//	Compression
+//		Applies to tables created afterwards.
+//
+// All given options are validated before any of them is applied, and they
+// are applied atomically. Changing other options returns an error.
+func (db *DB) SetOptions(options map[string]string) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	co := db.s.o
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	cur := co.mutable.Load()
+	m := *cur
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := options[name]
+		if name == "Compression" {
+			c, ok := opt.CompressionByName(value)
+			if !ok {
+				return fmt.Errorf("leveldb: invalid value %q for option Compression", value)
+			}
+			m.compression = c
+			if m.compression == opt.DefaultCompression {
+				m.compression = opt.DefaultCompressionType
+			}
+			continue
+		}
+
+		var p *int
+		switch name {
+		case "WriteBuffer":
+			p = &m.writeBuffer
+		case "CompactionL0Trigger":
+			p = &m.compactionL0Trigger
+		case "WriteL0SlowdownTrigger":
+			p = &m.writeL0SlowdownTrigger
+		case "WriteL0PauseTrigger":
+			p = &m.writeL0PauseTrigger
+		case "BlockCacheCapacity":
+			if db.s.tops.blockCache == nil || co.Options.GetBlockCacheCapacity() <= 0 {
+				return fmt.Errorf("leveldb: option BlockCacheCapacity can't be changed, the block cache is disabled")
+			}
+			if db.s.tops.blockScope != nil {
+				return fmt.Errorf("leveldb: option BlockCacheCapacity can't be changed, the block cache is shared")
+			}
+			p = &m.blockCacheCapacity
+		default:
+			if _, ok := reflect.TypeOf(opt.Options{}).FieldByName(name); ok {
+				return fmt.Errorf("leveldb: option %s can't be changed at runtime", name)
+			}
+			return fmt.Errorf("leveldb: unknown option %q", name)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("leveldb: invalid value %q for option %s", value, name)
+		}
+		*p = n
+	}
+	if m.writeL0SlowdownTrigger > m.writeL0PauseTrigger {
+		return fmt.Errorf("leveldb: WriteL0SlowdownTrigger (%d) must not be greater than WriteL0PauseTrigger (%d)",
+			m.writeL0SlowdownTrigger, m.writeL0PauseTrigger)
+	}
+	if m.compression != cur.compression {
+		to := *co.Options
+		to.Compression = m.compression
+		m.table = &to
+	}
+	co.mutable.Store(&m)
+
+	if m.blockCacheCapacity != cur.blockCacheCapacity {
+		db.s.tops.blockCache.SetCapacity(m.blockCacheCapacity)
+	}
+	// The level-0 compaction score is computed when a version is created.
+	if m.compactionL0Trigger != cur.compactionL0Trigger {
+		db.compCommitLk.Lock()
+		db.s.rescore()
+		db.compCommitLk.Unlock()
+	}
+	fields := make([]opt.LogField, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, lf(name, options[name]))
+	}
+	db.log(opt.LogInfo, "db@setoptions done", fields...)
+	if !co.GetReadOnly() {
+		if err := db.s.writeOptions(); err != nil {
+			db.log(opt.LogWarn, "db@setoptions writing options failed", lfErr(err))
+		}
+	}
+
+	// The new triggers may allow a paused write or a compaction to proceed.
+	db.compTrigger(db.tcompCmdC)
+	return nil
+}
@@ -0,0 +1,510 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-update/goleveldb/leveldb/comparer"
+	"github.com/golang-update/goleveldb/leveldb/filter"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/storage"
+)
+
+// The OPTIONS file records the options a DB was last opened with, one
+// 'name=value' line per option after a 'version=N' line. It is rewritten
+// on every open and by DB.SetOptions.
+const optionsFileVersion = 1
+
+// Options that are not properties of the DB and thus not recorded.
+var optionsFileSkip = map[string]bool{
+	"ErrorIfExist":           true,
+	"ErrorIfMissing":         true,
+	"ErrorIfOptionsMismatch": true,
+	"ReadOnly":               true,
+}
+
+// OptionsDiff is a difference between the stored options of a DB and the
+// options it is opened with.
+type OptionsDiff struct {
+	Name   string
+	Stored string
+	Given  string
+
+	// Dangerous is true if the difference may cause existing data to be
+	// misread or lost, e.g. a different Comparer.
+	Dangerous bool
+}
+
+func (d OptionsDiff) String() string {
+	kind := "safe"
+	if d.Dangerous {
+		kind = "dangerous"
+	}
+	return fmt.Sprintf("%s: %q -> %q (%s)", d.Name, d.Stored, d.Given, kind)
+}
+
+func filterName(f filter.Filter) string {
+	if f == nil {
+		return ""
+	}
+	return f.Name()
+}
+
+var compressionType = reflect.TypeOf(opt.Compression(0))
+
+// encodeOptionValue encodes the value of the given opt.Options field. It
+// returns false if the field is not recorded.
+func encodeOptionValue(o *opt.Options, name string, v reflect.Value) (string, bool) {
+	switch name {
+	case "Comparer":
+		return o.GetComparer().Name(), true
+	case "Filter":
+		return filterName(o.GetFilter()), true
+	case "AltFilters":
+		names := make([]string, 0, len(o.AltFilters))
+		for _, f := range o.AltFilters {
+			names = append(names, filterName(f))
+		}
+		return strings.Join(names, ","), true
+	}
+	// Compressions are recorded by name.
+	switch v.Type() {
+	case compressionType:
+		return opt.Compression(v.Uint()).String(), true
+	case reflect.SliceOf(compressionType):
+		names := make([]string, v.Len())
+		for i := range names {
+			names[i] = opt.Compression(v.Index(i).Uint()).String()
+		}
+		return strings.Join(names, ","), true
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Float64 {
+			return "", false
+		}
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = strconv.FormatFloat(v.Index(i).Float(), 'g', -1, 64)
+		}
+		return strings.Join(values, ","), true
+	}
+	return "", false
+}
+
+func decodeOptionValue(v reflect.Value, s string) error {
+	switch v.Type() {
+	case compressionType:
+		c, ok := opt.CompressionByName(s)
+		if !ok {
+			return fmt.Errorf("unknown compression %q", s)
+		}
+		v.SetUint(uint64(c))
+		return nil
+	case reflect.SliceOf(compressionType):
+		var cs []opt.Compression
+		if s != "" {
+			for _, name := range strings.Split(s, ",") {
+				c, ok := opt.CompressionByName(name)
+				if !ok {
+					return fmt.Errorf("unknown compression %q", name)
+				}
+				cs = append(cs, c)
+			}
+		}
+		v.Set(reflect.ValueOf(cs))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		x, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(x)
+	case reflect.Int, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint:
+		x, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case reflect.Slice:
+		if s == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		parts := strings.Split(s, ",")
+		sl := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			x, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return err
+			}
+			sl.Index(i).SetFloat(x)
+		}
+		v.Set(sl)
+	}
+	return nil
+}
+
+// encodeOptions returns the recorded options as name/value pairs.
+func encodeOptions(o *opt.Options) map[string]string {
+	m := make(map[string]string)
+	ov := reflect.ValueOf(o).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		if optionsFileSkip[name] {
+			continue
+		}
+		if value, ok := encodeOptionValue(o, name, ov.Field(i)); ok {
+			m[name] = value
+		}
+	}
+	return m
+}
+
+func writeOptionsFile(w io.Writer, o *opt.Options) error {
+	m := encodeOptions(o)
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version=%d\n", optionsFileVersion)
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s=%s\n", name, m[name])
+	}
+	return bw.Flush()
+}
+
+func readOptionsFile(data []byte) (map[string]string, error) {
+	m := make(map[string]string)
+	version := -1
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("leveldb: options file: invalid line %d: %q", i+1, line)
+		}
+		if version < 0 {
+			if name != "version" {
+				return nil, fmt.Errorf("leveldb: options file: missing version")
+			}
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 1 {
+				return nil, fmt.Errorf("leveldb: options file: invalid version %q", value)
+			}
+			if v > optionsFileVersion {
+				return nil, fmt.Errorf("leveldb: options file: unsupported version %d", v)
+			}
+			version = v
+			continue
+		}
+		m[name] = value
+	}
+	if version < 0 {
+		return nil, fmt.Errorf("leveldb: options file: missing version")
+	}
+	return m, nil
+}
+
+func latestOptionsFile(stor storage.Storage) (fd storage.FileDesc, err error) {
+	fds, err := stor.List(storage.TypeOptions)
+	if err != nil {
+		return
+	}
+	if len(fds) == 0 {
+		return fd, ErrNotFound
+	}
+	fd = fds[0]
+	for _, x := range fds[1:] {
+		if x.Num > fd.Num {
+			fd = x
+		}
+	}
+	return
+}
+
+func loadOptionsFile(stor storage.Storage) (map[string]string, error) {
+	fd, err := latestOptionsFile(stor)
+	if err != nil {
+		return nil, err
+	}
+	r, err := stor.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return readOptionsFile(data)
+}
+
+// decodeOptions sets the recorded options other than the Comparer and
+// filters. Unknown options, e.g. written by a newer version, are ignored.
+func decodeOptions(m map[string]string) (*opt.Options, error) {
+	o := &opt.Options{}
+	ov := reflect.ValueOf(o).Elem()
+	for name, value := range m {
+		switch name {
+		case "Comparer", "Filter", "AltFilters":
+			continue
+		}
+		f := ov.FieldByName(name)
+		if !f.IsValid() || optionsFileSkip[name] {
+			continue
+		}
+		if err := decodeOptionValue(f, value); err != nil {
+			return nil, fmt.Errorf("leveldb: options file: invalid value %q for option %s", value, name)
+		}
+	}
+	return o, nil
+}
+
+// LoadOptions reads the options recorded in the latest OPTIONS file of the
+// given storage. It returns ErrNotFound if there is no OPTIONS file.
+//
+// Options that can't be recorded, such as the cachers, Logger and
+// EventListener, are left unset. The Comparer, Filter and AltFilters are
+// recorded by name and resolved against the given comparers and filters;
+// comparer.DefaultComparer is always a candidate. A name that can't be
+// resolved is an error.
+func LoadOptions(stor storage.Storage, comparers []comparer.Comparer, filters []filter.Filter) (*opt.Options, error) {
+	m, err := loadOptionsFile(stor)
+	if err != nil {
+		return nil, err
+	}
+	o, err := decodeOptions(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if name := m["Comparer"]; name != "" {
+		for _, cmp := range append([]comparer.Comparer{comparer.DefaultComparer}, comparers...) {
+			if cmp.Name() == name {
+				o.Comparer = cmp
+				break
+			}
+		}
+		if o.Comparer == nil {
+			return nil, fmt.Errorf("leveldb: unknown comparer %q", name)
+		}
+	}
+	findFilter := func(name string) (filter.Filter, error) {
+		for _, f := range filters {
+			if f.Name() == name {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("leveldb: unknown filter %q", name)
+	}
+	if name := m["Filter"]; name != "" {
+		if o.Filter, err = findFilter(name); err != nil {
+			return nil, err
+		}
+	}
+	if names := m["AltFilters"]; names != "" {
+		for _, name := range strings.Split(names, ",") {
+			f, err := findFilter(name)
+			if err != nil {
+				return nil, err
+			}
+			o.AltFilters = append(o.AltFilters, f)
+		}
+	}
+	return o, nil
+}
+
+// effectiveOption returns the value of the given option as seen by the DB,
+// using the option getter if it takes no argument.
+func effectiveOption(o *opt.Options, m map[string]string, name string) string {
+	if getter := reflect.ValueOf(o).MethodByName("Get" + name); getter.IsValid() && getter.Type().NumIn() == 0 {
+		if v, ok := encodeOptionValue(o, name, getter.Call(nil)[0]); ok {
+			return v
+		}
+	}
+	return m[name]
+}
+
+func isDangerousOptionDiff(name, storedValue string, stored, given *opt.Options) bool {
+	switch name {
+	case "Comparer":
+		return true
+	case "Filter":
+		// New tables would mix filter formats, unless the stored filter is
+		// kept as an alternative filter.
+		if storedValue != "" {
+			for _, f := range given.GetAltFilters() {
+				if filterName(f) == storedValue {
+					return false
+				}
+			}
+		}
+		return true
+	case "Compression", "CompressionPerLevel", "BottommostCompression":
+		// New tables would mix compression formats.
+		return true
+	case "CompactionNumLevels":
+		// Tables beyond the last level would be ignored.
+		return given.GetCompactionNumLevels() < stored.GetCompactionNumLevels()
+	}
+	return false
+}
+
+func diffOptions(storedMap map[string]string, given *opt.Options) ([]OptionsDiff, error) {
+	stored, err := decodeOptions(storedMap)
+	if err != nil {
+		return nil, err
+	}
+	given = dupOptions(given)
+	givenMap := encodeOptions(given)
+
+	names := make([]string, 0, len(givenMap))
+	for name := range givenMap {
+		if _, ok := storedMap[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []OptionsDiff
+	for _, name := range names {
+		var sv, gv string
+		switch name {
+		case "Comparer", "Filter", "AltFilters":
+			sv, gv = storedMap[name], givenMap[name]
+		default:
+			sv, gv = effectiveOption(stored, storedMap, name), effectiveOption(given, givenMap, name)
+		}
+		if sv != gv {
+			diffs = append(diffs, OptionsDiff{
+				Name:      name,
+				Stored:    sv,
+				Given:     gv,
+				Dangerous: isDangerousOptionDiff(name, sv, stored, given),
+			})
+		}
+	}
+	return diffs, nil
+}
+
+// CheckOptions compares the options recorded in the latest OPTIONS file of
+// the given storage with the given options, and returns their differences.
+// It returns ErrNotFound if there is no OPTIONS file.
+//
+// Differences that may cause existing data to be misread or lost, or new
+// tables to be written in a different format than the existing ones, are
+// marked as dangerous, other differences are safe. That is a different
+// Comparer, Filter, unless the stored filter is given as an alternative
+// filter, or compression, and fewer levels.
+func CheckOptions(stor storage.Storage, o *opt.Options) ([]OptionsDiff, error) {
+	m, err := loadOptionsFile(stor)
+	if err != nil {
+		return nil, err
+	}
+	return diffOptions(m, o)
+}
+
+// checkOptions logs the differences between the stored and the given
+// options, and fails if any of them is dangerous and the
+// ErrorIfOptionsMismatch option is set.
+func (s *session) checkOptions(o *opt.Options) error {
+	diffs, err := CheckOptions(s.stor, o)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		// An unreadable OPTIONS file doesn't prevent opening the DB; it is
+		// rewritten on open.
+		s.log(opt.LogWarn, "options@check failed", lfErr(err))
+		return nil
+	}
+	var dangerous []string
+	for _, d := range diffs {
+		level := opt.LogInfo
+		if d.Dangerous {
+			level = opt.LogWarn
+			dangerous = append(dangerous, d.String())
+		}
+		s.log(level, "options@check differs", lf("option", d.Name), lf("stored", d.Stored), lf("given", d.Given), lf("dangerous", d.Dangerous))
+	}
+	if len(dangerous) > 0 && o.GetErrorIfOptionsMismatch() {
+		return fmt.Errorf("%w: %s", ErrIncompatibleOptions, strings.Join(dangerous, "; "))
+	}
+	return nil
+}
+
+// writeOptions writes the current options into a new OPTIONS file, and
+// removes the previous one.
+func (s *session) writeOptions() error {
+	var buf bytes.Buffer
+	if err := writeOptionsFile(&buf, s.o.current()); err != nil {
+		return err
+	}
+
+	fd := storage.FileDesc{Type: storage.TypeOptions, Num: s.allocFileNum()}
+	w, err := s.stor.Create(fd)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	s.omu.Lock()
+	prev := s.optionsFd
+	s.optionsFd = fd
+	s.omu.Unlock()
+	if !prev.Zero() {
+		if _, err := s.removeFile(prev); err != nil {
+			s.log(opt.LogWarn, "options@remove failed", lfFile(prev), lfErr(err))
+		}
+	}
+	s.log(opt.LogDebug, "options@write written", lfFile(fd))
+	return nil
+}
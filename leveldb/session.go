@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/golang-update/goleveldb/leveldb/errors"
 	"github.com/golang-update/goleveldb/leveldb/journal"
@@ -41,18 +42,24 @@ type session struct {
 	stPrevJournalNum int64 // prev journal file number; no longer used; for compatibility with older version of leveldb
 	stTempFileNum    int64
 	stSeqNum         uint64 // last mem compacted seq; need external synchronization
+	stScrubPtr       int64  // last table verified by the scrubber; need external synchronization
 
 	stor     *iStorage
 	storLock storage.Locker
 	o        *cachedOptions
+	logger   opt.Logger
 	icmp     *iComparer
+	noKeyLog bool // user keys are redacted from the log of encrypted storages
 	tops     *tOps
 
 	manifest       *journal.Writer
 	manifestWriter storage.Writer
 	manifestFd     storage.FileDesc
 
-	stCompPtrs  []internalKey // compaction pointers; need external synchronization
+	optionsFd storage.FileDesc // current OPTIONS file; protected by omu
+	omu       sync.Mutex
+
+	stCompPtrs  []internalKey // compaction pointers; protected by cmu
 	stVersion   *version      // current version
 	ntVersionID int64         // next version id to assign
 	refCh       chan *vTask
@@ -63,6 +70,21 @@ type session struct {
 	closeW      sync.WaitGroup
 	vmu         sync.Mutex
 
+	stCompactions []*compaction // in-progress table compactions; protected by cmu
+	cmu           sync.Mutex
+
+	recReport *RecoveryReport // set while recovering by Recover or RecoverDryRun
+
+	stLostRanges []lrRecord // key ranges of quarantined tables; protected by lmu
+	// Quarantined tables whose file isn't moved aside yet, which unlike
+	// stLostRanges outlive ClearDataLoss; protected by lmu.
+	stQuarantined map[int64]struct{}
+	lmu           sync.RWMutex
+
+	rmPaused  int                // file deletion pause count; protected by rmu
+	rmPending []storage.FileDesc // files whose deletion is deferred; protected by rmu
+	rmu       sync.Mutex
+
 	// Testing fields
 	fileRefCh chan chan map[int64]int // channel used to pass current reference stat
 }
@@ -79,6 +101,7 @@ func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
 	s = &session{
 		stor:      newIStorage(stor),
 		storLock:  storLock,
+		noKeyLog:  storage.IsEncrypted(stor),
 		refCh:     make(chan *vTask),
 		relCh:     make(chan *vTask),
 		deltaCh:   make(chan *vDelta),
@@ -92,7 +115,6 @@ func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
 	s.closeW.Add(1)
 	go s.refLoop()
 	s.setVersion(nil, newVersion(s))
-	s.log("log@legend F·NumFile S·FileSize N·Entry C·BadEntry B·BadBlock Ke·KeyError D·DroppedEntry L·Level Q·SeqNum T·TimeElapsed")
 	return
 }
 
@@ -180,7 +202,7 @@ func (s *session) recover() (err error) {
 			if strict || !errors.IsCorrupted(err) {
 				return
 			}
-			s.logf("manifest error: %v (skipped)", errors.SetFd(err, fd))
+			s.log(opt.LogWarn, "manifest@recovery skipped error", lfErr(errors.SetFd(err, fd)))
 		}
 		rec.resetCompPtrs()
 		rec.resetAddedTables()
@@ -219,7 +241,7 @@ func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
 	defer func() {
 		if err != nil {
 			s.abandon <- nv.id
-			s.logf("commit@abandon useless vid D%d", nv.id)
+			s.log(opt.LogDebug, "commit@abandon useless version", lf("version", nv.id))
 		}
 	}()
 
@@ -240,3 +262,13 @@ func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
 
 	return
 }
+
+// Replaces the current version with a copy whose compaction scores are
+// computed with the current options. It must be serialized with commits.
+func (s *session) rescore() {
+	v := s.version()
+	defer v.release()
+	nv := v.spawn(&sessionRecord{}, true)
+	atomic.StorePointer(&nv.cSeek, atomic.LoadPointer(&v.cSeek))
+	s.setVersion(&sessionRecord{}, nv)
+}
@@ -25,18 +25,32 @@ const (
 func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
 	v := s.version()
 	defer v.release()
-	return v.pickMemdbLevel(umin, umax, maxLevel)
-}
 
-func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (int, error) {
-	// Create sorted table.
-	iter := mdb.NewIterator(nil)
-	defer iter.Release()
-	t, n, err := s.tops.createFrom(iter)
-	if err != nil {
-		return 0, err
+	if s.o.GetCompactionDynamicLevelBytes() && v.baseLevel > maxLevel {
+		// Levels above the base level are empty, so memdb may skip
+		// directly to the base level.
+		maxLevel = v.baseLevel
+	}
+
+	// Don't push memdb below the output level of an overlapping in-progress
+	// compaction, otherwise its older entries could end up above ours.
+	s.cmu.Lock()
+	for _, c := range s.stCompactions {
+		if c.sourceLevel < maxLevel && c.overlaps(umin, umax) {
+			maxLevel = c.sourceLevel
+		}
 	}
+	s.cmu.Unlock()
 
+	level := v.pickMemdbLevel(umin, umax, maxLevel)
+	if s.o.GetCompactionDynamicLevelBytes() && level < v.baseLevel {
+		// Keep levels above the base level empty.
+		level = 0
+	}
+	return level
+}
+
+func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (int, error) {
 	// Pick level other than zero can cause compaction issue with large
 	// bulk insert and delete on strictly incrementing key-space. The
 	// problem is that the small deletion markers trapped at lower level,
@@ -45,53 +59,89 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	// higher level, thus maximum possible level is always picked, while
 	// overlapping deletion marker pushed into lower level.
 	// See: https://github.com/golang-update/goleveldb/issues/127.
-	flushLevel := s.pickMemdbLevel(t.imin.ukey(), t.imax.ukey(), maxLevel)
+	//
+	// The level is picked once, before creating the table, so that the
+	// table is created with the compression of the level it is flushed
+	// into.
+	var flushLevel int
+	bounds := mdb.NewIterator(nil)
+	if bounds.First() {
+		umin := append([]byte(nil), internalKey(bounds.Key()).ukey()...)
+		bounds.Last()
+		umax := internalKey(bounds.Key()).ukey()
+		flushLevel = s.pickMemdbLevel(umin, umax, maxLevel)
+	}
+	bounds.Release()
+
+	// Create sorted table.
+	iter := mdb.NewIterator(nil)
+	defer iter.Release()
+	t, n, err := s.tops.createFrom(iter, s.o.levelCompression(flushLevel, false))
+	if err != nil {
+		return 0, err
+	}
 	rec.addTableFile(flushLevel, t)
 
-	s.logf("memdb@flush created L%d@%d N·%d S·%s %q:%q", flushLevel, t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
+	s.log(opt.LogDebug, "memdb@flush created", lfLevel(flushLevel), lfTable(t.fd.Num), lfEntries(n), lfSize(t.size), lfMin(t.imin), lfMax(t.imax))
 	return flushLevel, nil
 }
 
-// Pick a compaction based on current state; need external synchronization.
+// Pick a compaction based on current state. The picked compaction doesn't
+// conflict with any in-progress compaction.
 func (s *session) pickCompaction() *compaction {
 	v := s.version()
 
-	var sourceLevel int
-	var t0 tFiles
-	var typ int
-	if v.cScore >= 1 {
-		sourceLevel = v.cLevel
-		cptr := s.getCompPtr(sourceLevel)
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	for _, sourceLevel := range v.cLevels {
 		tables := v.levels[sourceLevel]
-		if cptr != nil && sourceLevel > 0 {
-			n := len(tables)
-			if i := sort.Search(n, func(i int) bool {
-				return s.icmp.Compare(tables[i].imax, cptr) > 0
-			}); i < n {
-				t0 = append(t0, tables[i])
+		if sourceLevel == 0 {
+			if c := s.tryCompaction(v, sourceLevel, tFiles{tables[0]}, level0Compaction); c != nil {
+				return c
 			}
+			continue
 		}
-		if len(t0) == 0 {
-			t0 = append(t0, tables[0])
+
+		// Start from the compaction pointer and walk the level round-robin,
+		// skipping tables that are busy with other compactions.
+		n, start := len(tables), 0
+		if cptr := s.getCompPtr(sourceLevel); cptr != nil {
+			start = sort.Search(n, func(i int) bool {
+				return s.icmp.Compare(tables[i].imax, cptr) > 0
+			})
 		}
-		if sourceLevel == 0 {
-			typ = level0Compaction
-		} else {
-			typ = nonLevel0Compaction
+		for i := 0; i < n; i++ {
+			t := tables[(start+i)%n]
+			if c := s.tryCompaction(v, sourceLevel, tFiles{t}, nonLevel0Compaction); c != nil {
+				return c
+			}
 		}
-	} else {
-		if p := atomic.LoadPointer(&v.cSeek); p != nil {
-			ts := (*tSet)(p)
-			sourceLevel = ts.level
-			t0 = append(t0, ts.table)
-			typ = seekCompaction
-		} else {
-			v.release()
-			return nil
+	}
+
+	if p := atomic.LoadPointer(&v.cSeek); p != nil {
+		ts := (*tSet)(p)
+		if c := s.tryCompaction(v, ts.level, tFiles{ts.table}, seekCompaction); c != nil {
+			return c
 		}
 	}
 
-	return newCompaction(s, v, sourceLevel, t0, typ)
+	v.release()
+	return nil
+}
+
+// Creates compaction and registers it if it doesn't conflict with any
+// in-progress compaction, otherwise returns nil. The returned compaction
+// takes over the version reference; caller should hold cmu.
+func (s *session) tryCompaction(v *version, sourceLevel int, t0 tFiles, typ int) *compaction {
+	c := newCompaction(s, v, sourceLevel, t0, typ)
+	for _, x := range s.stCompactions {
+		if c.conflicts(x) {
+			return nil
+		}
+	}
+	s.stCompactions = append(s.stCompactions, c)
+	return c
 }
 
 // Create compaction from given level and range; need external synchronization.
@@ -119,7 +169,7 @@ func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit
 		for i, t := range t0 {
 			total += t.size
 			if total >= limit {
-				s.logf("table@compaction limiting F·%d -> F·%d", len(t0), i+1)
+				s.log(opt.LogDebug, "table@compaction limiting", lfFiles(len(t0)), lf("limited_files", i+1))
 				t0 = t0[:i+1]
 				break
 			}
@@ -130,17 +180,70 @@ func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit
 	if sourceLevel != 0 {
 		typ = nonLevel0Compaction
 	}
-	return newCompaction(s, v, sourceLevel, t0, typ)
+	c := newCompaction(s, v, sourceLevel, t0, typ)
+
+	s.cmu.Lock()
+	s.stCompactions = append(s.stCompactions, c)
+	s.cmu.Unlock()
+	return c
+}
+
+// Reports whether the table is an input of an in-progress compaction.
+func (s *session) isCompacting(num int64) bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	for _, c := range s.stCompactions {
+		for _, tables := range c.levels {
+			for _, t := range tables {
+				if t.fd.Num == num {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the given tables are in the current version.
+func (s *session) hasTables(tables []dtRecord) bool {
+	v := s.version()
+	defer v.release()
+next:
+	for _, r := range tables {
+		if r.level < len(v.levels) {
+			for _, t := range v.levels[r.level] {
+				if t.fd.Num == r.num {
+					continue next
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Unregisters in-progress compaction.
+func (s *session) doneCompaction(c *compaction) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	for i, x := range s.stCompactions {
+		if x == c {
+			s.stCompactions = append(s.stCompactions[:i], s.stCompactions[i+1:]...)
+			return
+		}
+	}
 }
 
 func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles, typ int) *compaction {
+	targetLevel := v.targetLevel(sourceLevel)
 	c := &compaction{
 		s:             s,
 		v:             v,
 		typ:           typ,
 		sourceLevel:   sourceLevel,
+		targetLevel:   targetLevel,
 		levels:        [2]tFiles{t0, nil},
-		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
+		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(targetLevel - 1)),
 		tPtrs:         make([]int, len(v.levels)),
 	}
 	c.expand()
@@ -155,6 +258,7 @@ type compaction struct {
 
 	typ           int
 	sourceLevel   int
+	targetLevel   int
 	levels        [2]tFiles
 	maxGPOverlaps int64
 
@@ -163,6 +267,7 @@ type compaction struct {
 	seenKey           bool
 	gpOverlappedBytes int64
 	imin, imax        internalKey
+	amin, amax        internalKey
 	tPtrs             []int
 	released          bool
 
@@ -172,6 +277,17 @@ type compaction struct {
 	snapTPtrs             []int
 }
 
+// bottommost returns whether the compaction output level is the last
+// non-empty level.
+func (c *compaction) bottommost() bool {
+	for level := c.targetLevel + 1; level < len(c.v.levels); level++ {
+		if len(c.v.levels[level]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *compaction) save() {
 	c.snapGPI = c.gpi
 	c.snapSeenKey = c.seenKey
@@ -189,16 +305,17 @@ func (c *compaction) restore() {
 func (c *compaction) release() {
 	if !c.released {
 		c.released = true
+		c.s.doneCompaction(c)
 		c.v.release()
 	}
 }
 
 // Expand compacted tables; need external synchronization.
 func (c *compaction) expand() {
-	limit := int64(c.s.o.GetCompactionExpandLimit(c.sourceLevel))
+	limit := int64(c.s.o.GetCompactionExpandLimit(c.targetLevel - 1))
 	vt0 := c.v.levels[c.sourceLevel]
 	vt1 := tFiles{}
-	if level := c.sourceLevel + 1; level < len(c.v.levels) {
+	if level := c.targetLevel; level < len(c.v.levels) {
 		vt1 = c.v.levels[level]
 	}
 
@@ -218,16 +335,18 @@ func (c *compaction) expand() {
 	amin, amax := append(t0, t1...).getRange(c.s.icmp)
 
 	// See if we can grow the number of inputs in "sourceLevel" without
-	// changing the number of "sourceLevel+1" files we pick up.
+	// changing the number of "targetLevel" files we pick up.
 	if len(t1) > 0 {
 		exp0 := vt0.getOverlaps(nil, c.s.icmp, amin.ukey(), amax.ukey(), c.sourceLevel == 0)
 		if len(exp0) > len(t0) && t1.size()+exp0.size() < limit {
 			xmin, xmax := exp0.getRange(c.s.icmp)
 			exp1 := vt1.getOverlaps(nil, c.s.icmp, xmin.ukey(), xmax.ukey(), false)
 			if len(exp1) == len(t1) {
-				c.s.logf("table@compaction expanding L%d+L%d (F·%d S·%s)+(F·%d S·%s) -> (F·%d S·%s)+(F·%d S·%s)",
-					c.sourceLevel, c.sourceLevel+1, len(t0), shortenb(t0.size()), len(t1), shortenb(t1.size()),
-					len(exp0), shortenb(exp0.size()), len(exp1), shortenb(exp1.size()))
+				c.s.log(opt.LogDebug, "table@compaction expanding",
+					lf("source_level", c.sourceLevel), lf("target_level", c.targetLevel),
+					lf("source_files", len(t0)), lf("source_size", t0.size()), lf("target_files", len(t1)), lf("target_size", t1.size()),
+					lf("expanded_source_files", len(exp0)), lf("expanded_source_size", exp0.size()),
+					lf("expanded_target_files", len(exp1)), lf("expanded_target_size", exp1.size()))
 				imin, imax = xmin, xmax
 				t0, t1 = exp0, exp1
 				amin, amax = append(t0, t1...).getRange(c.s.icmp)
@@ -236,13 +355,34 @@ func (c *compaction) expand() {
 	}
 
 	// Compute the set of grandparent files that overlap this compaction
-	// (parent == sourceLevel+1; grandparent == sourceLevel+2)
-	if level := c.sourceLevel + 2; level < len(c.v.levels) {
+	// (parent == targetLevel; grandparent == targetLevel+1)
+	if level := c.targetLevel + 1; level < len(c.v.levels) {
 		c.gp = c.v.levels[level].getOverlaps(c.gp, c.s.icmp, amin.ukey(), amax.ukey(), false)
 	}
 
 	c.levels[0], c.levels[1] = t0, t1
 	c.imin, c.imax = imin, imax
+	c.amin, c.amax = amin, amax
+}
+
+// Returns true if the key range covered by compaction overlaps with the
+// given user key range.
+func (c *compaction) overlaps(umin, umax []byte) bool {
+	return c.s.icmp.uCompare(umin, c.amax.ukey()) <= 0 && c.s.icmp.uCompare(umax, c.amin.ukey()) >= 0
+}
+
+// Returns true if both compactions can't run at the same time. Level-0
+// compactions are always serialized since level-0 tables may overlap each
+// other, otherwise compactions conflict if the levels they span intersect
+// and their key ranges overlap.
+func (c *compaction) conflicts(x *compaction) bool {
+	if c.sourceLevel == 0 && x.sourceLevel == 0 {
+		return true
+	}
+	if c.sourceLevel > x.targetLevel || x.sourceLevel > c.targetLevel {
+		return false
+	}
+	return c.overlaps(x.amin.ukey(), x.amax.ukey())
 }
 
 // Check whether compaction is trivial.
@@ -251,7 +391,7 @@ func (c *compaction) trivial() bool {
 }
 
 func (c *compaction) baseLevelForKey(ukey []byte) bool {
-	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+	for level := c.targetLevel + 1; level < len(c.v.levels); level++ {
 		tables := c.v.levels[level]
 		for c.tPtrs[level] < len(tables) {
 			t := tables[c.tPtrs[level]]
@@ -315,12 +455,12 @@ func (c *compaction) newIterator() iterator.Iterator {
 		}
 
 		// Level-0 is not sorted and may overlaps each other.
-		if c.sourceLevel+i == 0 {
+		if i == 0 && c.sourceLevel == 0 {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator(t, nil, ro))
+				its = append(its, c.s.tops.newBackgroundIterator(t, nil, ro))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro, true), strict)
 			its = append(its, it)
 		}
 	}
@@ -32,6 +32,10 @@ const (
 	recAddTable    = 7
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
+	recTableChecksum  = 10
+	recScrubPtr       = 11
+	recLostRange      = 12
+	recClearLostRange = 13
 )
 
 type cpRecord struct {
@@ -45,6 +49,29 @@ type atRecord struct {
 	size  int64
 	imin  internalKey
 	imax  internalKey
+
+	// The whole-file checksum, recorded by a recTableChecksum record
+	// following the recAddTable record.
+	checksum    uint32
+	hasChecksum bool
+}
+
+// lrRecord is the key range of a quarantined table. Entries with sequence
+// numbers greater than seq were written after the table was quarantined.
+type lrRecord struct {
+	level int
+	num   int64
+	seq   uint64
+	imin  internalKey
+	imax  internalKey
+}
+
+func (r *lrRecord) err() error {
+	return &ErrDataLoss{
+		Fd:  storage.FileDesc{Type: storage.TypeQuarantine, Num: r.num},
+		Min: append([]byte(nil), r.imin.ukey()...),
+		Max: append([]byte(nil), r.imax.ukey()...),
+	}
 }
 
 type dtRecord struct {
@@ -62,6 +89,8 @@ type sessionRecord struct {
 	compPtrs       []cpRecord
 	addedTables    []atRecord
 	deletedTables  []dtRecord
+	scrubPtr       int64
+	lostRanges     []lrRecord
 
 	scratch [binary.MaxVarintLen64]byte
 	err     error
@@ -96,6 +125,11 @@ func (p *sessionRecord) setSeqNum(num uint64) {
 	p.seqNum = num
 }
 
+func (p *sessionRecord) setScrubPtr(num int64) {
+	p.hasRec |= 1 << recScrubPtr
+	p.scrubPtr = num
+}
+
 func (p *sessionRecord) addCompPtr(level int, ikey internalKey) {
 	p.hasRec |= 1 << recCompPtr
 	p.compPtrs = append(p.compPtrs, cpRecord{level, ikey})
@@ -108,11 +142,26 @@ func (p *sessionRecord) resetCompPtrs() {
 
 func (p *sessionRecord) addTable(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTable
-	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax})
+	p.addedTables = append(p.addedTables, atRecord{level: level, num: num, size: size, imin: imin, imax: imax})
 }
 
 func (p *sessionRecord) addTableFile(level int, t *tFile) {
 	p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	if t.hasChecksum {
+		p.setTableChecksum(t.fd.Num, t.checksum)
+	}
+}
+
+// setTableChecksum sets the checksum of the last added table with the given
+// number.
+func (p *sessionRecord) setTableChecksum(num int64, checksum uint32) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if r := &p.addedTables[i]; r.num == num {
+			r.checksum = checksum
+			r.hasChecksum = true
+			return
+		}
+	}
 }
 
 func (p *sessionRecord) resetAddedTables() {
@@ -130,6 +179,28 @@ func (p *sessionRecord) resetDeletedTables() {
 	p.deletedTables = p.deletedTables[:0]
 }
 
+func (p *sessionRecord) addLostRange(level int, num int64, seq uint64, imin, imax internalKey) {
+	p.hasRec |= 1 << recLostRange
+	p.lostRanges = append(p.lostRanges, lrRecord{level, num, seq, imin, imax})
+}
+
+func (p *sessionRecord) hasLostRange(num int64) bool {
+	for _, r := range p.lostRanges {
+		if r.num == num {
+			return true
+		}
+	}
+	return false
+}
+
+// clearLostRanges drops the lost ranges recorded so far, including those of
+// earlier records.
+func (p *sessionRecord) clearLostRanges() {
+	p.hasRec |= 1 << recClearLostRange
+	p.hasRec &= ^(1 << recLostRange)
+	p.lostRanges = p.lostRanges[:0]
+}
+
 func (p *sessionRecord) putUvarint(w io.Writer, x uint64) {
 	if p.err != nil {
 		return
@@ -174,6 +245,10 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putUvarint(w, recSeqNum)
 		p.putUvarint(w, p.seqNum)
 	}
+	if p.has(recScrubPtr) {
+		p.putUvarint(w, recScrubPtr)
+		p.putVarint(w, p.scrubPtr)
+	}
 	for _, r := range p.compPtrs {
 		p.putUvarint(w, recCompPtr)
 		p.putUvarint(w, uint64(r.level))
@@ -191,6 +266,22 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putVarint(w, r.size)
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
+		if r.hasChecksum {
+			p.putUvarint(w, recTableChecksum)
+			p.putVarint(w, r.num)
+			p.putUvarint(w, uint64(r.checksum))
+		}
+	}
+	if p.has(recClearLostRange) {
+		p.putUvarint(w, recClearLostRange)
+	}
+	for _, r := range p.lostRanges {
+		p.putUvarint(w, recLostRange)
+		p.putUvarint(w, uint64(r.level))
+		p.putVarint(w, r.num)
+		p.putUvarint(w, r.seq)
+		p.putBytes(w, r.imin)
+		p.putBytes(w, r.imax)
 	}
 	return p.err
 }
@@ -295,6 +386,11 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.setSeqNum(x)
 			}
+		case recScrubPtr:
+			x := p.readVarint("scrub-ptr", br)
+			if p.err == nil {
+				p.setScrubPtr(x)
+			}
 		case recCompPtr:
 			level := p.readLevel("comp-ptr.level", br)
 			ikey := p.readBytes("comp-ptr.ikey", br)
@@ -310,6 +406,23 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.addTable(level, num, size, imin, imax)
 			}
+		case recTableChecksum:
+			num := p.readVarint("table-checksum.num", br)
+			checksum := p.readUvarint("table-checksum.checksum", br)
+			if p.err == nil {
+				p.setTableChecksum(num, uint32(checksum))
+			}
+		case recLostRange:
+			level := p.readLevel("lost-range.level", br)
+			num := p.readVarint("lost-range.num", br)
+			seq := p.readUvarint("lost-range.seq", br)
+			imin := p.readBytes("lost-range.imin", br)
+			imax := p.readBytes("lost-range.imax", br)
+			if p.err == nil {
+				p.addLostRange(level, num, seq, imin, imax)
+			}
+		case recClearLostRange:
+			p.clearLostRanges()
 		case recDelTable:
 			level := p.readLevel("del-table.level", br)
 			num := p.readVarint("del-table.num", br)
@@ -49,8 +49,15 @@ func TestSessionRecord_EncodeDecode(t *testing.T) {
 		v.addTable(3, big+300+i, big+400+i,
 			makeInternalKey(nil, []byte("foo"), uint64(big+500+1), keyTypeVal),
 			makeInternalKey(nil, []byte("zoo"), uint64(big+600+1), keyTypeDel))
+		v.setTableChecksum(big+300+i, 0xdeadbeef+uint32(i))
 		v.delTable(4, big+700+i)
 		v.addCompPtr(int(i), makeInternalKey(nil, []byte("x"), uint64(big+900+1), keyTypeVal))
+		v.addLostRange(5, big+800+i, uint64(big+1000),
+			makeInternalKey(nil, []byte("bar"), uint64(big+500+1), keyTypeVal),
+			makeInternalKey(nil, []byte("baz"), uint64(big+600+1), keyTypeVal))
+		if i == 1 {
+			v.clearLostRanges()
+		}
 	}
 
 	v.setComparer("foo")
@@ -58,5 +65,6 @@ func TestSessionRecord_EncodeDecode(t *testing.T) {
 	v.setPrevJournalNum(big + 99)
 	v.setNextFileNum(big + 200)
 	v.setSeqNum(uint64(big + 1000))
+	v.setScrubPtr(big + 300)
 	test()
 }
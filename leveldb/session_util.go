@@ -12,7 +12,9 @@ import (
 	"time"
 
 	"github.com/golang-update/goleveldb/leveldb/journal"
+	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
+	"github.com/golang-update/goleveldb/leveldb/util"
 )
 
 // Logging.
@@ -24,14 +26,35 @@ type dropper struct {
 
 func (d dropper) Drop(err error) {
 	if e, ok := err.(*journal.ErrCorrupted); ok {
-		d.s.logf("journal@drop %s-%d S·%s %q", d.fd.Type, d.fd.Num, shortenb(int64(e.Size)), e.Reason)
+		d.s.log(opt.LogWarn, "journal@drop", lfFile(d.fd), lfSize(int64(e.Size)), lf("reason", e.Reason))
+		d.s.reportSkippedRecord(d.fd, e.Size, err)
 	} else {
-		d.s.logf("journal@drop %s-%d %q", d.fd.Type, d.fd.Num, err)
+		d.s.log(opt.LogWarn, "journal@drop", lfFile(d.fd), lfErr(err))
+		d.s.reportSkippedRecord(d.fd, 0, err)
 	}
 }
 
-func (s *session) log(v ...interface{})                 { s.stor.Log(fmt.Sprint(v...)) }
-func (s *session) logf(format string, v ...interface{}) { s.stor.Log(fmt.Sprintf(format, v...)) }
+// Adds a journal record skipped while recovering to the recovery report,
+// if any.
+func (s *session) reportSkippedRecord(fd storage.FileDesc, size int, err error) {
+	if s.recReport != nil && fd.Type == storage.TypeJournal {
+		s.recReport.SkippedJournalRecords = append(s.recReport.SkippedJournalRecords, SkippedJournalRecord{Fd: fd, Size: size, Err: err})
+	}
+}
+
+func (s *session) log(level opt.LogLevel, msg string, fields ...opt.LogField) {
+	for i, f := range fields {
+		switch v := f.Value.(type) {
+		case error:
+			if s.noKeyLog {
+				fields[i].Value = redactErr(v)
+			}
+		case userLogValue:
+			fields[i].Value = v.format(s.noKeyLog)
+		}
+	}
+	s.logger.Log(level, msg, fields...)
+}
 
 // File utils.
 
@@ -285,7 +308,7 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 			select {
 			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted}:
 			case <-v.s.closeC:
-				s.log("reference loop already exist")
+				s.log(opt.LogError, "reference loop already exist")
 			}
 		}
 		// Release current version.
@@ -336,8 +359,112 @@ func (s *session) reuseFileNum(num int64) {
 	}
 }
 
-// Set compaction ptr at given level; need external synchronization.
+// Removes file from persistent storage. If file deletion is paused the
+// removal is deferred until resumeFileDeletion, in which case deferred is
+// true.
+func (s *session) removeFile(fd storage.FileDesc) (deferred bool, err error) {
+	s.rmu.Lock()
+	if s.rmPaused > 0 {
+		s.rmPending = append(s.rmPending, fd)
+		s.rmu.Unlock()
+		return true, nil
+	}
+	s.rmu.Unlock()
+	return false, s.discardFile(fd)
+}
+
+// Removes the file from persistent storage, or moves it aside if it is a
+// quarantined table.
+func (s *session) discardFile(fd storage.FileDesc) error {
+	if fd.Type == storage.TypeTable && s.isQuarantined(fd.Num) {
+		err := s.stor.Rename(fd, storage.FileDesc{Type: storage.TypeQuarantine, Num: fd.Num})
+		if err == nil {
+			s.lmu.Lock()
+			delete(s.stQuarantined, fd.Num)
+			s.lmu.Unlock()
+			s.log(opt.LogWarn, "table@quarantine moved aside", lfTable(fd.Num))
+		}
+		return err
+	}
+	return s.stor.Remove(fd)
+}
+
+// Pauses file deletion; may be nested.
+func (s *session) pauseFileDeletion() {
+	s.rmu.Lock()
+	s.rmPaused++
+	s.rmu.Unlock()
+}
+
+// Resumes file deletion and removes files whose deletion was deferred, once
+// all pauses are resumed.
+func (s *session) resumeFileDeletion() {
+	s.rmu.Lock()
+	if s.rmPaused--; s.rmPaused > 0 {
+		s.rmu.Unlock()
+		return
+	}
+	pending := s.rmPending
+	s.rmPending = nil
+	s.rmu.Unlock()
+
+	for _, fd := range pending {
+		if err := s.discardFile(fd); err != nil {
+			s.log(opt.LogError, "file@remove failed", lfFile(fd), lfErr(err))
+		} else {
+			s.log(opt.LogDebug, "file@remove removed", lfFile(fd))
+			if fd.Type == storage.TypeTable {
+				s.o.GetEventListener().OnTableFileDeleted(opt.TableFileInfo{Num: fd.Num, Level: -1})
+			}
+		}
+	}
+}
+
+// Reports whether the table is quarantined and its file not moved aside
+// yet.
+func (s *session) isQuarantined(num int64) bool {
+	s.lmu.RLock()
+	defer s.lmu.RUnlock()
+	_, ok := s.stQuarantined[num]
+	return ok
+}
+
+// Returns an ErrDataLoss if the user key is in the range of a quarantined
+// table, unless seq, the sequence number of the entry found for the key,
+// shows it was written after the table was quarantined. Seq is zero if no
+// entry was found.
+func (s *session) checkLostKey(ukey []byte, seq uint64) error {
+	s.lmu.RLock()
+	defer s.lmu.RUnlock()
+	for i := range s.stLostRanges {
+		lr := &s.stLostRanges[i]
+		if seq <= lr.seq && s.icmp.uCompare(ukey, lr.imin.ukey()) >= 0 && s.icmp.uCompare(ukey, lr.imax.ukey()) <= 0 {
+			return lr.err()
+		}
+	}
+	return nil
+}
+
+// Returns an ErrDataLoss if the user key range overlaps the range of a
+// quarantined table. A nil range means the whole key space.
+func (s *session) checkLostRange(r *util.Range) error {
+	s.lmu.RLock()
+	defer s.lmu.RUnlock()
+	for i := range s.stLostRanges {
+		lr := &s.stLostRanges[i]
+		if r == nil ||
+			(r.Start == nil || s.icmp.uCompare(lr.imax.ukey(), r.Start) >= 0) &&
+				(r.Limit == nil || s.icmp.uCompare(lr.imin.ukey(), r.Limit) < 0) {
+			return lr.err()
+		}
+	}
+	return nil
+}
+
+// Set compaction ptr at given level.
 func (s *session) setCompPtr(level int, ik internalKey) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
 	if level >= len(s.stCompPtrs) {
 		newCompPtrs := make([]internalKey, level+1)
 		copy(newCompPtrs, s.stCompPtrs)
@@ -346,7 +473,7 @@ func (s *session) setCompPtr(level int, ik internalKey) {
 	s.stCompPtrs[level] = append(internalKey{}, ik...)
 }
 
-// Get compaction ptr at given level; need external synchronization.
+// Get compaction ptr at given level; caller should hold cmu.
 func (s *session) getCompPtr(level int) internalKey {
 	if level >= len(s.stCompPtrs) {
 		return nil
@@ -370,11 +497,27 @@ func (s *session) fillRecord(r *sessionRecord, snapshot bool) {
 			r.setSeqNum(s.stSeqNum)
 		}
 
+		if !r.has(recScrubPtr) && s.stScrubPtr != 0 {
+			r.setScrubPtr(s.stScrubPtr)
+		}
+
+		if !r.has(recClearLostRange) {
+			s.lmu.RLock()
+			for _, lr := range s.stLostRanges {
+				if !r.hasLostRange(lr.num) {
+					r.addLostRange(lr.level, lr.num, lr.seq, lr.imin, lr.imax)
+				}
+			}
+			s.lmu.RUnlock()
+		}
+
+		s.cmu.Lock()
 		for level, ik := range s.stCompPtrs {
 			if ik != nil {
 				r.addCompPtr(level, ik)
 			}
 		}
+		s.cmu.Unlock()
 
 		r.setComparer(s.icmp.uName())
 	}
@@ -395,6 +538,31 @@ func (s *session) recordCommited(rec *sessionRecord) {
 		s.stSeqNum = rec.seqNum
 	}
 
+	if rec.has(recScrubPtr) {
+		s.stScrubPtr = rec.scrubPtr
+	}
+
+	if rec.has(recClearLostRange) || len(rec.lostRanges) > 0 {
+		s.lmu.Lock()
+		if rec.has(recClearLostRange) {
+			s.stLostRanges = nil
+		}
+	next:
+		for _, r := range rec.lostRanges {
+			for _, lr := range s.stLostRanges {
+				if lr.num == r.num {
+					continue next
+				}
+			}
+			s.stLostRanges = append(s.stLostRanges, r)
+			if s.stQuarantined == nil {
+				s.stQuarantined = make(map[int64]struct{})
+			}
+			s.stQuarantined[r.num] = struct{}{}
+		}
+		s.lmu.Unlock()
+	}
+
 	for _, r := range rec.compPtrs {
 		s.setCompPtr(r.level, r.ikey)
 	}
@@ -0,0 +1,419 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Encrypted file header:
+//
+//	+-----------------+-------------+-------------------+------------+--------------+
+//	| magic (8-bytes) | version (1) | key ID length (1) | key ID (n) | IV (16-byte) |
+//	+-----------------+-------------+-------------------+------------+--------------+
+//
+// The header is followed by the file contents encrypted with AES-CTR, using
+// the key with the given ID and the IV as the initial counter block.
+const (
+	encMagic        = "LDBENC\x00\x01"
+	encVersion      = 1
+	encMaxKeyIDLen  = 255
+	encHeaderMinLen = len(encMagic) + 2 + aes.BlockSize
+)
+
+// ErrKeyNotFound is returned by a KeyProvider if the requested key doesn't
+// exist.
+var ErrKeyNotFound = errors.New("leveldb/storage: encryption key not found")
+
+// KeyProvider provides the keys of an encrypted storage. The keys must be
+// 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+//
+// A KeyProvider must be safe for concurrent use.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key used to encrypt new files. The
+	// ID is recorded in each file, and must not be longer than 255 bytes.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, which is used to decrypt files
+	// encrypted with it. Keys must be kept for as long as files encrypted
+	// with them exist, see Reencrypt.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider with a fixed set of keys.
+type StaticKeyProvider struct {
+	// Keys holds the keys by ID.
+	Keys map[string][]byte
+
+	// Current is the ID of the key used to encrypt new files.
+	Current string
+}
+
+// CurrentKey implements KeyProvider.CurrentKey.
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+// Key implements KeyProvider.Key.
+func (p *StaticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+type encryptedStorage struct {
+	Storage
+	kp KeyProvider
+
+	// Whether files without encryption header are read as plaintext, which
+	// is only used by Reencrypt.
+	allowPlaintext bool
+
+	mu     sync.Mutex
+	blocks map[string]cipher.Block // by key ID
+}
+
+// NewEncryptedStorage returns a storage that transparently encrypts the
+// files of the given storage with AES-CTR, including tables, journals and
+// manifests. Each file has a random IV, which is stored in a file header
+// with the ID of its key, so keys may be rotated by changing the current key
+// of the KeyProvider; existing files are still read with their own key.
+//
+// The CURRENT file and the LOG file of the file-system backed storage are
+// not encrypted. The CURRENT file only names the manifest, and a DB doesn't
+// log user keys to an encrypted storage, see IsEncrypted; the LOG still
+// holds file names, sizes and sequence numbers.
+//
+// Encryption provides confidentiality only, it doesn't detect tampering.
+func NewEncryptedStorage(stor Storage, kp KeyProvider) (Storage, error) {
+	if stor == nil || kp == nil {
+		return nil, errors.New("leveldb/storage: nil storage or key provider")
+	}
+	s := &encryptedStorage{Storage: stor, kp: kp, blocks: make(map[string]cipher.Block)}
+	// Fail early on an invalid current key.
+	if _, _, err := s.currentBlock(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Encrypter is implemented by storages that encrypt the files they store.
+// A storage wrapping another storage should implement it by forwarding to
+// IsEncrypted of the wrapped storage, otherwise the DB logs user keys to it.
+type Encrypter interface {
+	// Encrypted returns whether the files of the storage are encrypted.
+	Encrypted() bool
+}
+
+// IsEncrypted returns whether the given storage implements Encrypter and
+// encrypts its files.
+func IsEncrypted(stor Storage) bool {
+	e, ok := stor.(Encrypter)
+	return ok && e.Encrypted()
+}
+
+// Encrypted implements Encrypter.
+func (s *encryptedStorage) Encrypted() bool { return true }
+
+func (s *encryptedStorage) block(id string, key []byte) (cipher.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if block, ok := s.blocks[id]; ok {
+		return block, nil
+	}
+	if key == nil {
+		var err error
+		if key, err = s.kp.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("leveldb/storage: invalid encryption key %q: %v", id, err)
+	}
+	s.blocks[id] = block
+	return block, nil
+}
+
+func (s *encryptedStorage) currentBlock() (string, cipher.Block, error) {
+	id, key, err := s.kp.CurrentKey()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(id) > encMaxKeyIDLen {
+		return "", nil, fmt.Errorf("leveldb/storage: encryption key ID too long: %q", id)
+	}
+	block, err := s.block(id, key)
+	return id, block, err
+}
+
+// readHeader reads the encryption header of the given file. It returns a
+// nil cipher if the file is plaintext and plaintext is allowed.
+func (s *encryptedStorage) readHeader(fd FileDesc, r io.ReaderAt) (id string, block cipher.Block, iv []byte, size int, err error) {
+	hdr := make([]byte, len(encMagic)+2+encMaxKeyIDLen+aes.BlockSize)
+	n, err := r.ReadAt(hdr, 0)
+	if err != nil && err != io.EOF {
+		return
+	}
+	hdr = hdr[:n]
+	err = nil
+	if !bytes.HasPrefix(hdr, []byte(encMagic)) {
+		if s.allowPlaintext {
+			return
+		}
+		err = &ErrCorrupted{Fd: fd, Err: errors.New("leveldb/storage: missing encryption header")}
+		return
+	}
+	if len(hdr) < encHeaderMinLen || hdr[len(encMagic)] != encVersion {
+		err = &ErrCorrupted{Fd: fd, Err: errors.New("leveldb/storage: invalid encryption header")}
+		return
+	}
+	idLen := int(hdr[len(encMagic)+1])
+	size = encHeaderMinLen + idLen
+	if len(hdr) < size {
+		err = &ErrCorrupted{Fd: fd, Err: errors.New("leveldb/storage: truncated encryption header")}
+		return
+	}
+	id = string(hdr[len(encMagic)+2 : len(encMagic)+2+idLen])
+	iv = hdr[size-aes.BlockSize : size]
+	block, err = s.block(id, nil)
+	return
+}
+
+func (s *encryptedStorage) Open(fd FileDesc) (Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	id, block, iv, size, err := s.readHeader(fd, r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if block == nil {
+		return r, nil
+	}
+	return &encryptedReader{r: r, keyID: id, block: block, iv: iv, off: int64(size)}, nil
+}
+
+func (s *encryptedStorage) Create(fd FileDesc) (Writer, error) {
+	id, block, err := s.currentBlock()
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 0, encHeaderMinLen+len(id))
+	hdr = append(hdr, encMagic...)
+	hdr = append(hdr, encVersion, byte(len(id)))
+	hdr = append(hdr, id...)
+	hdr = append(hdr, iv...)
+	if _, err := w.Write(hdr); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encryptedWriter{w: w, stream: cipher.NewCTR(block, iv)}, nil
+}
+
+// ctrAt returns the counter block for the given offset of the plaintext,
+// and the offset within that block.
+func ctrAt(iv []byte, off int64) ([]byte, int) {
+	ctr := make([]byte, aes.BlockSize)
+	copy(ctr, iv)
+	// Add the block index to the 128-bit big-endian counter.
+	lo := binary.BigEndian.Uint64(ctr[8:])
+	sum := lo + uint64(off)/aes.BlockSize
+	binary.BigEndian.PutUint64(ctr[8:], sum)
+	if sum < lo {
+		binary.BigEndian.PutUint64(ctr[:8], binary.BigEndian.Uint64(ctr[:8])+1)
+	}
+	return ctr, int(off % aes.BlockSize)
+}
+
+type encryptedReader struct {
+	r     Reader
+	keyID string
+	block cipher.Block
+	iv    []byte
+	off   int64 // header size
+
+	mu  sync.Mutex
+	pos int64
+}
+
+func (r *encryptedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("leveldb/storage: negative offset")
+	}
+	n, err := r.r.ReadAt(p, off+r.off)
+	if n > 0 {
+		ctr, skip := ctrAt(r.iv, off)
+		stream := cipher.NewCTR(r.block, ctr)
+		if skip > 0 {
+			var discard [aes.BlockSize]byte
+			stream.XORKeyStream(discard[:skip], discard[:skip])
+		}
+		stream.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *encryptedReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		size, err := r.r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return r.pos, err
+		}
+		pos = size - r.off + offset
+	default:
+		return r.pos, errors.New("leveldb/storage: invalid whence")
+	}
+	if pos < 0 {
+		return r.pos, errors.New("leveldb/storage: negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *encryptedReader) Close() error {
+	return r.r.Close()
+}
+
+type encryptedWriter struct {
+	w      Writer
+	stream cipher.Stream
+	buf    []byte
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	if cap(w.buf) < len(p) {
+		w.buf = make([]byte, len(p))
+	}
+	buf := w.buf[:len(p)]
+	w.stream.XORKeyStream(buf, p)
+	n, err := w.w.Write(buf)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+func (w *encryptedWriter) Sync() error {
+	return w.w.Sync()
+}
+
+func (w *encryptedWriter) Close() error {
+	return w.w.Close()
+}
+
+// Reencrypt re-encrypts the files of the given storage that aren't
+// encrypted with the current key of the given KeyProvider, which must also
+// provide the keys of the existing files. Plaintext files are encrypted, so
+// Reencrypt may be used to encrypt an existing DB.
+//
+// The storage must not be in use by a DB. Each file is rewritten into a
+// temporary file, which is then renamed over the original file.
+func Reencrypt(stor Storage, kp KeyProvider) error {
+	lock, err := stor.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	es, err := NewEncryptedStorage(stor, kp)
+	if err != nil {
+		return err
+	}
+	s := es.(*encryptedStorage)
+	s.allowPlaintext = true
+	currentID, _, err := kp.CurrentKey()
+	if err != nil {
+		return err
+	}
+
+	fds, err := stor.List(TypeAll &^ TypeTemp)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		if err := s.reencryptFile(fd, currentID); err != nil {
+			return fmt.Errorf("leveldb/storage: re-encrypting %s: %w", fd, err)
+		}
+	}
+	return nil
+}
+
+func (s *encryptedStorage) reencryptFile(fd FileDesc, currentID string) error {
+	r, err := s.Open(fd)
+	if err != nil {
+		return err
+	}
+	if er, ok := r.(*encryptedReader); ok && er.keyID == currentID {
+		return r.Close()
+	}
+
+	tmp := FileDesc{Type: TypeTemp, Num: fd.Num}
+	w, err := s.Create(tmp)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	// The file must be closed before it is replaced.
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.Storage.Rename(tmp, fd)
+	}
+	if err != nil {
+		s.Storage.Remove(tmp)
+	}
+	return err
+}
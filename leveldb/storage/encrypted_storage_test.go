@@ -0,0 +1,165 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func newTestKeyProvider() *StaticKeyProvider {
+	return &StaticKeyProvider{
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 16),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+		Current: "k1",
+	}
+}
+
+func writeTestFile(t *testing.T, stor Storage, fd FileDesc, data []byte) {
+	w, err := stor.Create(fd)
+	if err != nil {
+		t.Fatal("Create: ", err)
+	}
+	// Write in uneven chunks to exercise the stream across blocks.
+	for p := data; len(p) > 0; {
+		n := 1 + rand.Intn(100)
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal("Write: ", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+}
+
+func readTestFile(t *testing.T, stor Storage, fd FileDesc) []byte {
+	r, err := stor.Open(fd)
+	if err != nil {
+		t.Fatal("Open: ", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	return data
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	ms := NewMemStorage()
+	kp := newTestKeyProvider()
+	es, err := NewEncryptedStorage(ms, kp)
+	if err != nil {
+		t.Fatal("NewEncryptedStorage: ", err)
+	}
+
+	data := make([]byte, 10000)
+	rand.Read(data)
+	fd := FileDesc{Type: TypeTable, Num: 1}
+	writeTestFile(t, es, fd, data)
+
+	if got := readTestFile(t, es, fd); !bytes.Equal(got, data) {
+		t.Fatal("Read: data mismatch")
+	}
+	raw := readTestFile(t, ms, fd)
+	if bytes.Contains(raw, data[:64]) {
+		t.Fatal("underlying file contains plaintext")
+	}
+
+	r, err := es.Open(fd)
+	if err != nil {
+		t.Fatal("Open: ", err)
+	}
+	for i := 0; i < 100; i++ {
+		off := rand.Intn(len(data))
+		p := make([]byte, rand.Intn(200))
+		n, err := r.ReadAt(p, int64(off))
+		if err != nil && err != io.EOF {
+			t.Fatal("ReadAt: ", err)
+		}
+		if !bytes.Equal(p[:n], data[off:off+n]) {
+			t.Fatalf("ReadAt: data mismatch at offset %d", off)
+		}
+	}
+	if size, err := r.Seek(0, io.SeekEnd); err != nil || size != int64(len(data)) {
+		t.Fatalf("Seek: want=%d got=%d err=%v", len(data), size, err)
+	}
+	if _, err := r.Seek(9990, io.SeekStart); err != nil {
+		t.Fatal("Seek: ", err)
+	}
+	if rest, _ := io.ReadAll(r); !bytes.Equal(rest, data[9990:]) {
+		t.Fatal("Read after Seek: data mismatch")
+	}
+	r.Close()
+
+	// Plaintext files are rejected.
+	writeTestFile(t, ms, FileDesc{Type: TypeTable, Num: 2}, data)
+	if _, err := es.Open(FileDesc{Type: TypeTable, Num: 2}); !isCorrupted(err) {
+		t.Fatalf("Open plaintext: expecting corruption error, got %v", err)
+	}
+
+	// An unknown key can't be read.
+	kp2 := &StaticKeyProvider{Keys: map[string][]byte{"k2": kp.Keys["k2"]}, Current: "k2"}
+	es2, err := NewEncryptedStorage(ms, kp2)
+	if err != nil {
+		t.Fatal("NewEncryptedStorage: ", err)
+	}
+	if _, err := es2.Open(fd); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Open with unknown key: expecting ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestEncryptedStorageReencrypt(t *testing.T) {
+	ms := NewMemStorage()
+	kp := newTestKeyProvider()
+	es, err := NewEncryptedStorage(ms, kp)
+	if err != nil {
+		t.Fatal("NewEncryptedStorage: ", err)
+	}
+
+	files := map[FileDesc][]byte{}
+	for i := 1; i <= 3; i++ {
+		data := make([]byte, 1000*i)
+		rand.Read(data)
+		fd := FileDesc{Type: TypeTable, Num: int64(i)}
+		files[fd] = data
+		writeTestFile(t, es, fd, data)
+	}
+	// A plaintext file is encrypted too.
+	plain := []byte("plaintext journal")
+	files[FileDesc{Type: TypeJournal, Num: 4}] = plain
+	writeTestFile(t, ms, FileDesc{Type: TypeJournal, Num: 4}, plain)
+
+	kp.Current = "k2"
+	if err := Reencrypt(ms, kp); err != nil {
+		t.Fatal("Reencrypt: ", err)
+	}
+
+	// Only the new key is needed afterwards.
+	kp2 := &StaticKeyProvider{Keys: map[string][]byte{"k2": kp.Keys["k2"]}, Current: "k2"}
+	es2, err := NewEncryptedStorage(ms, kp2)
+	if err != nil {
+		t.Fatal("NewEncryptedStorage: ", err)
+	}
+	for fd, data := range files {
+		if got := readTestFile(t, es2, fd); !bytes.Equal(got, data) {
+			t.Fatalf("%s: data mismatch", fd)
+		}
+	}
+	if fds, _ := ms.List(TypeTemp); len(fds) != 0 {
+		t.Fatalf("temporary files left: %v", fds)
+	}
+}
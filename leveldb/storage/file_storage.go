@@ -628,6 +628,10 @@ func fsGenName(fd FileDesc) string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeOptions:
+		return fmt.Sprintf("OPTIONS-%06d", fd.Num)
+	case TypeQuarantine:
+		return fmt.Sprintf("%06d.quarantine", fd.Num)
 	default:
 		panic("invalid file type")
 	}
@@ -657,6 +661,8 @@ func fsParseName(name string) (fd FileDesc, ok bool) {
 			fd.Type = TypeTable
 		case "tmp":
 			fd.Type = TypeTemp
+		case "quarantine":
+			fd.Type = TypeQuarantine
 		default:
 			return
 		}
@@ -667,6 +673,11 @@ func fsParseName(name string) (fd FileDesc, ok bool) {
 		fd.Type = TypeManifest
 		return fd, true
 	}
+	n, _ = fmt.Sscanf(name, "OPTIONS-%d%s", &fd.Num, &tail)
+	if n == 1 {
+		fd.Type = TypeOptions
+		return fd, true
+	}
 	return
 }
 
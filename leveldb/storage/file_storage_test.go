@@ -29,6 +29,8 @@ var cases = []struct {
 	{nil, "MANIFEST-000007", TypeManifest, 7},
 	{nil, "9223372036854775807.log", TypeJournal, 9223372036854775807},
 	{nil, "000100.tmp", TypeTemp, 100},
+	{nil, "OPTIONS-000005", TypeOptions, 5},
+	{nil, "000009.quarantine", TypeQuarantine, 9},
 }
 
 var invalidCases = []string{
@@ -43,6 +45,8 @@ var invalidCases = []string{
 	"MANIFES",
 	"MANIFEST",
 	"MANIFEST-",
+	"OPTIONS",
+	"OPTIONS-",
 	"XMANIFEST-3",
 	"MANIFEST-3x",
 	"LOC",
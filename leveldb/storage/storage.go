@@ -22,8 +22,10 @@ const (
 	TypeJournal
 	TypeTable
 	TypeTemp
+	TypeOptions
+	TypeQuarantine
 
-	TypeAll = TypeManifest | TypeJournal | TypeTable | TypeTemp
+	TypeAll = TypeManifest | TypeJournal | TypeTable | TypeTemp | TypeOptions | TypeQuarantine
 )
 
 func (t FileType) String() string {
@@ -36,6 +38,10 @@ func (t FileType) String() string {
 		return "table"
 	case TypeTemp:
 		return "temp"
+	case TypeOptions:
+		return "options"
+	case TypeQuarantine:
+		return "quarantine"
 	}
 	return fmt.Sprintf("<unknown:%d>", t)
 }
@@ -113,6 +119,10 @@ func (fd FileDesc) String() string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeOptions:
+		return fmt.Sprintf("OPTIONS-%06d", fd.Num)
+	case TypeQuarantine:
+		return fmt.Sprintf("%06d.quarantine", fd.Num)
 	default:
 		return fmt.Sprintf("%#x-%d", fd.Type, fd.Num)
 	}
@@ -130,6 +140,8 @@ func FileDescOk(fd FileDesc) bool {
 	case TypeJournal:
 	case TypeTable:
 	case TypeTemp:
+	case TypeOptions:
+	case TypeQuarantine:
 	default:
 		return false
 	}
@@ -26,6 +26,10 @@ type tFile struct {
 	seekLeft   int32
 	size       int64
 	imin, imax internalKey
+
+	// The whole-file checksum, if known.
+	checksum    uint32
+	hasChecksum bool
 }
 
 // Returns true if given key is after largest key of this table.
@@ -79,7 +83,9 @@ func newTableFile(fd storage.FileDesc, size int64, imin, imax internalKey) *tFil
 }
 
 func tableFileFromRecord(r atRecord) *tFile {
-	return newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	t := newTableFile(storage.FileDesc{Type: storage.TypeTable, Num: r.num}, r.size, r.imin, r.imax)
+	t.checksum, t.hasChecksum = r.checksum, r.hasChecksum
+	return t
 }
 
 // tFiles hold multiple tFile.
@@ -282,7 +288,7 @@ func (tf tFiles) getRange(icmp *iComparer) (imin, imax internalKey) {
 }
 
 // Creates iterator index from tables.
-func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions) iterator.IteratorIndexer {
+func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions, background bool) iterator.IteratorIndexer {
 	if slice != nil {
 		var start, limit int
 		if slice.Start != nil {
@@ -296,11 +302,12 @@ func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range
 		tf = tf[start:limit]
 	}
 	return iterator.NewArrayIndexer(&tFilesArrayIndexer{
-		tFiles: tf,
-		tops:   tops,
-		icmp:   icmp,
-		slice:  slice,
-		ro:     ro,
+		tFiles:     tf,
+		tops:       tops,
+		icmp:       icmp,
+		slice:      slice,
+		ro:         ro,
+		background: background,
 	})
 }
 
@@ -311,6 +318,8 @@ type tFilesArrayIndexer struct {
 	icmp  *iComparer
 	slice *util.Range
 	ro    *opt.ReadOptions
+	// Set for reads done by the DB itself, see tOps.newBackgroundIterator.
+	background bool
 }
 
 func (a *tFilesArrayIndexer) Search(key []byte) int {
@@ -318,10 +327,14 @@ func (a *tFilesArrayIndexer) Search(key []byte) int {
 }
 
 func (a *tFilesArrayIndexer) Get(i int) iterator.Iterator {
+	var slice *util.Range
 	if i == 0 || i == a.Len()-1 {
-		return a.tops.newIterator(a.tFiles[i], a.slice, a.ro)
+		slice = a.slice
+	}
+	if a.background {
+		return a.tops.newBackgroundIterator(a.tFiles[i], slice, a.ro)
 	}
-	return a.tops.newIterator(a.tFiles[i], nil, a.ro)
+	return a.tops.newIterator(a.tFiles[i], slice, a.ro)
 }
 
 // Helper type for sortByKey.
@@ -350,11 +363,18 @@ type tOps struct {
 	evictRemoved bool
 	fileCache    *cache.Cache
 	blockCache   *cache.Cache
+	blockScope   *cache.Scope // Non-nil if the block cache is shared.
 	blockBuffer  *util.BufferPool
+	rowCache     *cache.Cache
+	rowScope     *cache.Scope // Non-nil if the row cache is the shared block cache.
+	readStats    table.Stats
+
+	// Row cache statistics.
+	rowHits, rowMisses uint64
 }
 
-// Creates an empty table and returns table writer.
-func (t *tOps) create(tSize int) (*tWriter, error) {
+// Creates an empty table with the given compression and returns table writer.
+func (t *tOps) create(tSize int, compression opt.Compression) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()}
 	fw, err := t.s.stor.Create(fd)
 	if err != nil {
@@ -364,13 +384,13 @@ func (t *tOps) create(tSize int) (*tWriter, error) {
 		t:  t,
 		fd: fd,
 		w:  fw,
-		tw: table.NewWriter(fw, t.s.o.Options, t.blockBuffer, tSize),
+		tw: table.NewWriter(fw, t.s.o.tableOptions(compression), t.blockBuffer, tSize),
 	}, nil
 }
 
 // Builds table from src iterator.
-func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create(0)
+func (t *tOps) createFrom(src iterator.Iterator, compression opt.Compression) (f *tFile, n int, err error) {
+	w, err := t.create(0, compression)
 	if err != nil {
 		return
 	}
@@ -411,7 +431,7 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 
 		var blockCache *cache.NamespaceGetter
 		if t.blockCache != nil {
-			blockCache = &cache.NamespaceGetter{Cache: t.blockCache, NS: uint64(f.fd.Num)}
+			blockCache = &cache.NamespaceGetter{Cache: t.blockCache, NS: t.blockNS(f.fd.Num)}
 		}
 
 		var tr *table.Reader
@@ -420,6 +440,12 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 			_ = r.Close()
 			return 0, nil
 		}
+		tr.SetStats(&t.readStats)
+		if t.pinned(f) {
+			if err := tr.Pin(); err != nil {
+				t.s.log(opt.LogWarn, "table@pin failed", lfTable(f.fd.Num), lfErr(err))
+			}
+		}
 		return 1, tr
 
 	})
@@ -429,6 +455,54 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 	return
 }
 
+// Returns the block cache namespace of the table.
+func (t *tOps) blockNS(num int64) uint64 {
+	if t.blockScope != nil {
+		return t.blockScope.NS(uint64(num))
+	}
+	return uint64(num)
+}
+
+// Returns the row cache namespace of the table.
+func (t *tOps) rowNS(num int64) uint64 {
+	if t.rowScope != nil {
+		return t.rowScope.NS(uint64(num))
+	}
+	return uint64(num)
+}
+
+// Returns the size of the blocks of this DB in the block cache.
+func (t *tOps) blockCacheSize() int {
+	if t.blockScope != nil {
+		return t.blockScope.Size()
+	}
+	return t.blockCache.Size()
+}
+
+// Returns true if the index and filter blocks of the table should be
+// pinned in the block cache, see opt.Options.BlockCachePinning. It is only
+// called when the table reader is opened; the pinned blocks are handed out
+// without references, so they can't be unpinned before the reader is
+// released.
+func (t *tOps) pinned(f *tFile) bool {
+	if t.blockCache == nil {
+		return false
+	}
+	switch t.s.o.GetBlockCachePinning() {
+	case opt.PinAll:
+		return true
+	case opt.PinL0:
+		v := t.s.version()
+		defer v.release()
+		for _, x := range v.levels[0] {
+			if x.fd.Num == f.fd.Num {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Finds key/value pair whose key is greater than or equal to the
 // given key.
 func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
@@ -450,6 +524,91 @@ func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte,
 	return ch.Value().(*table.Reader).FindKey(key, true, ro)
 }
 
+// A row is the newest entry of a user key in a table, held by the row
+// cache.
+type row struct {
+	ukey  []byte
+	seq   uint64
+	kt    keyType
+	value []byte
+}
+
+// Approximate memory overhead of a row, besides its key and value.
+const rowOverhead = 64
+
+// Hashes the user key into the row cache key, using FNV-1a. Rows of
+// colliding user keys are told apart by their key.
+func rowHash(ukey []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range ukey {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Finds the newest entry of the given user key in the table, consulting
+// the row cache first. It returns ErrNotFound if the table doesn't contain
+// the key. If noValue is true, the value of a row that isn't cached isn't
+// read, and the row isn't cached. The returned row must not be modified.
+func (t *tOps) findRow(f *tFile, ukey []byte, noValue bool, ro *opt.ReadOptions) (*row, error) {
+	hash := rowHash(ukey)
+	if ch := t.rowCache.Get(t.rowNS(f.fd.Num), hash, nil); ch != nil {
+		r := ch.Value().(*row)
+		ch.Release()
+		if bytes.Equal(r.ukey, ukey) {
+			atomic.AddUint64(&t.rowHits, 1)
+			return r, nil
+		}
+	}
+	atomic.AddUint64(&t.rowMisses, 1)
+
+	ch, err := t.open(f)
+	if err != nil {
+		return nil, err
+	}
+	// The row is cached before the table is released, so that it can't
+	// outlive the eviction of the table rows, see remove.
+	defer ch.Release()
+	var (
+		ikey   = makeInternalKey(nil, ukey, keyMaxSeq, keyTypeSeek)
+		rkey   []byte
+		rvalue []byte
+	)
+	if noValue {
+		rkey, err = ch.Value().(*table.Reader).FindKey(ikey, true, ro)
+	} else {
+		rkey, rvalue, err = ch.Value().(*table.Reader).Find(ikey, true, ro)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fukey, fseq, fkt, err := parseInternalKey(rkey)
+	if err != nil {
+		return nil, err
+	}
+	if t.s.icmp.uCompare(ukey, fukey) != 0 {
+		return nil, ErrNotFound
+	}
+	if noValue {
+		return &row{ukey: ukey, seq: fseq, kt: fkt}, nil
+	}
+	r := &row{
+		ukey:  append([]byte(nil), ukey...),
+		seq:   fseq,
+		kt:    fkt,
+		value: append([]byte(nil), rvalue...),
+	}
+	if !ro.GetDontFillCache() {
+		if ch := t.rowCache.Get(t.rowNS(f.fd.Num), hash, func() (int, cache.Value) {
+			return len(r.ukey) + len(r.value) + rowOverhead, r
+		}); ch != nil {
+			ch.Release()
+		}
+	}
+	return r, nil
+}
+
 // Returns approximate offset of the given key.
 func (t *tOps) offsetOf(f *tFile, key []byte) (offset int64, err error) {
 	ch, err := t.open(f)
@@ -471,20 +630,43 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 	return iter
 }
 
+// Like newIterator, for reads done by the DB itself, such as compactions.
+// They are counted as compaction reads in DB.Stats.
+func (t *tOps) newBackgroundIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	ch, err := t.open(f)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := ch.Value().(*table.Reader).NewBackgroundIterator(slice, ro)
+	iter.SetReleaser(ch)
+	return iter
+}
+
 // Removes table from persistent storage. It waits until
 // no one use the the table.
 func (t *tOps) remove(fd storage.FileDesc) {
 	t.fileCache.Delete(0, uint64(fd.Num), func() {
-		if err := t.s.stor.Remove(fd); err != nil {
-			t.s.logf("table@remove removing @%d %q", fd.Num, err)
-		} else {
-			t.s.logf("table@remove removed @%d", fd.Num)
+		deferred, err := t.s.removeFile(fd)
+		switch {
+		case err != nil:
+			t.s.log(opt.LogError, "table@remove failed", lfTable(fd.Num), lfErr(err))
+		case deferred:
+			t.s.log(opt.LogDebug, "table@remove deferred", lfTable(fd.Num))
+		default:
+			t.s.log(opt.LogDebug, "table@remove removed", lfTable(fd.Num))
+			t.s.o.GetEventListener().OnTableFileDeleted(opt.TableFileInfo{Num: fd.Num, Level: -1})
+		}
+		// File numbers may be reused, the rows must not outlive the table.
+		if t.rowCache != nil {
+			t.rowCache.EvictNS(t.rowNS(fd.Num))
 		}
 		if t.evictRemoved && t.blockCache != nil {
-			t.blockCache.EvictNS(uint64(fd.Num))
+			t.blockCache.EvictNS(t.blockNS(fd.Num))
 		}
 		// Try to reuse file num, useful for discarded transaction.
-		t.s.reuseFileNum(fd.Num)
+		if !deferred {
+			t.s.reuseFileNum(fd.Num)
+		}
 	})
 }
 
@@ -492,9 +674,16 @@ func (t *tOps) remove(fd storage.FileDesc) {
 // regadless still used or not.
 func (t *tOps) close() {
 	t.fileCache.Close(true)
-	if t.blockCache != nil {
+	if t.blockScope != nil {
+		t.blockScope.Close()
+	} else if t.blockCache != nil {
 		t.blockCache.Close(false)
 	}
+	if t.rowScope != nil {
+		t.rowScope.Close()
+	} else if t.rowCache != nil {
+		t.rowCache.Close(false)
+	}
 }
 
 // Creates new initialized table ops instance.
@@ -502,18 +691,37 @@ func newTableOps(s *session) *tOps {
 	var (
 		fileCacher  cache.Cacher
 		blockCache  *cache.Cache
+		blockScope  *cache.Scope
 		blockBuffer *util.BufferPool
+		rowCache    *cache.Cache
+		rowScope    *cache.Scope
 	)
 	if s.o.GetOpenFilesCacheCapacity() > 0 {
 		fileCacher = s.o.GetOpenFilesCacher().New(s.o.GetOpenFilesCacheCapacity())
 	}
-	if !s.o.GetDisableBlockCache() {
+	if shared := s.o.GetBlockCache(); shared != nil {
+		blockCache = shared
+		blockScope = shared.NewScope()
+	} else if !s.o.GetDisableBlockCache() {
 		var blockCacher cache.Cacher
 		if s.o.GetBlockCacheCapacity() > 0 {
 			blockCacher = s.o.GetBlockCacher().New(s.o.GetBlockCacheCapacity())
 		}
+		if ratio := s.o.GetBlockCacheHighPriorityRatio(); ratio > 0 {
+			if pc, ok := blockCacher.(cache.PriorityCacher); ok {
+				pc.SetHighPriorityRatio(ratio)
+			}
+		}
 		blockCache = cache.NewCache(blockCacher)
 	}
+	if n := s.o.GetRowCacheCapacity(); n > 0 {
+		if shared := s.o.GetBlockCache(); shared != nil {
+			rowCache = shared
+			rowScope = shared.NewScope()
+		} else {
+			rowCache = cache.NewCache(s.o.GetBlockCacher().New(n))
+		}
+	}
 	if !s.o.GetDisableBufferPool() {
 		blockBuffer = util.NewBufferPool(s.o.GetBlockSize() + 5)
 	}
@@ -523,7 +731,10 @@ func newTableOps(s *session) *tOps {
 		evictRemoved: s.o.GetBlockCacheEvictRemoved(),
 		fileCache:    cache.NewCache(fileCacher),
 		blockCache:   blockCache,
+		blockScope:   blockScope,
 		blockBuffer:  blockBuffer,
+		rowCache:     rowCache,
+		rowScope:     rowScope,
 	}
 }
 
@@ -586,6 +797,7 @@ func (w *tWriter) finish() (f *tFile, err error) {
 		}
 	}
 	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	f.checksum, f.hasChecksum = w.tw.Checksum(), true
 	return
 }
 
@@ -597,9 +809,12 @@ func (w *tWriter) drop() error {
 	w.tw = nil
 	w.first = nil
 	w.last = nil
-	if err := w.t.s.stor.Remove(w.fd); err != nil {
+	deferred, err := w.t.s.removeFile(w.fd)
+	if err != nil {
 		return err
 	}
-	w.t.s.reuseFileNum(w.fd.Num)
+	if !deferred {
+		w.t.s.reuseFileNum(w.fd.Num)
+	}
 	return nil
 }
@@ -0,0 +1,36 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/xxh3"
+
+	"github.com/golang-update/goleveldb/leveldb/opt"
+	"github.com/golang-update/goleveldb/leveldb/util"
+)
+
+// blockChecksum returns the checksum of the given block contents, which
+// includes the block type, written at the given offset of a table of the
+// given format version.
+func blockChecksum(version int, typ opt.ChecksumType, b []byte, offset uint64) uint32 {
+	var checksum uint32
+	switch typ {
+	case opt.XXHash64Checksum:
+		checksum = uint32(xxhash.Sum64(b))
+	case opt.XXH3Checksum:
+		checksum = uint32(xxh3.Hash(b))
+	default:
+		checksum = util.NewCRC(b).Value()
+	}
+	if version >= 1 {
+		// Mix in the block offset, so that a valid block read from the wrong
+		// place doesn't pass verification.
+		checksum += uint32(offset) + uint32(offset>>32)
+	}
+	return checksum
+}
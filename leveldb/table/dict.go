@@ -0,0 +1,108 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/golang-update/goleveldb/leveldb/iterator"
+	"github.com/golang-update/goleveldb/leveldb/opt"
+)
+
+// The compression dictionary of a table is stored uncompressed in a meta
+// block, referenced from the metaindex by "compression.dict." followed by
+// the compression name. It is used to compress the data blocks only.
+const compressionDictPrefix = "compression.dict."
+
+// CompressionDict is a compression dictionary, which is used to compress
+// the data blocks of tables, see Writer.SetCompressionDict.
+//
+// CompressionDict is safe for concurrent use.
+type CompressionDict struct {
+	compression opt.Compression
+	dict        []byte
+	codec       opt.Codec
+}
+
+// NewCompressionDict creates a compression dictionary for the given
+// compression, which codec must implement opt.DictCodec.
+func NewCompressionDict(compression opt.Compression, dict []byte) (*CompressionDict, error) {
+	dc, ok := compression.Codec().(opt.DictCodec)
+	if !ok {
+		return nil, fmt.Errorf("leveldb/table: compression %s doesn't support dictionaries", compression)
+	}
+	codec, err := dc.WithDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressionDict{compression: compression, dict: dict, codec: codec}, nil
+}
+
+// Compression returns the compression of the dictionary.
+func (d *CompressionDict) Compression() opt.Compression {
+	return d.compression
+}
+
+// Bytes returns the dictionary.
+func (d *CompressionDict) Bytes() []byte {
+	return d.dict
+}
+
+// Release releases the codec resources held by the dictionary. The
+// dictionary must not be used afterward.
+func (d *CompressionDict) Release() {
+	if closer, ok := d.codec.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// TrainCompressionDict trains a compression dictionary of at most maxSize
+// bytes for the compression of the given options. It is trained from data
+// blocks built from the key/value pairs of the given iterator, as a Writer
+// would build them, until sampleSize bytes are sampled.
+func TrainCompressionDict(iter iterator.Iterator, o *opt.Options, maxSize, sampleSize int) (*CompressionDict, error) {
+	compression := o.GetCompression()
+	dc, ok := compression.Codec().(opt.DictCodec)
+	if !ok {
+		return nil, fmt.Errorf("leveldb/table: compression %s doesn't support dictionaries", compression)
+	}
+
+	var (
+		samples [][]byte
+		sampled int
+		scratch [30]byte
+		bw      = blockWriter{restartInterval: o.GetBlockRestartInterval(), scratch: scratch[:]}
+	)
+	addSample := func() {
+		bw.finish()
+		samples = append(samples, append([]byte(nil), bw.buf.Bytes()...))
+		sampled += bw.buf.Len()
+		bw.reset()
+		bw.prevKey = bw.prevKey[:0]
+	}
+	for sampled < sampleSize && iter.Next() {
+		if err := bw.append(iter.Key(), iter.Value()); err != nil {
+			return nil, err
+		}
+		if bw.bytesLen() >= o.GetBlockSize() {
+			addSample()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if bw.nEntries > 0 {
+		addSample()
+	}
+
+	dict, err := dc.TrainDict(samples, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewCompressionDict(compression, dict)
+}
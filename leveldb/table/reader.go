@@ -13,8 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
-
-	"github.com/golang-update/snappy"
+	"time"
 
 	"github.com/golang-update/goleveldb/leveldb/cache"
 	"github.com/golang-update/goleveldb/leveldb/comparer"
@@ -459,9 +458,14 @@ type filterBlock struct {
 	oOffset    int
 	baseLg     uint
 	filtersNum int
+	// A filter partition holds a single filter data.
+	partition bool
 }
 
 func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte) bool {
+	if b.partition {
+		return len(b.data) > 0 && filter.Contains(b.data, key)
+	}
 	i := int(offset >> b.baseLg)
 	if i < b.filtersNum {
 		o := b.data[b.oOffset+i*4:]
@@ -483,11 +487,12 @@ func (b *filterBlock) Release() {
 }
 
 type indexIter struct {
-	*blockIter
+	iterator.Iterator
 	tr    *Reader
 	slice *util.Range
 	// Options
 	fillCache bool
+	acct      readAcct
 }
 
 func (i *indexIter) Get() iterator.Iterator {
@@ -500,11 +505,47 @@ func (i *indexIter) Get() iterator.Iterator {
 		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad data block handle"))
 	}
 
+	// Only the first and last data blocks need to be sliced, which can't be
+	// told apart when iterating a partitioned index.
+	var slice *util.Range
+	if i.slice != nil {
+		if bi, ok := i.Iterator.(*blockIter); !ok || bi.isFirst() || bi.isLast() {
+			slice = i.slice
+		}
+	}
+	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache, i.acct)
+}
+
+// partitionIter iterates the top-level index of a partitioned table, and
+// yields the iterators of its index partitions.
+type partitionIter struct {
+	*blockIter
+	tr    *Reader
+	slice *util.Range
+	// Options
+	fillCache bool
+	acct      readAcct
+}
+
+func (i *partitionIter) Get() iterator.Iterator {
+	value := i.Value()
+	if value == nil {
+		return nil
+	}
+	partBH, n := decodeBlockHandle(value)
+	if n == 0 {
+		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad index partition handle"))
+	}
+
 	var slice *util.Range
 	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
 		slice = i.slice
 	}
-	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache)
+	b, rel, err := i.tr.readBlockCached(partBH, IndexBlock, true, i.fillCache, i.acct)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return i.tr.newBlockIter(b, rel, slice, true)
 }
 
 // Reader is a table reader.
@@ -512,19 +553,35 @@ type Reader struct {
 	mu     sync.RWMutex
 	fd     storage.FileDesc
 	reader io.ReaderAt
+	size   int64
 	cache  *cache.NamespaceGetter
 	err    error
 	bpool  *util.BufferPool
+	stats  *Stats
 	// Options
 	o              *opt.Options
 	cmp            comparer.Comparer
 	filter         filter.Filter
 	verifyChecksum bool
+	formatVersion  int
+	checksumType   opt.ChecksumType
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle
+	dictBH                    blockHandle
+	dict                      *CompressionDict
 	indexBlock                *block
 	filterBlock               *filterBlock
+	// Cache handles of the pinned index and filter blocks.
+	indexPin, filterPin util.Releaser
+
+	// Partitioned index and filter, the top-level index is indexBlock.
+	// The partitions BH span all the partitions of their kind.
+	partitioned      bool
+	indexPartsBH     blockHandle
+	filterIndexBH    blockHandle
+	filterPartsBH    blockHandle
+	filterIndexBlock *block
 }
 
 func (r *Reader) blockKind(bh blockHandle) string {
@@ -537,6 +594,20 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.dictBH.offset:
+		if r.dictBH.length > 0 {
+			return "compression-dict-block"
+		}
+	case r.filterIndexBH.offset:
+		if r.filterIndexBH.length > 0 {
+			return "filter-index-block"
+		}
+	}
+	switch {
+	case bh.offset >= r.indexPartsBH.offset && bh.offset < r.indexPartsBH.offset+r.indexPartsBH.length:
+		return "index-partition"
+	case bh.offset >= r.filterPartsBH.offset && bh.offset < r.filterPartsBH.offset+r.filterPartsBH.length:
+		return "filter-partition"
 	}
 	return "data-block"
 }
@@ -559,7 +630,9 @@ func (r *Reader) fixErrCorruptedBH(bh blockHandle, err error) error {
 	return err
 }
 
-func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, error) {
+// Reads the given block and decompresses it. The data blocks are
+// decompressed with the compression dictionary of the table, if any.
+func (r *Reader) readRawBlock(bh blockHandle, dataBlock, verifyChecksum bool) ([]byte, error) {
 	data := r.bpool.Get(int(bh.length + blockTrailerLen))
 	if _, err := r.reader.ReadAt(data, int64(bh.offset)); err != nil && err != io.EOF {
 		return nil, err
@@ -568,39 +641,43 @@ func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, erro
 	if verifyChecksum {
 		n := bh.length + 1
 		checksum0 := binary.LittleEndian.Uint32(data[n:])
-		checksum1 := util.NewCRC(data[:n]).Value()
+		checksum1 := blockChecksum(r.formatVersion, r.checksumType, data[:n], bh.offset)
 		if checksum0 != checksum1 {
 			r.bpool.Put(data)
 			return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("checksum mismatch, want=%#x got=%#x", checksum0, checksum1))
 		}
 	}
 
-	switch data[bh.length] {
-	case blockTypeNoCompression:
-		data = data[:bh.length]
-	case blockTypeSnappyCompression:
-		decLen, err := snappy.DecodedLen(data[:bh.length])
-		if err != nil {
-			r.bpool.Put(data)
-			return nil, r.newErrCorruptedBH(bh, err.Error())
-		}
-		decData := r.bpool.Get(decLen)
-		decData, err = snappy.Decode(decData, data[:bh.length])
+	blockType := data[bh.length]
+	if blockType == blockTypeNoCompression {
+		return data[:bh.length], nil
+	}
+	codec, ok := opt.CompressionCodec(blockType)
+	if dataBlock && r.dict != nil && blockType == r.dict.compression.BlockType() {
+		codec = r.dict.codec
+	}
+	if !ok {
 		r.bpool.Put(data)
-		if err != nil {
-			r.bpool.Put(decData)
-			return nil, r.newErrCorruptedBH(bh, err.Error())
-		}
-		data = decData
-	default:
+		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", blockType))
+	}
+	decLen, err := codec.DecodedLen(data[:bh.length])
+	if err != nil {
 		r.bpool.Put(data)
-		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", data[bh.length]))
+		return nil, r.newErrCorruptedBH(bh, err.Error())
+	}
+	decData := r.bpool.Get(decLen)
+	err = codec.Decode(decData, data[:bh.length])
+	r.bpool.Put(data)
+	if err != nil {
+		r.bpool.Put(decData)
+		return nil, r.newErrCorruptedBH(bh, err.Error())
 	}
+	data = decData
 	return data, nil
 }
 
-func (r *Reader) readBlock(bh blockHandle, verifyChecksum bool) (*block, error) {
-	data, err := r.readRawBlock(bh, verifyChecksum)
+func (r *Reader) readBlock(bh blockHandle, dataBlock, verifyChecksum bool) (*block, error) {
+	data, err := r.readRawBlock(bh, dataBlock, verifyChecksum)
 	if err != nil {
 		return nil, err
 	}
@@ -615,16 +692,75 @@ func (r *Reader) readBlock(bh blockHandle, verifyChecksum bool) (*block, error)
 	return b, nil
 }
 
-func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool) (*block, util.Releaser, error) {
+// readAcct accounts the work done by a 'read operation', in the reader
+// stats and in its PerfContext.
+type readAcct struct {
+	pc *opt.PerfContext
+	// Set for reads done by the DB itself, such as compactions, which
+	// are counted apart from the user reads.
+	background bool
+}
+
+func newReadAcct(ro *opt.ReadOptions) readAcct {
+	return readAcct{pc: ro.GetPerfContext()}
+}
+
+// perfNow returns the current time if pc is not nil.
+func perfNow(pc *opt.PerfContext) time.Time {
+	if pc == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// accountBlockRead accounts a read of the given block started at start.
+func (r *Reader) accountBlockRead(acct readAcct, bh blockHandle, start time.Time) {
+	r.stats.blockRead(bh, acct.background)
+	pc := acct.pc
+	if pc == nil {
+		return
+	}
+	pc.BlocksRead++
+	pc.BlockReadBytes += bh.length + blockTrailerLen
+	pc.BlockReadTime += time.Since(start)
+}
+
+// accountCacheLookup accounts a block cache lookup. Lookups of background
+// reads are only accounted in their PerfContext.
+func (r *Reader) accountCacheLookup(acct readAcct, kind BlockKind, hit bool) {
+	if !acct.background {
+		r.stats.cacheLookup(kind, hit)
+	}
+	pc := acct.pc
+	if pc == nil {
+		return
+	}
+	if hit {
+		pc.BlockCacheHits++
+	} else {
+		pc.BlockCacheMisses++
+	}
+}
+
+// Reads the given data or index block through the block cache.
+func (r *Reader) readBlockCached(bh blockHandle, kind BlockKind, verifyChecksum, fillCache bool, acct readAcct) (*block, util.Releaser, error) {
 	if r.cache != nil {
 		var (
-			err error
-			ch  *cache.Handle
+			err      error
+			ch       *cache.Handle
+			loaded   bool
+			priority = cache.LowPriority
 		)
+		if kind == IndexBlock {
+			priority = cache.HighPriority
+		}
 		if fillCache {
-			ch = r.cache.Get(bh.offset, func() (size int, value cache.Value) {
+			ch = r.cache.GetWithPriority(bh.offset, priority, func() (size int, value cache.Value) {
 				var b *block
-				b, err = r.readBlock(bh, verifyChecksum)
+				start := perfNow(acct.pc)
+				b, err = r.readBlock(bh, kind == DataBlock, verifyChecksum)
+				r.accountBlockRead(acct, bh, start)
+				loaded = true
 				if err != nil {
 					return 0, nil
 				}
@@ -633,6 +769,7 @@ func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool)
 		} else {
 			ch = r.cache.Get(bh.offset, nil)
 		}
+		r.accountCacheLookup(acct, kind, ch != nil && !loaded)
 		if ch != nil {
 			b, ok := ch.Value().(*block)
 			if !ok {
@@ -645,15 +782,20 @@ func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool)
 		}
 	}
 
-	b, err := r.readBlock(bh, verifyChecksum)
+	start := perfNow(acct.pc)
+	b, err := r.readBlock(bh, kind == DataBlock, verifyChecksum)
+	r.accountBlockRead(acct, bh, start)
 	return b, b, err
 }
 
 func (r *Reader) readFilterBlock(bh blockHandle) (*filterBlock, error) {
-	data, err := r.readRawBlock(bh, true)
+	data, err := r.readRawBlock(bh, false, true)
 	if err != nil {
 		return nil, err
 	}
+	if r.blockKind(bh) == "filter-partition" {
+		return &filterBlock{bpool: r.bpool, data: data, partition: true}, nil
+	}
 	n := len(data)
 	if n < 5 {
 		return nil, r.newErrCorruptedBH(bh, "too short")
@@ -673,16 +815,20 @@ func (r *Reader) readFilterBlock(bh blockHandle) (*filterBlock, error) {
 	return b, nil
 }
 
-func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool) (*filterBlock, util.Releaser, error) {
+func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool, acct readAcct) (*filterBlock, util.Releaser, error) {
 	if r.cache != nil {
 		var (
-			err error
-			ch  *cache.Handle
+			err    error
+			ch     *cache.Handle
+			loaded bool
 		)
 		if fillCache {
-			ch = r.cache.Get(bh.offset, func() (size int, value cache.Value) {
+			ch = r.cache.GetWithPriority(bh.offset, cache.HighPriority, func() (size int, value cache.Value) {
 				var b *filterBlock
+				start := perfNow(acct.pc)
 				b, err = r.readFilterBlock(bh)
+				r.accountBlockRead(acct, bh, start)
+				loaded = true
 				if err != nil {
 					return 0, nil
 				}
@@ -691,6 +837,7 @@ func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool) (*filterB
 		} else {
 			ch = r.cache.Get(bh.offset, nil)
 		}
+		r.accountCacheLookup(acct, FilterBlock, ch != nil && !loaded)
 		if ch != nil {
 			b, ok := ch.Value().(*filterBlock)
 			if !ok {
@@ -703,24 +850,64 @@ func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool) (*filterB
 		}
 	}
 
+	start := perfNow(acct.pc)
 	b, err := r.readFilterBlock(bh)
+	r.accountBlockRead(acct, bh, start)
 	return b, b, err
 }
 
-func (r *Reader) getIndexBlock(fillCache bool) (b *block, rel util.Releaser, err error) {
+func (r *Reader) getIndexBlock(fillCache bool, acct readAcct) (b *block, rel util.Releaser, err error) {
 	if r.indexBlock == nil {
-		return r.readBlockCached(r.indexBH, true, fillCache)
+		return r.readBlockCached(r.indexBH, IndexBlock, true, fillCache, acct)
 	}
 	return r.indexBlock, util.NoopReleaser{}, nil
 }
 
-func (r *Reader) getFilterBlock(fillCache bool) (*filterBlock, util.Releaser, error) {
+// Returns the filter block, or the filter partition, of the given key.
+func (r *Reader) getFilterBlock(key []byte, fillCache bool, acct readAcct) (*filterBlock, util.Releaser, error) {
+	if r.partitioned {
+		index := r.newBlockIter(r.filterIndexBlock, nil, nil, true)
+		defer index.Release()
+		if !index.Seek(key) {
+			if err := index.Error(); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, r.newErrCorruptedBH(r.filterIndexBH, "no filter partition")
+		}
+		partBH, n := decodeBlockHandle(index.Value())
+		if n == 0 {
+			return nil, nil, r.newErrCorruptedBH(r.filterIndexBH, "bad filter partition handle")
+		}
+		return r.readFilterBlockCached(partBH, fillCache, acct)
+	}
 	if r.filterBlock == nil {
-		return r.readFilterBlockCached(r.filterBH, fillCache)
+		return r.readFilterBlockCached(r.filterBH, fillCache, acct)
 	}
 	return r.filterBlock, util.NoopReleaser{}, nil
 }
 
+// Returns an iterator of the index entries, which are the data block
+// handles. For a partitioned table, the index partitions are read on
+// demand as the iterator moves, and corrupted ones are skipped unless
+// strict is true.
+func (r *Reader) newIndexIter(slice *util.Range, strict, fillCache bool, acct readAcct) (iterator.Iterator, error) {
+	if r.partitioned {
+		top := &partitionIter{
+			blockIter: r.newBlockIter(r.indexBlock, nil, slice, true),
+			tr:        r,
+			slice:     slice,
+			fillCache: fillCache,
+			acct:      acct,
+		}
+		return iterator.NewIndexedIterator(top, strict), nil
+	}
+	indexBlock, rel, err := r.getIndexBlock(fillCache, acct)
+	if err != nil {
+		return nil, err
+	}
+	return r.newBlockIter(indexBlock, rel, slice, true), nil
+}
+
 func (r *Reader) newBlockIter(b *block, bReleaser util.Releaser, slice *util.Range, inclLimit bool) *blockIter {
 	bi := &blockIter{
 		tr:            r,
@@ -761,15 +948,15 @@ func (r *Reader) newBlockIter(b *block, bReleaser util.Releaser, slice *util.Ran
 	return bi
 }
 
-func (r *Reader) getDataIter(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
-	b, rel, err := r.readBlockCached(dataBH, verifyChecksum, fillCache)
+func (r *Reader) getDataIter(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool, acct readAcct) iterator.Iterator {
+	b, rel, err := r.readBlockCached(dataBH, DataBlock, verifyChecksum, fillCache, acct)
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
 	return r.newBlockIter(b, rel, slice, false)
 }
 
-func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
+func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool, acct readAcct) iterator.Iterator {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -777,7 +964,7 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 		return iterator.NewEmptyIterator(r.err)
 	}
 
-	return r.getDataIter(dataBH, slice, verifyChecksum, fillCache)
+	return r.getDataIter(dataBH, slice, verifyChecksum, fillCache, acct)
 }
 
 // NewIterator creates an iterator from the table.
@@ -796,6 +983,21 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 //
 // Also read Iterator documentation of the leveldb/iterator package.
 func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return r.newIterator(slice, ro, newReadAcct(ro))
+}
+
+// NewBackgroundIterator is like NewIterator, for reads done by the DB
+// itself, such as compactions. Its block reads are counted by
+// Stats.BackgroundReadBytes instead of Stats.ReadBytes, and its cache and
+// filter lookups aren't counted in Stats. Counts in the PerfContext of ro
+// are unaffected.
+func (r *Reader) NewBackgroundIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	acct := newReadAcct(ro)
+	acct.background = true
+	return r.newIterator(slice, ro, acct)
+}
+
+func (r *Reader) newIterator(slice *util.Range, ro *opt.ReadOptions, acct readAcct) iterator.Iterator {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -804,17 +1006,19 @@ func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.It
 	}
 
 	fillCache := !ro.GetDontFillCache()
-	indexBlock, rel, err := r.getIndexBlock(fillCache)
+	strict := opt.GetStrict(r.o, ro, opt.StrictReader)
+	indexIt, err := r.newIndexIter(slice, strict, fillCache, acct)
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
 	index := &indexIter{
-		blockIter: r.newBlockIter(indexBlock, rel, slice, true),
+		Iterator:  indexIt,
 		tr:        r,
 		slice:     slice,
-		fillCache: !ro.GetDontFillCache(),
+		fillCache: fillCache,
+		acct:      acct,
 	}
-	return iterator.NewIndexedIterator(index, opt.GetStrict(r.o, ro, opt.StrictReader))
+	return iterator.NewIndexedIterator(index, strict)
 }
 
 func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bool) (rkey, value []byte, err error) {
@@ -826,13 +1030,11 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 		return
 	}
 
-	indexBlock, rel, err := r.getIndexBlock(true)
+	acct := newReadAcct(ro)
+	index, err := r.newIndexIter(nil, true, true, acct)
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 
 	if !index.Seek(key) {
@@ -850,19 +1052,28 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 
 	// The filter should only used for exact match.
 	if filtered && r.filter != nil {
-		filterBlock, frel, ferr := r.getFilterBlock(true)
+		filterBlock, frel, ferr := r.getFilterBlock(key, true, acct)
 		if ferr == nil {
-			if !filterBlock.contains(r.filter, dataBH.offset, key) {
-				frel.Release()
+			contains := filterBlock.contains(r.filter, dataBH.offset, key)
+			frel.Release()
+			if !acct.background {
+				r.stats.filterCheck(contains)
+			}
+			if pc := acct.pc; pc != nil {
+				pc.FilterChecks++
+				if contains {
+					pc.FilterHits++
+				}
+			}
+			if !contains {
 				return nil, nil, ErrNotFound
 			}
-			frel.Release()
 		} else if !errors.IsCorrupted(ferr) {
 			return nil, nil, ferr
 		}
 	}
 
-	data := r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
+	data := r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache(), acct)
 	if !data.Seek(key) {
 		data.Release()
 		if err = data.Error(); err != nil {
@@ -883,7 +1094,7 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 			return nil, nil, r.err
 		}
 
-		data = r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
+		data = r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache(), acct)
 		if !data.Next() {
 			data.Release()
 			if err = data.Error(); err == nil {
@@ -971,13 +1182,10 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 		return
 	}
 
-	indexBlock, rel, err := r.readBlockCached(r.indexBH, true, true)
+	index, err := r.newIndexIter(nil, true, true, readAcct{})
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 	if index.Seek(key) {
 		dataBH, n := decodeBlockHandle(index.Value())
@@ -995,6 +1203,186 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 	return
 }
 
+// VerifyChecksum reads every block of the table, bypassing the cache, and
+// verifies its checksum. If onRead isn't nil, it is called with the size
+// of each block read, and the verification stops at the first error it
+// returns.
+func (r *Reader) VerifyChecksum(onRead func(n int) error) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	charge := func(bh blockHandle) error {
+		if onRead == nil {
+			return nil
+		}
+		return onRead(int(bh.length + blockTrailerLen))
+	}
+	readBlock := func(bh blockHandle) (*block, error) {
+		b, err := r.readBlock(bh, false, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := charge(bh); err != nil {
+			b.Release()
+			return nil, err
+		}
+		return b, nil
+	}
+	verifyBlock := func(bh blockHandle, dataBlock bool) error {
+		if err := r.verifyBlock(bh, dataBlock); err != nil {
+			return err
+		}
+		return charge(bh)
+	}
+	verifyDataBlock := func(bh blockHandle) error {
+		return verifyBlock(bh, true)
+	}
+	verifyFilterBlock := func(bh blockHandle) error {
+		return verifyBlock(bh, false)
+	}
+
+	indexBlock, err := readBlock(r.indexBH)
+	if err != nil {
+		return err
+	}
+	err = r.verifyIndex(indexBlock, func(bh blockHandle) error {
+		if !r.partitioned {
+			return verifyDataBlock(bh)
+		}
+		partition, err := readBlock(bh)
+		if err != nil {
+			return err
+		}
+		return r.verifyIndex(partition, verifyDataBlock)
+	})
+	if err != nil {
+		return err
+	}
+	if r.filterIndexBH.length > 0 {
+		filterIndex, err := readBlock(r.filterIndexBH)
+		if err != nil {
+			return err
+		}
+		if err := r.verifyIndex(filterIndex, verifyFilterBlock); err != nil {
+			return err
+		}
+	}
+	for _, bh := range []blockHandle{r.metaBH, r.filterBH, r.dictBH} {
+		if bh.length == 0 {
+			continue
+		}
+		if err := verifyBlock(bh, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls verify for each block handle of the given index block, then
+// releases the index block.
+func (r *Reader) verifyIndex(index *block, verify func(bh blockHandle) error) error {
+	iter := r.newBlockIter(index, index, nil, true)
+	defer iter.Release()
+	for iter.Next() {
+		bh, n := decodeBlockHandle(iter.Value())
+		if n == 0 {
+			return r.newErrCorruptedBH(index.bh, "bad block handle")
+		}
+		if err := verify(bh); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (r *Reader) verifyBlock(bh blockHandle, dataBlock bool) error {
+	data, err := r.readRawBlock(bh, dataBlock, true)
+	if err != nil {
+		return err
+	}
+	r.bpool.Put(data)
+	return nil
+}
+
+// FileChecksum reads the whole table file and returns its checksum, as
+// returned by Writer.Checksum. If onRead isn't nil, it is called with the
+// size of each read, and FileChecksum stops at the first error it returns.
+func (r *Reader) FileChecksum(onRead func(n int) error) (uint32, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.reader == nil {
+		return 0, r.err
+	}
+	var (
+		checksum util.CRC
+		buf      = r.bpool.Get(64 << 10)
+	)
+	defer r.bpool.Put(buf)
+	for off := int64(0); off < r.size; {
+		n := len(buf)
+		if rest := r.size - off; rest < int64(n) {
+			n = int(rest)
+		}
+		m, err := r.reader.ReadAt(buf[:n], off)
+		checksum = checksum.Update(buf[:m])
+		off += int64(m)
+		if err == io.EOF && off < r.size {
+			return 0, r.newErrCorrupted(off, r.size-off, "table", "short read")
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if onRead != nil {
+			if err := onRead(m); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return checksum.Value(), nil
+}
+
+// Pin reads the index and filter blocks into the block cache, and keeps
+// them there until the reader is released. It is a no-op if the reader
+// has no block cache, or if the table is partitioned, since the reader
+// keeps these blocks, or the top-level ones, by itself.
+func (r *Reader) Pin() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.cache == nil || r.partitioned {
+		return nil
+	}
+	if r.indexBlock == nil {
+		b, rel, err := r.readBlockCached(r.indexBH, IndexBlock, true, true, readAcct{})
+		if err != nil {
+			return err
+		}
+		r.indexBlock, r.indexPin = b, rel
+	}
+	if r.filter != nil && r.filterBlock == nil {
+		b, rel, err := r.readFilterBlockCached(r.filterBH, true, readAcct{})
+		if err != nil {
+			return err
+		}
+		r.filterBlock, r.filterPin = b, rel
+	}
+	return nil
+}
+
+// SetStats sets the statistics counters updated by the reader. It must be
+// called before the reader is used.
+func (r *Reader) SetStats(stats *Stats) {
+	r.stats = stats
+}
+
 // Release implements util.Releaser.
 // It also close the file if it is an io.Closer.
 func (r *Reader) Release() {
@@ -1004,13 +1392,27 @@ func (r *Reader) Release() {
 	if closer, ok := r.reader.(io.Closer); ok {
 		closer.Close()
 	}
-	if r.indexBlock != nil {
+	if r.indexPin != nil {
+		r.indexPin.Release()
+		r.indexPin = nil
+	} else if r.indexBlock != nil {
 		r.indexBlock.Release()
-		r.indexBlock = nil
 	}
-	if r.filterBlock != nil {
+	r.indexBlock = nil
+	if r.filterPin != nil {
+		r.filterPin.Release()
+		r.filterPin = nil
+	} else if r.filterBlock != nil {
 		r.filterBlock.Release()
-		r.filterBlock = nil
+	}
+	r.filterBlock = nil
+	if r.filterIndexBlock != nil {
+		r.filterIndexBlock.Release()
+		r.filterIndexBlock = nil
+	}
+	if r.dict != nil {
+		r.dict.Release()
+		r.dict = nil
 	}
 	r.reader = nil
 	r.cache = nil
@@ -1018,6 +1420,20 @@ func (r *Reader) Release() {
 	r.err = ErrReaderReleased
 }
 
+// Returns the filter of the given name, either the filter or one of the
+// alternative filters of the options.
+func findFilter(o *opt.Options, name string) filter.Filter {
+	if f := o.GetFilter(); f != nil && f.Name() == name {
+		return f
+	}
+	for _, f := range o.GetAltFilters() {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
 // NewReader creates a new initialized table reader for the file.
 // The fi, cache and bpool is optional and can be nil.
 //
@@ -1030,6 +1446,7 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	r := &Reader{
 		fd:             fd,
 		reader:         f,
+		size:           size,
 		cache:          cache,
 		bpool:          bpool,
 		o:              o,
@@ -1042,33 +1459,66 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 		return r, nil
 	}
 
-	footerPos := size - footerLen
-	var footer [footerLen]byte
-	if _, err := r.reader.ReadAt(footer[:], footerPos); err != nil && err != io.EOF {
+	// Read the longest footer, the magic selects the footer format.
+	footerPos := size - extFooterLen
+	if footerPos < 0 {
+		footerPos = 0
+	}
+	var footerBuf [extFooterLen]byte
+	footer := footerBuf[:size-footerPos]
+	if _, err := r.reader.ReadAt(footer, footerPos); err != nil && err != io.EOF {
 		return nil, err
 	}
-	if string(footer[footerLen-len(magic):footerLen]) != magic {
-		r.err = r.newErrCorrupted(footerPos, footerLen, "table-footer", "bad magic number")
+	switch string(footer[len(footer)-len(magic):]) {
+	case magic:
+		footerPos += int64(len(footer) - footerLen)
+		footer = footer[len(footer)-footerLen:]
+		r.checksumType = opt.CRC32CChecksum
+	case extMagic:
+		if len(footer) < extFooterLen {
+			r.err = r.newErrCorrupted(0, size, "table", "too small")
+			return r, nil
+		}
+		r.formatVersion = int(binary.LittleEndian.Uint32(footer[extFooterLen-len(extMagic)-4:]))
+		if r.formatVersion < 1 || r.formatVersion > opt.MaxTableFormatVersion {
+			r.err = r.newErrCorrupted(footerPos, extFooterLen, "table-footer", fmt.Sprintf("unsupported format version %d", r.formatVersion))
+			return r, nil
+		}
+		r.checksumType = opt.ChecksumType(footer[0])
+		switch r.checksumType {
+		case opt.CRC32CChecksum, opt.XXHash64Checksum, opt.XXH3Checksum:
+		default:
+			r.err = r.newErrCorrupted(footerPos, extFooterLen, "table-footer", fmt.Sprintf("unknown checksum type %d", footer[0]))
+			return r, nil
+		}
+	default:
+		r.err = r.newErrCorrupted(footerPos, int64(len(footer)), "table-footer", "bad magic number")
 		return r, nil
 	}
 
+	// The block handles follow the checksum type in the extended footer.
+	handles := footer
+	if r.formatVersion >= 1 {
+		handles = footer[1:]
+	}
+
 	var n int
 	// Decode the metaindex block handle.
-	r.metaBH, n = decodeBlockHandle(footer[:])
+	r.metaBH, n = decodeBlockHandle(handles)
 	if n == 0 {
-		r.err = r.newErrCorrupted(footerPos, footerLen, "table-footer", "bad metaindex block handle")
+		r.err = r.newErrCorrupted(footerPos, int64(len(footer)), "table-footer", "bad metaindex block handle")
 		return r, nil
 	}
 
 	// Decode the index block handle.
-	r.indexBH, n = decodeBlockHandle(footer[n:])
+	r.indexBH, n = decodeBlockHandle(handles[n:])
 	if n == 0 {
-		r.err = r.newErrCorrupted(footerPos, footerLen, "table-footer", "bad index block handle")
+		r.err = r.newErrCorrupted(footerPos, int64(len(footer)), "table-footer", "bad index block handle")
 		return r, nil
 	}
 
 	// Read metaindex block.
-	metaBlock, err := r.readBlock(r.metaBH, true)
+	metaBlock, err := r.readBlock(r.metaBH, false, true)
 	if err != nil {
 		if errors.IsCorrupted(err) {
 			r.err = err
@@ -1081,40 +1531,125 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	r.dataEnd = int64(r.metaBH.offset)
 
 	// Read metaindex.
+	var dictCompression string
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		if strings.HasPrefix(key, compressionDictPrefix) {
+			if dictBH, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.dictBH = dictBH
+				dictCompression = key[len(compressionDictPrefix):]
+			}
 			continue
 		}
-		fn := key[7:]
-		if f0 := o.GetFilter(); f0 != nil && f0.Name() == fn {
-			r.filter = f0
-		} else {
-			for _, f0 := range o.GetAltFilters() {
-				if f0.Name() == fn {
-					r.filter = f0
-					break
+		if key == partitionedIndexKey {
+			if partsBH, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.partitioned = true
+				r.indexPartsBH = partsBH
+				if int64(partsBH.offset) < r.dataEnd {
+					r.dataEnd = int64(partsBH.offset)
 				}
 			}
+			continue
 		}
 		if r.filter != nil {
+			continue
+		}
+		if strings.HasPrefix(key, partitionedFilterPrefix) {
+			f := findFilter(o, key[len(partitionedFilterPrefix):])
+			if f == nil {
+				continue
+			}
+			value := metaIter.Value()
+			filterIndexBH, n := decodeBlockHandle(value)
+			if n == 0 {
+				continue
+			}
+			filterPartsBH, m := decodeBlockHandle(value[n:])
+			if m == 0 {
+				continue
+			}
+			r.filter = f
+			r.filterIndexBH = filterIndexBH
+			r.filterPartsBH = filterPartsBH
+			// Update data end.
+			if int64(filterPartsBH.offset) < r.dataEnd {
+				r.dataEnd = int64(filterPartsBH.offset)
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, "filter.") {
+			continue
+		}
+		if f := findFilter(o, key[7:]); f != nil {
 			filterBH, n := decodeBlockHandle(metaIter.Value())
 			if n == 0 {
 				continue
 			}
+			r.filter = f
 			r.filterBH = filterBH
 			// Update data end.
 			r.dataEnd = int64(filterBH.offset)
-			break
 		}
 	}
 	metaIter.Release()
 	metaBlock.Release()
 
+	// Load the compression dictionary, it is kept for the lifetime of the
+	// reader.
+	if r.dictBH.length > 0 {
+		if int64(r.dictBH.offset) < r.dataEnd {
+			r.dataEnd = int64(r.dictBH.offset)
+		}
+		data, err := r.readRawBlock(r.dictBH, false, true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				r.err = err
+				return r, nil
+			}
+			return nil, err
+		}
+		dict := append([]byte(nil), data...)
+		r.bpool.Put(data)
+		compression, ok := opt.CompressionByName(dictCompression)
+		if !ok {
+			r.err = r.newErrCorruptedBH(r.dictBH, fmt.Sprintf("unknown compression %q", dictCompression))
+			return r, nil
+		}
+		if r.dict, err = NewCompressionDict(compression, dict); err != nil {
+			r.err = r.newErrCorruptedBH(r.dictBH, err.Error())
+			return r, nil
+		}
+	}
+
+	// The top-level indexes of a partitioned table are small, they are kept
+	// for the lifetime of the reader.
+	if r.partitioned {
+		r.indexBlock, err = r.readBlock(r.indexBH, false, true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				r.err = err
+				return r, nil
+			}
+			return nil, err
+		}
+		if r.filter != nil {
+			r.filterIndexBlock, err = r.readBlock(r.filterIndexBH, false, true)
+			if err != nil {
+				if !errors.IsCorrupted(err) {
+					return nil, err
+				}
+
+				// Don't use filter then.
+				r.filter = nil
+			}
+		}
+		return r, nil
+	}
+
 	// Cache index and filter block locally, since we don't have global cache.
 	if cache == nil {
-		r.indexBlock, err = r.readBlock(r.indexBH, true)
+		r.indexBlock, err = r.readBlock(r.indexBH, false, true)
 		if err != nil {
 			if errors.IsCorrupted(err) {
 				r.err = err
@@ -0,0 +1,125 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"sync/atomic"
+)
+
+// BlockKind is the kind of a table block.
+type BlockKind int
+
+// Block kinds.
+const (
+	DataBlock BlockKind = iota
+	IndexBlock
+	FilterBlock
+	numBlockKinds
+)
+
+func (k BlockKind) String() string {
+	switch k {
+	case DataBlock:
+		return "data"
+	case IndexBlock:
+		return "index"
+	case FilterBlock:
+		return "filter"
+	}
+	return "unknown"
+}
+
+// Stats holds statistics counters shared by table readers, see
+// Reader.SetStats. The counters are updated atomically, so a Stats
+// may be shared by concurrently used readers.
+type Stats struct {
+	cacheHits          [numBlockKinds]uint64
+	cacheMisses        [numBlockKinds]uint64
+	filterUseful       uint64
+	filterFullPositive uint64
+	readBytes          uint64
+	bgReadBytes        uint64
+}
+
+// CacheHits returns the number of block cache hits for the given kind.
+func (s *Stats) CacheHits(kind BlockKind) uint64 {
+	return atomic.LoadUint64(&s.cacheHits[kind])
+}
+
+// CacheMisses returns the number of block cache misses for the given kind.
+func (s *Stats) CacheMisses(kind BlockKind) uint64 {
+	return atomic.LoadUint64(&s.cacheMisses[kind])
+}
+
+// FilterUseful returns the number of filter lookups that avoided reading
+// a data block.
+func (s *Stats) FilterUseful() uint64 {
+	return atomic.LoadUint64(&s.filterUseful)
+}
+
+// FilterFullPositive returns the number of filter lookups that reported
+// the key may be present.
+func (s *Stats) FilterFullPositive() uint64 {
+	return atomic.LoadUint64(&s.filterFullPositive)
+}
+
+// ReadBytes returns the number of bytes read from storage by block reads,
+// except background reads, see Reader.NewBackgroundIterator.
+func (s *Stats) ReadBytes() uint64 {
+	return atomic.LoadUint64(&s.readBytes)
+}
+
+// BackgroundReadBytes returns the number of bytes read from storage by
+// background block reads.
+func (s *Stats) BackgroundReadBytes() uint64 {
+	return atomic.LoadUint64(&s.bgReadBytes)
+}
+
+// Reset resets all counters to zero.
+func (s *Stats) Reset() {
+	for kind := range s.cacheHits {
+		atomic.StoreUint64(&s.cacheHits[kind], 0)
+		atomic.StoreUint64(&s.cacheMisses[kind], 0)
+	}
+	atomic.StoreUint64(&s.filterUseful, 0)
+	atomic.StoreUint64(&s.filterFullPositive, 0)
+	atomic.StoreUint64(&s.readBytes, 0)
+	atomic.StoreUint64(&s.bgReadBytes, 0)
+}
+
+func (s *Stats) cacheLookup(kind BlockKind, hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		atomic.AddUint64(&s.cacheHits[kind], 1)
+	} else {
+		atomic.AddUint64(&s.cacheMisses[kind], 1)
+	}
+}
+
+func (s *Stats) filterCheck(positive bool) {
+	if s == nil {
+		return
+	}
+	if positive {
+		atomic.AddUint64(&s.filterFullPositive, 1)
+	} else {
+		atomic.AddUint64(&s.filterUseful, 1)
+	}
+}
+
+func (s *Stats) blockRead(bh blockHandle, background bool) {
+	if s == nil {
+		return
+	}
+	if background {
+		atomic.AddUint64(&s.bgReadBytes, bh.length+blockTrailerLen)
+	} else {
+		atomic.AddUint64(&s.readBytes, bh.length+blockTrailerLen)
+	}
+}
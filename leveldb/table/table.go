@@ -39,8 +39,16 @@ Table block trailer:
     | compression type (1-byte) | checksum (4-byte) |
     +---------------------------+-------------------+
 
-    The checksum is a CRC-32 computed using Castagnoli's polynomial. Compression
-    type also included in the checksum.
+    The checksum is a masked CRC-32 computed using Castagnoli's polynomial.
+    Compression type also included in the checksum. Tables of format version
+    1 record the checksum algorithm in the footer, which is either CRC-32C,
+    or the low 32-bit of xxHash64 or XXH3, and add the sum of the low and
+    high 32-bit of the block offset to the checksum.
+
+    The compression type selects the codec of the block, see
+    opt.RegisterCompression: 0 is uncompressed, 1 is snappy, 4 is LZ4 and 7 is
+    zstd. LZ4 and zstd compressed data is prefixed with the uvarint-encoded
+    length of the uncompressed data.
 
 Table footer:
 
@@ -52,6 +60,18 @@ Table footer:
 
     The magic are first 64-bit of SHA-1 sum of "http://code.google.com/p/leveldb/".
 
+Table footer (format version 1):
+
+                              +------------------- 40-bytes -------------------+
+                             /                                                  \
+    +------------------------+------------------------+--------------------+------+--------------------+-----------------+
+    | checksum type (1-byte) | metaindex block handle / index block handle / ---- | version (4-bytes) | magic (8-bytes) |
+    +------------------------+------------------------+--------------------+------+--------------------+-----------------+
+
+    The checksum type is 1 for CRC-32C, 2 for xxHash64 and 3 for XXH3. The
+    magic are first 64-bit of SHA-1 sum of
+    "github.com/golang-update/goleveldb/leveldb/table".
+
 NOTE: All fixed-length integer are little-endian.
 */
 
@@ -141,16 +161,48 @@ Filter block trailer:
 NOTE: All fixed-length integer are little-endian.
 */
 
+/*
+Partitioned index and filter:
+
+A table written with opt.Options.IndexPartitionSize splits its index into
+index partitions of about that size. Each index partition is an index block
+of consecutive data blocks, and the index block of the footer becomes a
+top-level index, which maps the last key of each index partition to its
+block handle. If the table has a filter, a filter partition is generated
+for each index partition, with a single filter data of all the keys of its
+data blocks, and a filter index maps the same keys to the filter partitions.
+
+Partitioned table data structure:
+
+    +--------------+-----+--------------+-------------------+--------------+-------------------+-----+-----------------+-----------------+--------+
+    | data block 1 | ... | data block n | filter partitions | filter index | index partition 1 | ... | metaindex block | top-level index | footer |
+    +--------------+-----+--------------+-------------------+--------------+-------------------+-----+-----------------+-----------------+--------+
+
+    The metaindex block maps "index.partitioned" to a block handle spanning
+    all the index partitions, and "partitionedfilter.<name>" to the filter
+    index block handle followed by a block handle spanning all the filter
+    partitions. Partitioned tables have format version 2 or later, so
+    readers without partitioning support reject them: format version 0
+    readers don't recognize the footer magic, and format version 1 readers
+    reject the version.
+*/
+
 const (
 	blockTrailerLen = 5
 	footerLen       = 48
+	extFooterLen    = 1 + 40 + 4 + 8 // footer of format version 1 and later
 
-	magic = "\x57\xfb\x80\x8b\x24\x75\x47\xdb"
+	magic    = "\x57\xfb\x80\x8b\x24\x75\x47\xdb"
+	extMagic = "\x50\x0c\x0a\x77\x4c\x1e\x29\x3f"
 
 	// The block type gives the per-block compression format.
 	// These constants are part of the file format and should not be changed.
 	blockTypeNoCompression     = 0
 	blockTypeSnappyCompression = 1
+
+	// Metaindex keys of partitioned tables.
+	partitionedIndexKey     = "index.partitioned"
+	partitionedFilterPrefix = "partitionedfilter."
 )
 
 type blockHandle struct {
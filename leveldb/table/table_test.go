@@ -8,10 +8,14 @@ package table
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/golang-update/goleveldb/leveldb/cache"
+	"github.com/golang-update/goleveldb/leveldb/filter"
 	"github.com/golang-update/goleveldb/leveldb/iterator"
 	"github.com/golang-update/goleveldb/leveldb/opt"
 	"github.com/golang-update/goleveldb/leveldb/storage"
@@ -121,11 +125,389 @@ var _ = testutil.Defer(func() {
 			testutil.AllKeyValueTesting(nil, Build, nil, nil)
 			Describe("with one key per block", Test(testutil.KeyValue_Generate(nil, 9, 1, 1, 10, 512, 512), func(r *Reader) {
 				It("should have correct blocks number", func() {
-					indexBlock, err := r.readBlock(r.indexBH, true)
+					indexBlock, err := r.readBlock(r.indexBH, false, true)
 					Expect(err).To(BeNil())
 					Expect(indexBlock.restartsLen).Should(Equal(9))
 				})
 			}))
 		})
+
+		Describe("partitioned index test", func() {
+			o := &opt.Options{
+				BlockSize:            128,
+				BlockRestartInterval: 3,
+				IndexPartitionSize:   64,
+				Filter:               filter.NewBloomFilter(10),
+			}
+			build := func(kv testutil.KeyValue, o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				data := build(kv, o)
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tableWrapper{tr}
+			}
+
+			testutil.AllKeyValueTesting(nil, Build, nil, nil)
+
+			kv := testutil.KeyValue{}
+			for i := 0; i < 500; i++ {
+				kv.Put([]byte(fmt.Sprintf("k%04d", i*2)), bytes.Repeat([]byte{'v'}, 20))
+			}
+
+			It("should only read the partitions it needs", func() {
+				data := build(kv, o)
+				c := cache.NewCache(cache.NewLRU(1 << 20))
+				defer c.Close(true)
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, &cache.NamespaceGetter{Cache: c}, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				stats := &Stats{}
+				tr.SetStats(stats)
+				Expect(tr.partitioned).Should(BeTrue())
+				Expect(tr.indexBlock.restartsLen).Should(BeNumerically(">", 10))
+				Expect(tr.VerifyChecksum(nil)).ShouldNot(HaveOccurred())
+
+				v, err := tr.Get([]byte("k0500"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(v).Should(Equal(bytes.Repeat([]byte{'v'}, 20)))
+				Expect(stats.CacheMisses(IndexBlock)).Should(Equal(uint64(1)))
+				Expect(stats.CacheMisses(DataBlock)).Should(Equal(uint64(1)))
+				_, err = tr.Get([]byte("k0500"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(stats.CacheHits(IndexBlock)).Should(Equal(uint64(1)))
+
+				// Absent keys are filtered by their filter partition.
+				var filtered int
+				for i := 0; i < 500; i++ {
+					_, err := tr.FindKey([]byte(fmt.Sprintf("k%04d", i*2+1)), true, nil)
+					if err == ErrNotFound {
+						filtered++
+					}
+				}
+				Expect(filtered).Should(BeNumerically(">", 450))
+				Expect(stats.FilterUseful()).Should(Equal(uint64(filtered)))
+				Expect(stats.CacheMisses(FilterBlock)).Should(Equal(uint64(tr.indexBlock.restartsLen)))
+			})
+
+			It("should be rejected by readers without partitioning support", func() {
+				data := build(kv, &opt.Options{BlockSize: 128, IndexPartitionSize: 64})
+				// Format version 0 readers check the footer magic, format
+				// version 1 readers the version.
+				Expect(string(data[len(data)-len(magic):])).ShouldNot(Equal(magic))
+				Expect(string(data[len(data)-len(extMagic):])).Should(Equal(extMagic))
+				Expect(binary.LittleEndian.Uint32(data[len(data)-len(extMagic)-4:])).Should(Equal(uint32(2)))
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr.formatVersion).Should(Equal(2))
+				Expect(tr.partitioned).Should(BeTrue())
+			})
+
+			It("should approximate offsets and detect corrupted partitions", func() {
+				data := build(kv, &opt.Options{BlockSize: 128, IndexPartitionSize: 64, Compression: opt.NoCompression})
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				offset, err := tr.OffsetOf([]byte("k0000"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(offset).Should(Equal(int64(0)))
+				offset, err = tr.OffsetOf([]byte("k0500"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(offset).Should(BeNumerically("~", len(data)/2, len(data)/4))
+				offset, err = tr.OffsetOf([]byte("z"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(offset).Should(Equal(tr.dataEnd))
+				Expect(tr.dataEnd).Should(Equal(int64(tr.indexPartsBH.offset)))
+
+				// Corrupt the last index partition.
+				data[tr.metaBH.offset-blockTrailerLen-1] ^= 0x80
+				tr, err = NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				_, err = tr.Get([]byte("k0000"), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				_, err = tr.Get([]byte("k0998"), nil)
+				Expect(err).Should(HaveOccurred())
+				Expect(err.Error()).Should(ContainSubstring("index-partition"))
+				Expect(tr.VerifyChecksum(nil)).Should(HaveOccurred())
+			})
+		})
+
+		Describe("compression test", func() {
+			kv := testutil.KeyValue_Generate(nil, 120, 1, 1, 10, 100, 200)
+			for _, c := range []opt.Compression{opt.NoCompression, opt.SnappyCompression, opt.ZstdCompression, opt.LZ4Compression, xorCompression} {
+				for _, level := range []int{0, 3} {
+					c, level := c, level
+					It(fmt.Sprintf("should read back %s level %d compressed blocks", c, level), func() {
+						o := &opt.Options{
+							BlockSize:        512,
+							Compression:      c,
+							CompressionLevel: level,
+						}
+						buf := &bytes.Buffer{}
+						tw := NewWriter(buf, o, nil, 0)
+						kv.Iterate(func(i int, key, value []byte) {
+							Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+						})
+						Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+						// Tables are read regardless of the compression option.
+						tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, nil)
+						Expect(err).ShouldNot(HaveOccurred())
+						indexBlock, err := tr.readBlock(tr.indexBH, false, true)
+						Expect(err).ShouldNot(HaveOccurred())
+						iter := tr.newBlockIter(indexBlock, nil, nil, true)
+						Expect(iter.First()).Should(BeTrue())
+						bh, n := decodeBlockHandle(iter.Value())
+						Expect(n).ShouldNot(BeZero())
+						iter.Release()
+						Expect(buf.Bytes()[bh.offset+bh.length]).Should(Equal(c.BlockType()))
+
+						kv.Iterate(func(i int, key, value []byte) {
+							v, err := tr.Get(key, nil)
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(v).Should(Equal(value), "Value of key %q", key)
+						})
+					})
+				}
+			}
+
+			It("should compress data blocks with a trained dictionary", func() {
+				o := &opt.Options{
+					BlockSize:   1024,
+					Compression: opt.ZstdCompression,
+				}
+				kv := testutil.KeyValue{}
+				for i := 0; i < 500; i++ {
+					kv.Put([]byte(fmt.Sprintf("k%04d", i)), []byte(fmt.Sprintf(
+						`{"id":%d,"name":"user-%d","email":"user%d@example.com","active":%v,"score":%d}`,
+						i, i*7, i*13, i%2 == 0, i*31%1000)))
+				}
+				build := func(dict *CompressionDict) []byte {
+					buf := &bytes.Buffer{}
+					tw := NewWriter(buf, o, nil, 0)
+					if dict != nil {
+						Expect(tw.SetCompressionDict(dict)).ShouldNot(HaveOccurred())
+					}
+					kv.Iterate(func(i int, key, value []byte) {
+						Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+					})
+					Expect(tw.Close()).ShouldNot(HaveOccurred())
+					return buf.Bytes()
+				}
+
+				iter := iterator.NewArrayIterator(kv)
+				dict, err := TrainCompressionDict(iter, o, 4096, 64*1024)
+				iter.Release()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(dict.Bytes())).Should(BeNumerically("<=", 4096))
+				plain, withDict := build(nil), build(dict)
+				Expect(len(withDict) - len(dict.Bytes())).Should(BeNumerically("<", len(plain)))
+
+				// The dictionary is loaded by the reader.
+				tr, err := NewReader(bytes.NewReader(withDict), int64(len(withDict)), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(tr.dict).ShouldNot(BeNil())
+				Expect(tr.dict.Bytes()).Should(Equal(dict.Bytes()))
+				kv.Iterate(func(i int, key, value []byte) {
+					v, err := tr.Get(key, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(v).Should(Equal(value), "Value of key %q", key)
+				})
+
+				// The dictionary codec is closed with the reader.
+				rdict := tr.dict
+				tr.Release()
+				Expect(tr.dict).Should(BeNil())
+				Expect(rdict.codec.Decode(make([]byte, 1), []byte{1, 0})).Should(HaveOccurred())
+
+				// Dictionaries require a dictionary-capable codec.
+				_, err = NewCompressionDict(opt.SnappyCompression, dict.Bytes())
+				Expect(err).Should(HaveOccurred())
+				tw := NewWriter(&bytes.Buffer{}, &opt.Options{Compression: opt.LZ4Compression}, nil, 0)
+				Expect(tw.SetCompressionDict(dict)).Should(HaveOccurred())
+				dict.Release()
+			})
+
+			It("should reject conflicting codec registrations", func() {
+				_, err := opt.RegisterCompression("test.xor2", 0x80, xorCodec{})
+				Expect(err).Should(HaveOccurred())
+				_, err = opt.RegisterCompression("test.xor", 0x81, xorCodec{})
+				Expect(err).Should(HaveOccurred())
+				_, err = opt.RegisterCompression("test.xor3", 2, xorCodec{})
+				Expect(err).Should(HaveOccurred())
+				c, ok := opt.CompressionByName("test.xor")
+				Expect(ok).Should(BeTrue())
+				Expect(c).Should(Equal(xorCompression))
+			})
+
+			It("should reject unknown compression types", func() {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, &opt.Options{Compression: opt.NoCompression}, nil, 0)
+				Expect(tw.Append([]byte("k"), []byte("v"))).ShouldNot(HaveOccurred())
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				data := buf.Bytes()
+				// Patch the type of the data block, and its checksum.
+				n := bytes.Index(data, []byte("v")) + 1 + 4 + 4
+				data[n] = 0xfe
+				binary.LittleEndian.PutUint32(data[n+1:], util.NewCRC(data[:n+1]).Value())
+
+				tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				_, err = tr.Get([]byte("k"), nil)
+				Expect(err).Should(HaveOccurred())
+				Expect(err.Error()).Should(ContainSubstring("unknown compression type 0xfe"))
+			})
+		})
+
+		Describe("checksum test", func() {
+			kv := testutil.KeyValue{}
+			for i := 0; i < 100; i++ {
+				kv.Put([]byte(fmt.Sprintf("k%04d", i)), bytes.Repeat([]byte{'v'}, 100))
+			}
+			build := func(o *opt.Options) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			// Returns the handles of the first two data blocks.
+			dataBlocks := func(tr *Reader) (bh0, bh1 blockHandle) {
+				indexBlock, err := tr.readBlock(tr.indexBH, false, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				iter := tr.newBlockIter(indexBlock, nil, nil, true)
+				defer iter.Release()
+				Expect(iter.Next()).Should(BeTrue())
+				bh0, _ = decodeBlockHandle(iter.Value())
+				Expect(iter.Next()).Should(BeTrue())
+				bh1, _ = decodeBlockHandle(iter.Value())
+				return
+			}
+
+			for _, version := range []int{0, 1, 2} {
+				for _, c := range []opt.ChecksumType{opt.DefaultChecksum, opt.CRC32CChecksum, opt.XXHash64Checksum, opt.XXH3Checksum} {
+					version, c := version, c
+					if version == 0 && c > opt.CRC32CChecksum {
+						continue
+					}
+					It(fmt.Sprintf("should read back format version %d tables with %s checksums", version, c), func() {
+						o := &opt.Options{BlockSize: 512, BlockChecksum: c, TableFormatVersion: version}
+						data := build(o)
+						Expect(len(data) > extFooterLen).Should(BeTrue())
+						if version == 0 {
+							Expect(string(data[len(data)-len(magic):])).Should(Equal(magic))
+						} else {
+							Expect(string(data[len(data)-len(extMagic):])).Should(Equal(extMagic))
+							Expect(opt.ChecksumType(data[len(data)-extFooterLen])).Should(Equal(o.GetBlockChecksum()))
+						}
+
+						tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(tr.formatVersion).Should(Equal(version))
+						Expect(tr.checksumType).Should(Equal(o.GetBlockChecksum()))
+						Expect(tr.VerifyChecksum(nil)).ShouldNot(HaveOccurred())
+						kv.Iterate(func(i int, key, value []byte) {
+							v, err := tr.Get(key, nil)
+							Expect(err).ShouldNot(HaveOccurred())
+							Expect(v).Should(Equal(value), "Value of key %q", key)
+						})
+
+						// Flipping a bit is detected.
+						data[10] ^= 0x80
+						tr, err = NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(tr.VerifyChecksum(nil)).Should(HaveOccurred())
+					})
+				}
+			}
+
+			It("should use the format version required by the options", func() {
+				Expect((&opt.Options{}).GetTableFormatVersion()).Should(Equal(0))
+				Expect((&opt.Options{BlockChecksum: opt.XXH3Checksum}).GetTableFormatVersion()).Should(Equal(1))
+				Expect((&opt.Options{IndexPartitionSize: 64, TableFormatVersion: 1}).GetTableFormatVersion()).Should(Equal(2))
+				Expect((&opt.Options{TableFormatVersion: 9}).GetTableFormatVersion()).Should(Equal(opt.MaxTableFormatVersion))
+			})
+
+			for _, version := range []int{0, 1, 2} {
+				version := version
+				It(fmt.Sprintf("should verify swapped blocks of format version %d tables", version), func() {
+					data := build(&opt.Options{BlockSize: 512, Compression: opt.NoCompression, TableFormatVersion: version})
+					tr, err := NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					bh0, bh1 := dataBlocks(tr)
+					Expect(bh0.length).Should(Equal(bh1.length))
+
+					// Swap the first two data blocks, including their trailers.
+					n := bh0.length + blockTrailerLen
+					b0 := append([]byte(nil), data[bh0.offset:bh0.offset+n]...)
+					copy(data[bh0.offset:], data[bh1.offset:bh1.offset+n])
+					copy(data[bh1.offset:], b0)
+
+					tr, err = NewReader(bytes.NewReader(data), int64(len(data)), storage.FileDesc{}, nil, nil, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					err = tr.VerifyChecksum(nil)
+					if version == 0 {
+						Expect(err).ShouldNot(HaveOccurred())
+					} else {
+						Expect(err).Should(HaveOccurred())
+						Expect(err.Error()).Should(ContainSubstring("checksum mismatch"))
+					}
+				})
+			}
+
+			It("should reject unknown footer versions and checksum types", func() {
+				data := build(&opt.Options{BlockChecksum: opt.XXHash64Checksum})
+				for _, x := range []struct {
+					pos    int
+					value  byte
+					reason string
+				}{
+					{len(data) - extFooterLen, 0x7f, "unknown checksum type"},
+					{len(data) - len(extMagic) - 4, 0x7f, "unsupported format version"},
+				} {
+					corrupted := append([]byte(nil), data...)
+					corrupted[x.pos] = x.value
+					tr, err := NewReader(bytes.NewReader(corrupted), int64(len(corrupted)), storage.FileDesc{}, nil, nil, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = tr.Get([]byte("k0000"), nil)
+					Expect(err).Should(HaveOccurred())
+					Expect(err.Error()).Should(ContainSubstring(x.reason))
+				}
+			})
+		})
 	})
 })
+
+// xorCodec is a user-supplied codec, which doesn't compress.
+type xorCodec struct{}
+
+func (xorCodec) Encode(dst, src []byte, level int) ([]byte, error) {
+	for _, c := range src {
+		dst = append(dst, c^0x5a)
+	}
+	return dst, nil
+}
+
+func (xorCodec) DecodedLen(src []byte) (int, error) { return len(src), nil }
+
+func (xorCodec) Decode(dst, src []byte) error {
+	for i, c := range src {
+		dst[i] = c ^ 0x5a
+	}
+	return nil
+}
+
+var xorCompression = func() opt.Compression {
+	c, err := opt.RegisterCompression("test.xor", 0x80, xorCodec{})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
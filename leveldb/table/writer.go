@@ -12,8 +12,6 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/golang-update/snappy"
-
 	"github.com/golang-update/goleveldb/leveldb/comparer"
 	"github.com/golang-update/goleveldb/leveldb/filter"
 	"github.com/golang-update/goleveldb/leveldb/opt"
@@ -144,22 +142,38 @@ func (w *filterWriter) generate() {
 	}
 }
 
+// An index partition, and the filter data of its keys, buffered until the
+// table is closed.
+type indexPartition struct {
+	key    []byte
+	index  []byte
+	filter []byte
+}
+
 // Writer is a table writer.
 type Writer struct {
 	writer io.Writer
 	err    error
 	// Options
-	cmp         comparer.Comparer
-	filter      filter.Filter
-	compression opt.Compression
-	blockSize   int
+	cmp              comparer.Comparer
+	filter           filter.Filter
+	compression      opt.Compression
+	compressionLevel int
+	dict             *CompressionDict
+	blockSize        int
+	formatVersion    int
+	checksumType     opt.ChecksumType
+	partitionSize    int
 
 	bpool       *util.BufferPool
 	dataBlock   blockWriter
 	indexBlock  blockWriter
 	filterBlock filterWriter
 	pendingBH   blockHandle
+	partitions  []indexPartition
+	nPartBlocks int
 	offset      uint64
+	checksum    util.CRC
 	nEntries    int
 	// Scratch allocated enough for 5 uvarint. Block writer should not use
 	// first 20-bytes since it will be used to encode block handle, which
@@ -169,18 +183,25 @@ type Writer struct {
 	compressionScratch []byte
 }
 
-func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh blockHandle, err error) {
+func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression, dict *CompressionDict) (bh blockHandle, err error) {
+	codec := compression.Codec()
+	if dict != nil {
+		codec = dict.codec
+	}
+
 	// Compress the buffer if necessary.
 	var b []byte
-	if compression == opt.SnappyCompression {
-		// Allocate scratch enough for compression and block trailer.
-		if n := snappy.MaxEncodedLen(buf.Len()) + blockTrailerLen; len(w.compressionScratch) < n {
-			w.compressionScratch = make([]byte, n)
+	if codec != nil {
+		var compressed []byte
+		compressed, err = codec.Encode(w.compressionScratch[:0], buf.Bytes(), w.compressionLevel)
+		if err != nil {
+			return
 		}
-		compressed := snappy.Encode(w.compressionScratch, buf.Bytes())
+		// Allocate block trailer.
 		n := len(compressed)
-		b = compressed[:n+blockTrailerLen]
-		b[n] = blockTypeSnappyCompression
+		b = append(compressed, make([]byte, blockTrailerLen)...)
+		b[n] = compression.BlockType()
+		w.compressionScratch = b
 	} else {
 		tmp := buf.Alloc(blockTrailerLen)
 		tmp[0] = blockTypeNoCompression
@@ -189,7 +210,7 @@ func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh b
 
 	// Calculate the checksum.
 	n := len(b) - 4
-	checksum := util.NewCRC(b[:n]).Value()
+	checksum := blockChecksum(w.formatVersion, w.checksumType, b[:n], w.offset)
 	binary.LittleEndian.PutUint32(b[n:], checksum)
 
 	// Write the buffer to the file.
@@ -197,6 +218,7 @@ func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh b
 	if err != nil {
 		return
 	}
+	w.checksum = w.checksum.Update(b)
 	bh = blockHandle{w.offset, uint64(len(b) - blockTrailerLen)}
 	w.offset += uint64(len(b))
 	return
@@ -226,22 +248,111 @@ func (w *Writer) flushPendingBH(key []byte) error {
 	w.dataBlock.prevKey = w.dataBlock.prevKey[:0]
 	// Clear pending block handle.
 	w.pendingBH = blockHandle{}
+	// Cut the index partition if its size target reached.
+	if w.partitionSize > 0 && w.indexBlock.bytesLen() >= w.partitionSize {
+		return w.cutPartition()
+	}
+	return nil
+}
+
+// Buffers the index block as an index partition, along with the filter data
+// of the keys added since the previous partition.
+func (w *Writer) cutPartition() error {
+	if err := w.indexBlock.finish(); err != nil {
+		return err
+	}
+	p := indexPartition{
+		key:   append([]byte(nil), w.indexBlock.prevKey...),
+		index: append([]byte(nil), w.indexBlock.buf.Bytes()...),
+	}
+	if w.filterBlock.generator != nil {
+		var buf util.Buffer
+		if w.filterBlock.nKeys > 0 {
+			w.filterBlock.generator.Generate(&buf)
+			w.filterBlock.nKeys = 0
+		}
+		p.filter = buf.Bytes()
+	}
+	w.partitions = append(w.partitions, p)
+	w.nPartBlocks += w.indexBlock.nEntries
+	w.indexBlock.reset()
 	return nil
 }
 
+// Writes the buffered filter partitions, the filter index and the index
+// partitions. The top-level index is left in the index block.
+func (w *Writer) writePartitions() (filterIndexBH, filterPartsBH, indexPartsBH blockHandle, err error) {
+	if w.filterBlock.generator != nil {
+		filterIndex := blockWriter{restartInterval: 1, scratch: w.scratch[20:]}
+		start := w.offset
+		for _, p := range w.partitions {
+			bh, err := w.writeBlock(util.NewBuffer(p.filter), opt.NoCompression, nil)
+			if err != nil {
+				return filterIndexBH, filterPartsBH, indexPartsBH, err
+			}
+			n := encodeBlockHandle(w.scratch[:20], bh)
+			if err := filterIndex.append(p.key, w.scratch[:n]); err != nil {
+				return filterIndexBH, filterPartsBH, indexPartsBH, err
+			}
+		}
+		filterPartsBH = blockHandle{start, w.offset - start}
+		if err = filterIndex.finish(); err != nil {
+			return
+		}
+		if filterIndexBH, err = w.writeBlock(&filterIndex.buf, w.compression, nil); err != nil {
+			return
+		}
+	}
+
+	start := w.offset
+	for _, p := range w.partitions {
+		bh, err := w.writeBlock(util.NewBuffer(p.index), w.compression, nil)
+		if err != nil {
+			return filterIndexBH, filterPartsBH, indexPartsBH, err
+		}
+		n := encodeBlockHandle(w.scratch[:20], bh)
+		if err := w.indexBlock.append(p.key, w.scratch[:n]); err != nil {
+			return filterIndexBH, filterPartsBH, indexPartsBH, err
+		}
+	}
+	indexPartsBH = blockHandle{start, w.offset - start}
+	w.partitions = nil
+	return
+}
+
 func (w *Writer) finishBlock() error {
 	if err := w.dataBlock.finish(); err != nil {
 		return err
 	}
-	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	bh, err := w.writeBlock(&w.dataBlock.buf, w.compression, w.dict)
 	if err != nil {
 		return err
 	}
 	w.pendingBH = bh
 	// Reset the data block.
 	w.dataBlock.reset()
-	// Flush the filter block.
-	w.filterBlock.flush(w.offset)
+	// Flush the filter block, partitioned filters are cut along with the
+	// index partitions instead.
+	if w.partitionSize == 0 {
+		w.filterBlock.flush(w.offset)
+	}
+	return nil
+}
+
+// SetCompressionDict sets the dictionary used to compress the data blocks
+// of the table, which is stored in the table. The dictionary must be of the
+// compression of the writer, and must be set before the first Append.
+func (w *Writer) SetCompressionDict(dict *CompressionDict) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.nEntries > 0 {
+		return errors.New("leveldb/table: Writer: compression dictionary set after Append")
+	}
+	if dict.compression != w.compression {
+		return fmt.Errorf("leveldb/table: Writer: compression dictionary of %s used with %s compression", dict.compression, w.compression)
+	}
+	w.dict = dict
 	return nil
 }
 
@@ -281,7 +392,7 @@ func (w *Writer) Append(key, value []byte) error {
 
 // BlocksLen returns number of blocks written so far.
 func (w *Writer) BlocksLen() int {
-	n := w.indexBlock.nEntries
+	n := w.nPartBlocks + w.indexBlock.nEntries
 	if w.pendingBH.length > 0 {
 		// Includes the pending block.
 		n++
@@ -299,6 +410,12 @@ func (w *Writer) BytesLen() int {
 	return int(w.offset)
 }
 
+// Checksum returns the masked CRC-32C checksum of the bytes written so far,
+// which is the checksum of the whole file after Close.
+func (w *Writer) Checksum() uint32 {
+	return w.checksum.Value()
+}
+
 // Close will finalize the table. Calling Append is not possible
 // after Close, but calling BlocksLen, EntriesLen and BytesLen
 // is still possible.
@@ -329,19 +446,49 @@ func (w *Writer) Close() error {
 		return err
 	}
 
-	// Write the filter block.
-	var filterBH blockHandle
-	if err := w.filterBlock.finish(); err != nil {
-		return err
+	// Write the compression dictionary block.
+	var dictBH blockHandle
+	if w.dict != nil {
+		var buf util.Buffer
+		buf.Write(w.dict.dict)
+		dictBH, w.err = w.writeBlock(&buf, opt.NoCompression, nil)
+		if w.err != nil {
+			return w.err
+		}
 	}
-	if buf := &w.filterBlock.buf; buf.Len() > 0 {
-		filterBH, w.err = w.writeBlock(buf, opt.NoCompression)
+
+	// Write the filter block, or the partitions.
+	var filterBH, filterIndexBH, filterPartsBH, indexPartsBH blockHandle
+	if w.partitionSize > 0 {
+		if w.indexBlock.nEntries > 0 {
+			if err := w.cutPartition(); err != nil {
+				return err
+			}
+		}
+		filterIndexBH, filterPartsBH, indexPartsBH, w.err = w.writePartitions()
 		if w.err != nil {
 			return w.err
 		}
+	} else {
+		if err := w.filterBlock.finish(); err != nil {
+			return err
+		}
+		if buf := &w.filterBlock.buf; buf.Len() > 0 {
+			filterBH, w.err = w.writeBlock(buf, opt.NoCompression, nil)
+			if w.err != nil {
+				return w.err
+			}
+		}
 	}
 
 	// Write the metaindex block.
+	if dictBH.length > 0 {
+		key := []byte(compressionDictPrefix + w.compression.String())
+		n := encodeBlockHandle(w.scratch[:20], dictBH)
+		if err := w.dataBlock.append(key, w.scratch[:n]); err != nil {
+			return err
+		}
+	}
 	if filterBH.length > 0 {
 		key := []byte("filter." + w.filter.Name())
 		n := encodeBlockHandle(w.scratch[:20], filterBH)
@@ -349,10 +496,25 @@ func (w *Writer) Close() error {
 			return err
 		}
 	}
+	if indexPartsBH.length > 0 {
+		n := encodeBlockHandle(w.scratch[:20], indexPartsBH)
+		if err := w.dataBlock.append([]byte(partitionedIndexKey), w.scratch[:n]); err != nil {
+			return err
+		}
+	}
+	if filterIndexBH.length > 0 {
+		var handles [40]byte
+		key := []byte(partitionedFilterPrefix + w.filter.Name())
+		n := encodeBlockHandle(handles[:], filterIndexBH)
+		n += encodeBlockHandle(handles[n:], filterPartsBH)
+		if err := w.dataBlock.append(key, handles[:n]); err != nil {
+			return err
+		}
+	}
 	if err := w.dataBlock.finish(); err != nil {
 		return err
 	}
-	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression, nil)
 	if err != nil {
 		w.err = err
 		return w.err
@@ -362,25 +524,36 @@ func (w *Writer) Close() error {
 	if err := w.indexBlock.finish(); err != nil {
 		return err
 	}
-	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression, nil)
 	if err != nil {
 		w.err = err
 		return w.err
 	}
 
 	// Write the table footer.
-	footer := w.scratch[:footerLen]
-	for i := range footer {
-		footer[i] = 0
+	var footer []byte
+	if w.formatVersion == 0 {
+		footer = w.scratch[:footerLen]
+		for i := range footer {
+			footer[i] = 0
+		}
+		n := encodeBlockHandle(footer, metaindexBH)
+		encodeBlockHandle(footer[n:], indexBH)
+		copy(footer[footerLen-len(magic):], magic)
+	} else {
+		footer = make([]byte, extFooterLen)
+		footer[0] = byte(w.checksumType)
+		n := encodeBlockHandle(footer[1:], metaindexBH)
+		encodeBlockHandle(footer[1+n:], indexBH)
+		binary.LittleEndian.PutUint32(footer[extFooterLen-len(extMagic)-4:], uint32(w.formatVersion))
+		copy(footer[extFooterLen-len(extMagic):], extMagic)
 	}
-	n := encodeBlockHandle(footer, metaindexBH)
-	encodeBlockHandle(footer[n:], indexBH)
-	copy(footer[footerLen-len(magic):], magic)
 	if _, err := w.writer.Write(footer); err != nil {
 		w.err = err
 		return w.err
 	}
-	w.offset += footerLen
+	w.checksum = w.checksum.Update(footer)
+	w.offset += uint64(len(footer))
 
 	w.err = errors.New("leveldb/table: writer is closed")
 	return nil
@@ -399,14 +572,18 @@ func NewWriter(f io.Writer, o *opt.Options, pool *util.BufferPool, size int) *Wr
 	bufBytes = bufBytes[:0]
 
 	w := &Writer{
-		writer:          f,
-		cmp:             o.GetComparer(),
-		filter:          o.GetFilter(),
-		compression:     o.GetCompression(),
-		blockSize:       o.GetBlockSize(),
-		comparerScratch: make([]byte, 0),
-		bpool:           pool,
-		dataBlock:       blockWriter{buf: *util.NewBuffer(bufBytes)},
+		writer:           f,
+		cmp:              o.GetComparer(),
+		filter:           o.GetFilter(),
+		compression:      o.GetCompression(),
+		compressionLevel: o.GetCompressionLevel(),
+		blockSize:        o.GetBlockSize(),
+		formatVersion:    o.GetTableFormatVersion(),
+		checksumType:     o.GetBlockChecksum(),
+		partitionSize:    o.GetIndexPartitionSize(),
+		comparerScratch:  make([]byte, 0),
+		bpool:            pool,
+		dataBlock:        blockWriter{buf: *util.NewBuffer(bufBytes)},
 	}
 	// data block
 	w.dataBlock.restartInterval = o.GetBlockRestartInterval()
@@ -419,7 +596,9 @@ func NewWriter(f io.Writer, o *opt.Options, pool *util.BufferPool, size int) *Wr
 	if w.filter != nil {
 		w.filterBlock.generator = w.filter.NewGenerator()
 		w.filterBlock.baseLg = uint(o.GetFilterBaseLg())
-		w.filterBlock.flush(0)
+		if w.partitionSize == 0 {
+			w.filterBlock.flush(0)
+		}
 	}
 	return w
 }
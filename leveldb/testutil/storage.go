@@ -60,6 +60,8 @@ const (
 	typeJournal
 	typeTable
 	typeTemp
+	typeOptions
+	typeQuarantine
 
 	typeCount
 )
@@ -98,6 +100,10 @@ func flattenType(m StorageMode, t storage.FileType) int {
 		return x + typeTable
 	case storage.TypeTemp:
 		return x + typeTemp
+	case storage.TypeOptions:
+		return x + typeOptions
+	case storage.TypeQuarantine:
+		return x + typeQuarantine
 	default:
 		panic("invalid file type")
 	}
@@ -116,6 +122,10 @@ func listFlattenType(m StorageMode, t storage.FileType) []int {
 			ret = append(ret, x+typeTable)
 		case t&storage.TypeTemp != 0:
 			ret = append(ret, x+typeTemp)
+		case t&storage.TypeOptions != 0:
+			ret = append(ret, x+typeOptions)
+		case t&storage.TypeQuarantine != 0:
+			ret = append(ret, x+typeQuarantine)
 		}
 	}
 	switch {
@@ -334,6 +344,11 @@ func (s *Storage) Log(str string) {
 	s.Storage.Log(str)
 }
 
+// Encrypted implements storage.Encrypter.
+func (s *Storage) Encrypted() bool {
+	return storage.IsEncrypted(s.Storage)
+}
+
 func (s *Storage) Lock() (l storage.Locker, err error) {
 	l, err = s.Storage.Lock()
 	if err != nil {
@@ -7,7 +7,7 @@
 package leveldb
 
 import (
-	"fmt"
+	"sort"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -34,6 +34,14 @@ type version struct {
 	cLevel int
 	cScore float64
 
+	// Levels whose score >= 1, ordered by descending score. Used to pick
+	// other levels when the best level is busy with in-progress compactions.
+	cLevels []int
+
+	// Level that level-0 is compacted into. Always 1 unless dynamic level
+	// sizing is enabled.
+	baseLevel int
+
 	cSeek unsafe.Pointer
 
 	closing  bool
@@ -59,7 +67,7 @@ func (v *version) incref() {
 		case v.s.refCh <- &vTask{vid: v.id, files: v.levels, created: time.Now()}:
 			// We can use v.levels directly here since it is immutable.
 		case <-v.s.closeC:
-			v.s.log("reference loop already exist")
+			v.s.log(opt.LogError, "reference loop already exist")
 		}
 	}
 }
@@ -75,7 +83,7 @@ func (v *version) releaseNB() {
 	case v.s.relCh <- &vTask{vid: v.id, files: v.levels, created: time.Now()}:
 		// We can use v.levels directly here since it is immutable.
 	case <-v.s.closeC:
-		v.s.log("reference loop already exist")
+		v.s.log(opt.LogError, "reference loop already exist")
 	}
 
 	v.released = true
@@ -144,12 +152,17 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 	}
 
 	ukey := ikey.ukey()
+	seq, _ := ikey.parseNum()
 	sampleSeeks := !v.s.o.GetDisableSeeksCompaction()
+	pc := ro.GetPerfContext()
 
 	var (
 		tset  *tSet
 		tseek bool
 
+		// Sequence number of the entry found.
+		fseqFound uint64
+
 		// Level-0.
 		zfound bool
 		zseq   uint64
@@ -174,7 +187,31 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 			fikey, fval []byte
 			ferr        error
 		)
-		if noValue {
+		if pc != nil {
+			pc.TablesProbed++
+		}
+
+		// The row cache holds the newest entry of the key in the table,
+		// which is only usable if it is visible at the lookup sequence.
+		var r *row
+		if v.s.tops.rowCache != nil {
+			r, ferr = v.s.tops.findRow(t, ukey, noValue, ro)
+			switch {
+			case ferr == ErrNotFound:
+				return true
+			case ferr != nil:
+				err = ferr
+				return false
+			case r.seq > seq:
+				r = nil
+			}
+		}
+		if r != nil {
+			fikey = makeInternalKey(nil, r.ukey, r.seq, r.kt)
+			if !noValue {
+				fval = append([]byte(nil), r.value...)
+			}
+		} else if noValue {
 			fikey, ferr = v.s.tops.findKey(t, ikey, ro)
 		} else {
 			fikey, fval, ferr = v.s.tops.find(t, ikey, ro)
@@ -200,6 +237,7 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						zval = fval
 					}
 				} else {
+					fseqFound = fseq
 					switch fkt {
 					case keyTypeVal:
 						value = fval
@@ -219,6 +257,7 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		return true
 	}, func(level int) bool {
 		if zfound {
+			fseqFound = zseq
 			switch zkt {
 			case keyTypeVal:
 				value = zval
@@ -237,6 +276,12 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		tcomp = atomic.CompareAndSwapPointer(&v.cSeek, nil, unsafe.Pointer(tset))
 	}
 
+	if err == nil || err == ErrNotFound {
+		if lerr := v.s.checkLostKey(ukey, fseqFound); lerr != nil {
+			value, err = nil, lerr
+		}
+	}
+
 	return
 }
 
@@ -266,7 +311,7 @@ func (v *version) getIterators(slice *util.Range, ro *opt.ReadOptions) (its []it
 				its = append(its, v.s.tops.newIterator(t, slice, ro))
 			}
 		} else if len(tables) != 0 {
-			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro), strict))
+			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro, false), strict))
 		}
 	}
 	return
@@ -357,11 +402,20 @@ func (v *version) computeCompaction() {
 	bestLevel := int(-1)
 	bestScore := float64(-1)
 
+	scores := make([]float64, len(v.levels))
 	statFiles := make([]int, len(v.levels))
-	statSizes := make([]string, len(v.levels))
-	statScore := make([]string, len(v.levels))
+	statSizes := make([]int64, len(v.levels))
 	statTotSize := int64(0)
 
+	var (
+		dynamic = v.s.o.GetCompactionDynamicLevelBytes()
+		targets []int64
+	)
+	v.baseLevel = 1
+	if dynamic {
+		v.baseLevel, targets = v.computeLevelTargets()
+	}
+
 	for level, tables := range v.levels {
 		var score float64
 		size := tables.size()
@@ -378,6 +432,11 @@ func (v *version) computeCompaction() {
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
 			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger())
+		} else if dynamic {
+			// The last non-empty level has no target and never pushed down.
+			if level < len(targets)-1 && targets[level] > 0 {
+				score = float64(size) / float64(targets[level])
+			}
 		} else {
 			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level))
 		}
@@ -386,17 +445,93 @@ func (v *version) computeCompaction() {
 			bestLevel = level
 			bestScore = score
 		}
+		if score >= 1 {
+			v.cLevels = append(v.cLevels, level)
+		}
+		scores[level] = score
 
 		statFiles[level] = len(tables)
-		statSizes[level] = shortenb(size)
-		statScore[level] = fmt.Sprintf("%.2f", score)
+		statSizes[level] = size
 		statTotSize += size
 	}
 
 	v.cLevel = bestLevel
 	v.cScore = bestScore
+	sort.SliceStable(v.cLevels, func(i, j int) bool {
+		return scores[v.cLevels[i]] > scores[v.cLevels[j]]
+	})
+
+	fields := []opt.LogField{lf("files", statFiles), lfSize(statTotSize), lf("level_sizes", statSizes), lf("scores", scores)}
+	if dynamic {
+		fields = append(fields, lf("base_level", v.baseLevel), lf("level_targets", targets))
+	}
+	v.s.log(opt.LogDebug, "version@stat", fields...)
+}
 
-	v.s.logf("version@stat F·%v S·%s%v Sc·%v", statFiles, shortenb(statTotSize), statSizes, statScore)
+// Computes base level and per-level size targets for dynamic level sizing.
+// The targets are derived backwards from the size of the last non-empty
+// level, which is the last element of the returned targets. The base level
+// is moved up until its target doesn't exceed the level-1 size limit, but
+// never below the first non-empty level, so levels above it stay empty.
+func (v *version) computeLevelTargets() (baseLevel int, targets []int64) {
+	var (
+		first, last int
+		lastSize    int64
+	)
+	for level := 1; level < len(v.levels); level++ {
+		if size := v.levels[level].size(); size > 0 {
+			if first == 0 {
+				first = level
+			}
+			last, lastSize = level, size
+		}
+	}
+
+	baseMax := v.s.o.GetCompactionTotalSize(1)
+	if last == 0 {
+		// Nothing beyond level-0 yet, compact it into the last level.
+		last = v.s.o.GetCompactionNumLevels() - 1
+		targets = make([]int64, last+1)
+		targets[last] = baseMax
+		return last, targets
+	}
+
+	// Multiplier between level and level+1.
+	mult := func(level int) float64 {
+		return float64(v.s.o.GetCompactionTotalSize(level+1)) / float64(v.s.o.GetCompactionTotalSize(level))
+	}
+	size := float64(lastSize)
+	for level := last - 1; level >= first; level-- {
+		size /= mult(level)
+	}
+	baseLevel = first
+	for baseLevel > 1 && size > float64(baseMax) {
+		baseLevel--
+		size /= mult(baseLevel)
+	}
+	if size > float64(baseMax) {
+		size = float64(baseMax)
+	}
+
+	targets = make([]int64, last+1)
+	for level := baseLevel; level <= last; level++ {
+		if level > baseLevel {
+			size *= mult(level - 1)
+		}
+		targets[level] = int64(size)
+		if targets[level] < baseMax {
+			targets[level] = baseMax
+		}
+	}
+	return
+}
+
+// Returns the level that compaction of the given level outputs to.
+func (v *version) targetLevel(sourceLevel int) int {
+	if sourceLevel == 0 && v.baseLevel > 1 {
+		return v.baseLevel
+	}
+	return sourceLevel + 1
 }
 
 func (v *version) needCompaction() bool {
@@ -0,0 +1,88 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command reencrypt re-encrypts a DB with a new key, or encrypts an
+// unencrypted DB. The DB must not be in use.
+//
+// The keys file holds one key per line, as an ID and a hex encoded key
+// separated by '='. Empty lines and lines starting with '#' are ignored.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-update/goleveldb/leveldb/storage"
+)
+
+var (
+	dbPath   string
+	keysFile string
+	keyID    string
+)
+
+func init() {
+	flag.StringVar(&dbPath, "db", "", "Path of the DB to re-encrypt")
+	flag.StringVar(&keysFile, "keys", "", "Path of the keys file")
+	flag.StringVar(&keyID, "key", "", "ID of the key to encrypt with")
+}
+
+func readKeys(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string][]byte)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, hexKey, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing '='", path, n)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(hexKey))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		keys[strings.TrimSpace(id)] = key
+	}
+	return keys, sc.Err()
+}
+
+func main() {
+	flag.Parse()
+	if dbPath == "" || keysFile == "" || keyID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := readKeys(keysFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	stor, err := storage.OpenFile(dbPath, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer stor.Close()
+
+	if err := storage.Reencrypt(stor, &storage.StaticKeyProvider{Keys: keys, Current: keyID}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Re-encrypted %s with key %q.\n", dbPath, keyID)
+}